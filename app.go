@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -30,8 +31,12 @@ type App struct {
 	serverPort string
 	mongoURI   string
 	dbName     string
-	jwtSecret  string
+	keysDir    string
 	jwtClient  string
+	tokens     services.TokenSettings
+	revocation time.Duration
+	search     services.SearchLimits
+	statuses   services.StatusTransitions
 	router     *gin.Engine
 	logger     *logrus.Logger
 	mongo      *mongo.Client
@@ -43,7 +48,7 @@ func RunApp() *App {
 		log.Fatalf("failed to setup logger: %v", err)
 	}
 
-	cfg := loadEnvConfig()
+	cfg := loadEnvConfig(logger)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -65,15 +70,21 @@ func RunApp() *App {
 	}
 
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.Use(utils.Recovery(logger))
 	router.Use(utils.GinLogger(logger))
+	router.Use(middleware.ErrorHandler())
+	router.NoRoute(utils.NoRoute)
 
 	return &App{
 		serverPort: cfg.serverPort,
 		mongoURI:   cfg.mongoURI,
 		dbName:     cfg.dbName,
-		jwtSecret:  cfg.jwtSecret,
+		keysDir:    cfg.keysDir,
 		jwtClient:  cfg.jwtClient,
+		tokens:     cfg.tokens,
+		revocation: cfg.revocation,
+		search:     cfg.search,
+		statuses:   cfg.statuses,
 		router:     router,
 		logger:     logger,
 		mongo:      client,
@@ -138,22 +149,82 @@ type envConfig struct {
 	dbName     string
 	dbUser     string
 	dbPwd      string
-	jwtSecret  string
+	keysDir    string
 	jwtClient  string
+	tokens     services.TokenSettings
+	revocation time.Duration
+	search     services.SearchLimits
+	statuses   services.StatusTransitions
 }
 
-func loadEnvConfig() envConfig {
+func loadEnvConfig(logger *logrus.Logger) envConfig {
 	return envConfig{
 		serverPort: os.Getenv("SERVER_PORT"),
 		mongoURI:   os.Getenv("DB_URI"),
 		dbName:     os.Getenv("DB_NAME"),
 		dbUser:     os.Getenv("DB_USER"),
 		dbPwd:      os.Getenv("DB_PWD"),
-		jwtSecret:  os.Getenv("JWT_SECRET_KEY"),
+		keysDir:    envString("JWT_KEYS_DIR", "keys"),
 		jwtClient:  os.Getenv("JWT_CLIENT_CODE"),
+		tokens: services.TokenSettings{
+			Issuer:    envString("JWT_ISSUER", "fhir-api-"+os.Getenv("JWT_CLIENT_CODE")),
+			Audience:  envString("JWT_AUDIENCE", "fhir-api-"+os.Getenv("JWT_CLIENT_CODE")),
+			TokenURL:  os.Getenv("JWT_TOKEN_URL"),
+			Lifetime:  envDuration("JWT_TOKEN_TTL", 24*time.Hour),
+			ClockSkew: envDuration("JWT_CLOCK_SKEW", time.Minute),
+		},
+		revocation: envDuration("REVOCATION_REFRESH", 30*time.Second),
+		search: services.SearchLimits{
+			DefaultCount: envInt("SEARCH_DEFAULT_COUNT", 20),
+			MaxCount:     envInt("SEARCH_MAX_COUNT", 100),
+			MaxInclude:   envInt("SEARCH_MAX_INCLUDE", 1000),
+		},
+		statuses: envStatusTransitions(logger, "ENCOUNTER_STATUS_TRANSITIONS"),
 	}
 }
 
+// envString lê uma variável de ambiente, usando def quando ausente.
+func envString(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envDuration lê uma duração (ex.: 90s, 1h), usando def quando ausente ou inválida.
+func envDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// envInt lê uma variável de ambiente inteira, usando def quando ausente ou inválida.
+func envInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// envStatusTransitions lê o grafo de transições de status de encounter, usando o
+// padrão quando a variável está ausente ou inválida.
+func envStatusTransitions(logger *logrus.Logger, name string) services.StatusTransitions {
+	value := os.Getenv(name)
+	if value == "" {
+		return services.DefaultStatusTransitions
+	}
+
+	transitions, err := services.ParseStatusTransitions(value)
+	if err != nil {
+		logger.WithError(err).Warnf("%s inválido, usando o grafo de transições padrão", name)
+		return services.DefaultStatusTransitions
+	}
+	return transitions
+}
+
 // @title Go API
 // @version 1.0
 // @description API para recursos FHIR
@@ -162,23 +233,116 @@ func loadEnvConfig() envConfig {
 func (a *App) Run() error {
 	db := a.mongo.Database(a.dbName)
 
+	keys, err := services.LoadKeyStore(a.keysDir)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	clientService := services.NewClientService(db, a.logger)
+	clientCtx, cancelClients := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := clientService.EnsureIndexes(clientCtx); err != nil {
+		a.logger.WithError(err).Warn("servidor iniciado sem os índices de clients")
+	}
+	cancelClients()
+
+	// A lista de revogação é relida periodicamente para incorporar revogações
+	// feitas por outras instâncias; watchCtx é cancelado no desligamento.
+	revocations := services.NewRevocationList(db, a.logger)
+	revocationCtx, cancelRevocations := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := revocations.EnsureIndexes(revocationCtx); err != nil {
+		a.logger.WithError(err).Warn("servidor iniciado sem os índices de revoked_tokens")
+	}
+	if err := revocations.Refresh(revocationCtx); err != nil {
+		a.logger.WithError(err).Warn("servidor iniciado sem a lista de tokens revogados")
+	}
+	cancelRevocations()
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go revocations.Watch(watchCtx, a.revocation)
+
+	verifier := services.NewTokenVerifier(keys, a.jwtClient, a.tokens, revocations)
 	authService := services.NewAuthService(
-		a.jwtSecret,
-		a.jwtClient,
-		24*time.Hour,
+		keys,
+		verifier,
+		clientService,
+		revocations,
+		a.logger,
 	)
+	authController := controllers.NewAuthController(authService)
 
-	encounterService := services.NewEncounterService(db, a.logger)
+	encounterService := services.NewEncounterService(db, a.logger, a.search, a.statuses)
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := encounterService.EnsureSchema(indexCtx); err != nil {
+		a.logger.WithError(err).Warn("servidor iniciado sem o validator de encounters")
+	}
+	if err := encounterService.EnsureIndexes(indexCtx); err != nil {
+		a.logger.WithError(err).Warn("servidor iniciado sem os índices de busca de encounters")
+	}
+	cancelIndexes()
 	encounterController := controllers.NewEncounterController(encounterService)
 
-	patientService := services.NewPatientService(db, a.logger)
+	patientService := services.NewPatientService(db, a.logger, a.search)
 	patientController := controllers.NewPatientController(patientService)
 
-	practitionerservice := services.NewPractitionerService(db, a.logger)
+	practitionerservice := services.NewPractitionerService(db, a.logger, a.search)
 	practitionerController := controllers.NewPractitionerController(practitionerservice)
 
+	historyService := services.NewHistoryService(db, a.logger, a.search)
+	historyCtx, cancelHistory := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := historyService.EnsureIndexes(historyCtx); err != nil {
+		a.logger.WithError(err).Warn("servidor iniciado sem os índices de history")
+	}
+	cancelHistory()
+	historyController := controllers.NewHistoryController(historyService)
+
+	bundleService := services.NewBundleService(db, a.logger, patientService, practitionerservice, encounterService)
+	bundleController := controllers.NewBundleController(bundleService)
+
+	routes := []controllers.Route{
+		{Method: http.MethodPost, Path: "/", Interaction: "transaction", Also: []string{"batch"}, Handler: bundleController.ProcessBundle},
+		{Method: http.MethodGet, Path: "/Patient", ResourceType: "Patient", Interaction: "search-type", SearchParams: patientService.SearchParams(), SearchRevInclude: patientService.SearchRevInclude(), Handler: patientController.SearchPatients},
+		{Method: http.MethodGet, Path: "/Patient/:id", ResourceType: "Patient", Interaction: "read", Handler: patientController.GetPatient},
+		{Method: http.MethodPost, Path: "/Patient", ResourceType: "Patient", Interaction: "create", Conditional: true, Handler: patientController.CreatePatient},
+		{Method: http.MethodPut, Path: "/Patient", ResourceType: "Patient", Interaction: "update", Conditional: true, Handler: patientController.ConditionalUpdatePatient},
+		{Method: http.MethodDelete, Path: "/Patient", ResourceType: "Patient", Interaction: "delete", Conditional: true, Handler: patientController.ConditionalDeletePatient},
+		{Method: http.MethodPut, Path: "/Patient/:id", ResourceType: "Patient", Interaction: "update", Handler: patientController.UpdatePatient},
+		{Method: http.MethodPatch, Path: "/Patient/:id", ResourceType: "Patient", Interaction: "patch", Handler: patientController.PatchPatient},
+		{Method: http.MethodDelete, Path: "/Patient/:id", ResourceType: "Patient", Interaction: "delete", Handler: patientController.DeletePatient},
+		{Method: http.MethodGet, Path: "/Patient/:id/_history/:vid", ResourceType: "Patient", Interaction: "vread", Handler: historyController.ReadVersion("Patient")},
+		{Method: http.MethodGet, Path: "/Patient/:id/_history", ResourceType: "Patient", Interaction: "history-instance", Handler: historyController.InstanceHistory("Patient")},
+		{Method: http.MethodGet, Path: "/Patient/_history", ResourceType: "Patient", Interaction: "history-type", Handler: historyController.TypeHistory("Patient")},
+		{Method: http.MethodGet, Path: "/Practitioner", ResourceType: "Practitioner", Interaction: "search-type", SearchParams: practitionerservice.SearchParams(), Handler: practitionerController.SearchPractitioners},
+		{Method: http.MethodGet, Path: "/Practitioner/:id", ResourceType: "Practitioner", Interaction: "read", Handler: practitionerController.GetPractitioner},
+		{Method: http.MethodPost, Path: "/Practitioner", ResourceType: "Practitioner", Interaction: "create", Conditional: true, Handler: practitionerController.CreatePractitioner},
+		{Method: http.MethodPut, Path: "/Practitioner", ResourceType: "Practitioner", Interaction: "update", Conditional: true, Handler: practitionerController.ConditionalUpdatePractitioner},
+		{Method: http.MethodDelete, Path: "/Practitioner", ResourceType: "Practitioner", Interaction: "delete", Conditional: true, Handler: practitionerController.ConditionalDeletePractitioner},
+		{Method: http.MethodPut, Path: "/Practitioner/:id", ResourceType: "Practitioner", Interaction: "update", Handler: practitionerController.UpdatePractitioner},
+		{Method: http.MethodPatch, Path: "/Practitioner/:id", ResourceType: "Practitioner", Interaction: "patch", Handler: practitionerController.PatchPractitioner},
+		{Method: http.MethodDelete, Path: "/Practitioner/:id", ResourceType: "Practitioner", Interaction: "delete", Handler: practitionerController.DeletePractitioner},
+		{Method: http.MethodGet, Path: "/Practitioner/:id/_history/:vid", ResourceType: "Practitioner", Interaction: "vread", Handler: historyController.ReadVersion("Practitioner")},
+		{Method: http.MethodGet, Path: "/Practitioner/:id/_history", ResourceType: "Practitioner", Interaction: "history-instance", Handler: historyController.InstanceHistory("Practitioner")},
+		{Method: http.MethodGet, Path: "/Practitioner/_history", ResourceType: "Practitioner", Interaction: "history-type", Handler: historyController.TypeHistory("Practitioner")},
+		{Method: http.MethodGet, Path: "/Encounter", ResourceType: "Encounter", Interaction: "search-type", SearchParams: encounterService.SearchParams(), SearchInclude: encounterService.SearchInclude(), Handler: encounterController.SearchEncounters},
+		{Method: http.MethodGet, Path: "/Encounter/:id", ResourceType: "Encounter", Interaction: "read", Handler: encounterController.GetEncounter},
+		{Method: http.MethodPost, Path: "/Encounter", ResourceType: "Encounter", Interaction: "create", Conditional: true, Handler: encounterController.CreateEncounter},
+		{Method: http.MethodPut, Path: "/Encounter", ResourceType: "Encounter", Interaction: "update", Conditional: true, Handler: encounterController.ConditionalUpdateEncounter},
+		{Method: http.MethodPut, Path: "/Encounter/:id", ResourceType: "Encounter", Interaction: "update", Handler: encounterController.UpdateEncounter},
+		{Method: http.MethodPatch, Path: "/Encounter/:id", ResourceType: "Encounter", Interaction: "patch", Handler: encounterController.PatchEncounter},
+		{Method: http.MethodGet, Path: "/Encounter/:id/_history/:vid", ResourceType: "Encounter", Interaction: "vread", Handler: historyController.ReadVersion("Encounter")},
+		{Method: http.MethodGet, Path: "/Encounter/:id/_history", ResourceType: "Encounter", Interaction: "history-instance", Handler: historyController.InstanceHistory("Encounter")},
+		{Method: http.MethodGet, Path: "/Encounter/_history", ResourceType: "Encounter", Interaction: "history-type", Handler: historyController.TypeHistory("Encounter")},
+
+		// Rotas legadas, mantidas por compatibilidade e não anunciadas no CapabilityStatement
+		{Method: http.MethodGet, Path: "/patients/:id", ResourceType: "Patient", Interaction: "read", Unlisted: true, Handler: patientController.GetPatient},
+		{Method: http.MethodGet, Path: "/practitioners/:id", ResourceType: "Practitioner", Interaction: "read", Unlisted: true, Handler: practitionerController.GetPractitioner},
+		{Method: http.MethodGet, Path: "/encounters/:id", ResourceType: "Encounter", Interaction: "read", Unlisted: true, Handler: encounterController.GetEncounter},
+		{Method: http.MethodPost, Path: "/encounters/:id/review-request", ResourceType: "Encounter", Interaction: "update", Unlisted: true, Handler: encounterController.UpdateEncounterStatus},
+	}
+
+	metadataController := controllers.NewMetadataController(routes, middleware.AuthSecurity(), a.tokens.TokenURL)
+
 	router := a.router
-	api := router.Group("/api/v1")
+	api := router.Group(controllers.APIBasePath)
 	{
 
 		// Rota do Swagger
@@ -192,25 +356,19 @@ func (a *App) Run() error {
 			c.JSON(http.StatusOK, gin.H{"status": "ok"})
 		})
 
-		api.POST("/auth/token", func(c *gin.Context) {
-			token, err := authService.GenerateToken()
-			if err != nil {
-				a.logger.WithError(err).Error("failed to generate token")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
-				return
-			}
-			c.JSON(http.StatusOK, gin.H{"token": token})
-		})
+		api.GET("/metadata", metadataController.GetMetadata)
+		api.GET("/.well-known/smart-configuration", metadataController.GetSmartConfiguration)
+		api.GET("/.well-known/jwks.json", authController.JWKS)
 
-		protected := api.Group("")
-		protected.Use(middleware.AuthMiddleware(a.jwtSecret, a.jwtClient))
-		{
+		api.POST("/auth/token", authController.Token)
+		api.POST("/auth/revoke", authController.Revoke)
+		api.POST("/auth/introspect", authController.Introspect)
 
-			protected.GET("/patients/:id", patientController.GetPatient)
-			protected.GET("/practitioners/:id", practitionerController.GetPractitioner)
-			protected.GET("/encounters/:id", encounterController.GetEncounter)
-			protected.POST("/encounters/:id/review-request", encounterController.UpdateEncounterStatus)
-		}
+		protected := api.Group("")
+		protected.Use(middleware.AuthMiddleware(verifier))
+		controllers.RegisterRoutes(protected, routes, func(route controllers.Route) gin.HandlerFunc {
+			return middleware.RequireScope(route.ResourceType, route.Interaction)
+		})
 	}
 
 	// Configurar e iniciar servidor (mesmo conteúdo anterior)
@@ -222,6 +380,19 @@ func (a *App) Run() error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP relê o diretório de chaves depois de um keys generate/activate/retire.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := keys.Reload(); err != nil {
+				a.logger.WithError(err).Error("falha ao recarregar as chaves de assinatura")
+				continue
+			}
+			a.logger.Info("chaves de assinatura recarregadas")
+		}
+	}()
+
 	go func() {
 		a.logger.Infof("Server is running on port %s", a.serverPort)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -231,6 +402,7 @@ func (a *App) Run() error {
 
 	<-quit
 	a.logger.Info("Shutting down server...")
+	stopWatch()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
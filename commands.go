@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"fhir-api/services"
+)
+
+// runCommand executa um comando administrativo em vez de subir o servidor, com
+// a mesma configuração de ambiente. Uso:
+//
+//	fhir-api register-client -id <client_id> -scope "<scopes>" [-name <nome>] [-public-key <arquivo.pem>]
+//	fhir-api keys list
+//	fhir-api keys generate [-alg RS256|ES256]
+//	fhir-api keys activate -kid <kid>
+//	fhir-api keys roll [-alg RS256|ES256]
+//	fhir-api keys retire -kid <kid>
+func runCommand(args []string) error {
+	switch args[0] {
+	case "register-client":
+		app := RunApp()
+		defer app.mongo.Disconnect(context.Background())
+		return app.registerClient(args[1:])
+	case "keys":
+		return keysCommand(envString("JWT_KEYS_DIR", "keys"), args[1:])
+	default:
+		return fmt.Errorf("comando desconhecido: %s", args[0])
+	}
+}
+
+// keysCommand administra as chaves de assinatura de tokens no diretório
+// JWT_KEYS_DIR. Uma rotação segura é: keys generate, esperar os verificadores
+// obterem o novo JWKS, keys activate e, depois que os tokens antigos expirarem,
+// keys retire. keys roll faz as duas primeiras etapas de uma vez. Servidores em
+// execução relêem o diretório ao receber SIGHUP.
+func keysCommand(dir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("uso: keys list|generate|activate|roll|retire")
+	}
+
+	flags := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	alg := flags.String("alg", "RS256", "algoritmo da nova chave: RS256 ou ES256")
+	kid := flags.String("kid", "", "kid da chave")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store := services.NewKeyStore(dir)
+	if err := store.Reload(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		for _, key := range store.Keys() {
+			status := ""
+			if key.Active {
+				status = " (ativa)"
+			}
+			fmt.Printf("%s %s%s\n", key.Kid, key.Alg, status)
+		}
+		return nil
+	case "generate":
+		generated, err := store.Generate(*alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("chave %s gerada; ative com: keys activate -kid %s\n", generated, generated)
+		return nil
+	case "roll":
+		generated, err := store.Roll(*alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("chave %s gerada e ativada\n", generated)
+		return nil
+	case "activate":
+		if err := store.Activate(*kid); err != nil {
+			return err
+		}
+		fmt.Printf("chave %s ativada\n", *kid)
+		return nil
+	case "retire":
+		if err := store.Retire(*kid); err != nil {
+			return err
+		}
+		fmt.Printf("chave %s removida\n", *kid)
+		return nil
+	default:
+		return fmt.Errorf("subcomando desconhecido: keys %s", args[0])
+	}
+}
+
+// registerClient registra um cliente OAuth2 e imprime o client_secret gerado,
+// que não é armazenado em claro e não pode ser recuperado depois.
+func (a *App) registerClient(args []string) error {
+	flags := flag.NewFlagSet("register-client", flag.ContinueOnError)
+	clientID := flags.String("id", "", "client_id do cliente")
+	name := flags.String("name", "", "nome descritivo do cliente")
+	scope := flags.String("scope", "", "scopes permitidos, separados por espaço")
+	keyFile := flags.String("public-key", "", "arquivo PEM com a chave pública para private_key_jwt")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var publicKey string
+	if *keyFile != "" {
+		pem, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		publicKey = string(pem)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientService := services.NewClientService(a.mongo.Database(a.dbName), a.logger)
+	if err := clientService.EnsureIndexes(ctx); err != nil {
+		return err
+	}
+	secret, err := clientService.RegisterClient(ctx, *clientID, *name, strings.Fields(*scope), publicKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("client_id=%s\nclient_secret=%s\n", *clientID, secret)
+	return nil
+}
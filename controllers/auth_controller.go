@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"fhir-api/models"
+	"fhir-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthController expõe o endpoint de token OAuth2.
+type AuthController struct {
+	service *services.AuthService
+}
+
+func NewAuthController(service *services.AuthService) *AuthController {
+	return &AuthController{service: service}
+}
+
+// Token godoc
+// @Summary Emite um access token
+// @Description Grant client_credentials do OAuth2. O cliente se autentica com client_id e client_secret (no corpo ou em Authorization: Basic) ou com private_key_jwt (client_assertion de uso único assinado com a chave registrada, aud igual a JWT_TOKEN_URL ou ao issuer, validade de até 5 minutos)
+// @Tags Auth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "client_credentials"
+// @Param client_id formData string false "Identificador do cliente"
+// @Param client_secret formData string false "Segredo do cliente"
+// @Param client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param client_assertion formData string false "JWT assinado pelo cliente (RS256 ou ES256)"
+// @Param scope formData string false "Scopes pedidos, separados por espaço; padrão: todos os do cliente"
+// @Success 200 {object} models.TokenResponse
+// @Failure 400 {object} models.OAuthError "invalid_request, unsupported_grant_type ou invalid_scope"
+// @Failure 401 {object} models.OAuthError "invalid_client"
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /auth/token [post]
+func (c *AuthController) Token(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	creds, basic, err := clientCredentials(ctx)
+	if err != nil {
+		respondOAuthError(ctx, err, basic)
+		return
+	}
+
+	token, err := c.service.IssueToken(ctx.Request.Context(), services.TokenRequest{
+		ClientCredentials: creds,
+		GrantType:         ctx.PostForm("grant_type"),
+		Scope:             ctx.PostForm("scope"),
+	})
+	if err != nil {
+		respondOAuthError(ctx, err, basic)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, token)
+}
+
+// Revoke godoc
+// @Summary Revoga um access token
+// @Description Revogação RFC 7009. O cliente se autentica como em /auth/token e só revoga tokens emitidos para ele; tokens desconhecidos, expirados ou de outro cliente também respondem 200
+// @Tags Auth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "Access token a revogar"
+// @Param token_type_hint formData string false "access_token"
+// @Success 200
+// @Failure 400 {object} models.OAuthError "invalid_request ou unsupported_token_type"
+// @Failure 401 {object} models.OAuthError "invalid_client"
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /auth/revoke [post]
+func (c *AuthController) Revoke(ctx *gin.Context) {
+	creds, basic, err := clientCredentials(ctx)
+	if err != nil {
+		respondOAuthError(ctx, err, basic)
+		return
+	}
+
+	if err := c.service.Revoke(ctx.Request.Context(), creds, ctx.PostForm("token"), ctx.PostForm("token_type_hint")); err != nil {
+		respondOAuthError(ctx, err, basic)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// Introspect godoc
+// @Summary Inspeciona um access token
+// @Description Introspecção RFC 7662 para clientes autenticados. Tokens inválidos, expirados ou revogados respondem apenas {"active": false}
+// @Tags Auth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param token formData string true "Access token a inspecionar"
+// @Success 200 {object} models.Introspection
+// @Failure 400 {object} models.OAuthError "invalid_request"
+// @Failure 401 {object} models.OAuthError "invalid_client"
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /auth/introspect [post]
+func (c *AuthController) Introspect(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+
+	creds, basic, err := clientCredentials(ctx)
+	if err != nil {
+		respondOAuthError(ctx, err, basic)
+		return
+	}
+
+	introspection, err := c.service.Introspect(ctx.Request.Context(), creds, ctx.PostForm("token"))
+	if err != nil {
+		respondOAuthError(ctx, err, basic)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, introspection)
+}
+
+// clientCredentials lê a autenticação do cliente de um POST form-urlencoded aos
+// endpoints /auth/*, no corpo ou em Authorization: Basic, e indica se o Basic
+// foi usado.
+func clientCredentials(ctx *gin.Context) (services.ClientCredentials, bool, error) {
+	if ctx.ContentType() != "application/x-www-form-urlencoded" {
+		return services.ClientCredentials{}, false, models.NewOAuthError("invalid_request", "body must be application/x-www-form-urlencoded", http.StatusBadRequest)
+	}
+
+	creds := services.ClientCredentials{
+		ClientID:            ctx.PostForm("client_id"),
+		ClientSecret:        ctx.PostForm("client_secret"),
+		ClientAssertionType: ctx.PostForm("client_assertion_type"),
+		ClientAssertion:     ctx.PostForm("client_assertion"),
+	}
+
+	// client_secret_basic: as credenciais do header são form-urlencoded (RFC 6749, seção 2.3.1).
+	basicID, basicSecret, basic := ctx.Request.BasicAuth()
+	if basic {
+		id, idErr := url.QueryUnescape(basicID)
+		secret, secretErr := url.QueryUnescape(basicSecret)
+		if idErr != nil || secretErr != nil || creds.ClientSecret != "" || (creds.ClientID != "" && creds.ClientID != id) {
+			return creds, basic, models.NewOAuthError("invalid_request", "conflicting or malformed client credentials", http.StatusBadRequest)
+		}
+		creds.ClientID, creds.ClientSecret = id, secret
+	}
+	return creds, basic, nil
+}
+
+// JWKS godoc
+// @Summary Chaves públicas de verificação de tokens
+// @Description JWK Set com todas as chaves aceitas na verificação, incluindo as que estão saindo de uso durante uma rotação; a que assina vem primeiro
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} models.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (c *AuthController) JWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, c.service.JWKS())
+}
+
+// respondOAuthError responde no formato da RFC 6749. Erros que não são do
+// protocolo, como falhas de banco, seguem para o ErrorHandler.
+func respondOAuthError(ctx *gin.Context, err error, basic bool) {
+	var oauthErr *models.OAuthError
+	if !errors.As(err, &oauthErr) {
+		ctx.Error(err)
+		return
+	}
+
+	if oauthErr.StatusCode == http.StatusUnauthorized && basic {
+		ctx.Header("WWW-Authenticate", `Basic realm="fhir-api"`)
+	}
+	ctx.JSON(oauthErr.StatusCode, oauthErr)
+}
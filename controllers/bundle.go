@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"fhir-api/fhir"
+	"fhir-api/services"
+	"fhir-api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchsetBundle monta o Bundle searchset devolvido pelas rotas de busca,
+// aplicando _elements/_summary a cada entrada. Os recursos trazidos por
+// _include/_revinclude vêm depois, com search.mode=include e sem subset.
+func searchsetBundle(ctx *gin.Context, result *services.SearchResult, subset *fhir.Subset) *fhir.Bundle {
+	base := baseURL(ctx)
+	total := result.Total
+
+	bundle := &fhir.Bundle{
+		ResourceType: "Bundle",
+		Type:         "searchset",
+		Total:        &total,
+		Link:         []fhir.BundleLink{{Relation: "self", URL: requestURL(ctx)}},
+	}
+
+	if result.Next != "" {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{Relation: "next", URL: pageURL(ctx, result.Next)})
+	}
+	if result.Previous != "" {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{Relation: "previous", URL: pageURL(ctx, result.Previous)})
+	}
+
+	for _, resource := range result.Resources {
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+			FullURL:  fullURL(base, resource),
+			Resource: subset.Apply(resource),
+			Search:   &fhir.BundleEntrySearch{Mode: "match"},
+		})
+	}
+	bundle.Entry = append(bundle.Entry, includedEntries(base, result.Included)...)
+
+	return bundle
+}
+
+func fullURL(base string, resource fhir.Resource) string {
+	return base + "/" + resource.ResourceTypeName() + "/" + resource.ResourceID()
+}
+
+// pageURL repete a busca atual trocando apenas o cursor de paginação.
+func pageURL(ctx *gin.Context, cursor string) string {
+	query := ctx.Request.URL.Query()
+	query.Set("_cursor", cursor)
+	return baseURL(ctx) + strings.TrimPrefix(ctx.Request.URL.Path, APIBasePath) + "?" + query.Encode()
+}
+
+// historyStatus é o response.status de cada entrada do histórico conforme o método que a gerou.
+var historyStatus = map[string]string{
+	http.MethodPost:   "201 Created",
+	http.MethodPut:    "200 OK",
+	http.MethodPatch:  "200 OK",
+	http.MethodDelete: "204 No Content",
+}
+
+// historyBundle monta o Bundle history de _history, da versão mais recente para
+// a mais antiga. Remoções aparecem sem resource.
+func historyBundle(ctx *gin.Context, result *services.HistoryResult) *fhir.Bundle {
+	base := baseURL(ctx)
+	total := result.Total
+
+	bundle := &fhir.Bundle{
+		ResourceType: "Bundle",
+		Type:         "history",
+		Total:        &total,
+		Link:         []fhir.BundleLink{{Relation: "self", URL: requestURL(ctx)}},
+	}
+
+	if result.Next != "" {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{Relation: "next", URL: pageURL(ctx, result.Next)})
+	}
+	if result.Previous != "" {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{Relation: "previous", URL: pageURL(ctx, result.Previous)})
+	}
+
+	for _, history := range result.Entries {
+		reference := history.ResourceType + "/" + history.ID
+		entry := fhir.BundleEntry{
+			FullURL: base + "/" + reference,
+			Request: &fhir.BundleEntryRequest{Method: history.Method, URL: reference},
+			Response: &fhir.BundleEntryResponse{
+				Status:       historyStatus[history.Method],
+				Etag:         fhir.WeakETag(history.VersionID),
+				LastModified: history.LastUpdated,
+			},
+		}
+		if history.Method == http.MethodPost {
+			entry.Request.URL = history.ResourceType
+		}
+		if history.Resource != nil {
+			entry.Resource = history.Resource
+		}
+		bundle.Entry = append(bundle.Entry, entry)
+	}
+
+	return bundle
+}
+
+// responseBundle monta o batch-response ou transaction-response, com uma entrada
+// por entrada recebida e na mesma ordem. Entradas de batch que falharam trazem
+// o OperationOutcome em response.outcome.
+func responseBundle(ctx *gin.Context, result *services.BundleResult) *fhir.Bundle {
+	base := baseURL(ctx)
+
+	bundle := &fhir.Bundle{
+		ResourceType: "Bundle",
+		Type:         result.Type + "-response",
+		Link:         []fhir.BundleLink{{Relation: "self", URL: requestURL(ctx)}},
+	}
+
+	for _, item := range result.Entries {
+		if item.Err != nil {
+			status, outcome := utils.OperationOutcomeFromError(item.Err)
+			bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+				Response: &fhir.BundleEntryResponse{Status: statusLine(status), Outcome: outcome},
+			})
+			continue
+		}
+
+		entry := fhir.BundleEntry{Response: &fhir.BundleEntryResponse{Status: statusLine(item.Status)}}
+		switch {
+		case item.Resource != nil:
+			entry.FullURL = fullURL(base, item.Resource)
+			entry.Resource = item.Resource
+			if meta := item.Resource.ResourceMeta(); meta != nil {
+				entry.Response.Etag = fhir.WeakETag(meta.VersionID)
+				entry.Response.LastModified = meta.LastUpdated
+			}
+			if item.Status == http.StatusCreated {
+				entry.Response.Location = versionedURL(base, item.Resource)
+			}
+		case item.Search != nil:
+			entry.Resource = nestedSearchset(base, item.Search)
+		}
+		bundle.Entry = append(bundle.Entry, entry)
+	}
+
+	return bundle
+}
+
+// nestedSearchset é o searchset de um GET de busca dentro de um batch, sem os
+// links de paginação da requisição corrente.
+func nestedSearchset(base string, result *services.SearchResult) *fhir.Bundle {
+	total := result.Total
+	bundle := &fhir.Bundle{ResourceType: "Bundle", Type: "searchset", Total: &total}
+	for _, resource := range result.Resources {
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+			FullURL:  fullURL(base, resource),
+			Resource: resource,
+			Search:   &fhir.BundleEntrySearch{Mode: "match"},
+		})
+	}
+	bundle.Entry = append(bundle.Entry, includedEntries(base, result.Included)...)
+	return bundle
+}
+
+func includedEntries(base string, included []fhir.Resource) []fhir.BundleEntry {
+	entries := make([]fhir.BundleEntry, 0, len(included))
+	for _, resource := range included {
+		entries = append(entries, fhir.BundleEntry{
+			FullURL:  fullURL(base, resource),
+			Resource: resource,
+			Search:   &fhir.BundleEntrySearch{Mode: "include"},
+		})
+	}
+	return entries
+}
+
+func statusLine(status int) string {
+	return fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+	"fhir-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BundleController atende o POST na raiz do servidor com Bundles batch e
+// transaction.
+type BundleController struct {
+	service *services.BundleService
+}
+
+func NewBundleController(service *services.BundleService) *BundleController {
+	return &BundleController{service: service}
+}
+
+// ProcessBundle godoc
+// @Summary Processa um Bundle batch ou transaction
+// @Description Executa as entradas do Bundle. Em transaction, referências urn:uuid entre entradas são resolvidas e todas as entradas são gravadas numa única transação do MongoDB; qualquer falha desfaz o Bundle inteiro.
+// @Tags system
+// @Accept json
+// @Produce json
+// @Param request body fhir.Bundle true "Bundle do tipo batch ou transaction"
+// @Success 200 {object} fhir.Bundle "Bundle batch-response ou transaction-response"
+// @Failure 400 {object} fhir.OperationOutcome "Bundle ou entrada inválida"
+// @Failure 404 {object} fhir.OperationOutcome "Recurso de uma entrada não encontrado"
+// @Failure 409 {object} fhir.OperationOutcome "Recurso alterado concorrentemente"
+// @Failure 412 {object} fhir.OperationOutcome "Versão informada em ifMatch desatualizada"
+// @Failure 422 {object} fhir.OperationOutcome "Entrada violou uma regra de negócio"
+// @Failure 500 {object} fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Failure 501 {object} fhir.OperationOutcome "MongoDB sem suporte a transações"
+// @Router / [post]
+func (c *BundleController) ProcessBundle(ctx *gin.Context) {
+	var bundle fhir.Bundle
+	if err := ctx.ShouldBindJSON(&bundle); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	result, err := c.service.ProcessBundle(ctx.Request.Context(), &bundle)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, responseBundle(ctx, result))
+}
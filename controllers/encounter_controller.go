@@ -2,8 +2,8 @@ package controllers
 
 import (
 	"net/http"
-	"strings"
 
+	"fhir-api/fhir"
 	"fhir-api/models"
 	"fhir-api/services"
 
@@ -18,86 +18,278 @@ func NewEncounterController(service *services.EncounterService) *EncounterContro
 	return &EncounterController{service: service}
 }
 
-type GetEncounterRequest struct {
-	Fields string `form:"fields" binding:"required"`
-}
-
 // GetEncounter godoc
 // @Summary Get encounter by ID
-// @Description Retrieves a specific encounter with optional field filtering
+// @Description Retrieves a specific encounter, optionally subsetted with _elements or _summary
 // @Tags Encounters
 // @Accept json
 // @Produce json
 // @Param id path string true "Encounter ID"
-// @Param fields query string false "Comma-separated list of fields to return (fhirId,fullUrl,status,class,period,practitionerId,patientId)"
-// @Success 200 {object} models.Encounter
-// @Failure 400 {object} map[string]string "invalid field specified"
-// @Failure 404 {object} map[string]string "Encounter not found"
-// @Router /encounters/{id} [get]
+// @Param _elements query string false "Comma-separated list of elements to return (mandatory elements are always kept)"
+// @Param _summary query string false "true, text, data or false"
+// @Param If-None-Match header string false "ETag known by the client; answers 304 when it is the current version"
+// @Success 200 {object} fhir.Encounter
+// @Success 304
+// @Failure 400 {object} fhir.OperationOutcome "Invalid id or subsetting parameter"
+// @Failure 404 {object} fhir.OperationOutcome "Encounter not found"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
+// @Router /Encounter/{id} [get]
 func (c *EncounterController) GetEncounter(ctx *gin.Context) {
-	id := ctx.Param("id")
+	subset, err := parseReadSubset(ctx, "Encounter")
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
 
-	var req GetEncounterRequest
-	if err := ctx.ShouldBindQuery(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "fields parameter is required"})
+	encounter, err := c.service.GetEncounter(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.Error(err)
 		return
 	}
 
-	fieldsParam := req.Fields
-	fields := strings.Split(fieldsParam, ",")
-	for i, field := range fields {
-		fields[i] = strings.TrimSpace(field)
+	respondResource(ctx, encounter, subset.Apply(encounter))
+}
+
+// SearchEncounters godoc
+// @Summary Search encounters
+// @Description FHIR search on encounters, returning a searchset Bundle
+// @Tags Encounters
+// @Produce json
+// @Param patient query string false "Patient reference (Patient/id)"
+// @Param practitioner query string false "Practitioner reference (Practitioner/id)"
+// @Param status query string false "Comma-separated statuses, combined with OR"
+// @Param class query string false "Encounter class code or display"
+// @Param date query string false "Date within the encounter period, with prefix (eq, lt, ge...)"
+// @Param _sort query string false "Comma-separated sort fields, '-' for descending (e.g. -date)"
+// @Param _count query int false "Page size (capped by SEARCH_MAX_COUNT)"
+// @Param _cursor query string false "Opaque cursor taken from the next/previous links"
+// @Param _elements query string false "Comma-separated list of elements to return in each entry"
+// @Param _summary query string false "true, text, data, count or false"
+// @Param _include query string false "Encounter:subject and/or Encounter:participant; referenced resources are added with search.mode=include"
+// @Success 200 {object} fhir.Bundle
+// @Failure 400 {object} fhir.OperationOutcome "Invalid search or include parameter"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
+// @Router /Encounter [get]
+func (c *EncounterController) SearchEncounters(ctx *gin.Context) {
+	subset, err := parseSubset(ctx, "Encounter")
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	result, err := c.service.SearchEncounters(ctx.Request.Context(), searchQuery(ctx, subset))
+	if err != nil {
+		ctx.Error(err)
+		return
 	}
 
-	validFields := []string{"fhirId", "fullUrl", "status", "class", "period", "practitionerId", "patientId"}
-	for _, field := range fields {
-		if !contains(validFields, field) {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid field specified"})
+	ctx.JSON(http.StatusOK, searchsetBundle(ctx, result, subset))
+}
+
+// CreateEncounter godoc
+// @Summary Create encounter
+// @Description Creates an Encounter; subject and participant must reference existing Patient and Practitioner. With If-None-Exist, returns the matching Encounter (200) instead of creating a duplicate
+// @Tags Encounters
+// @Accept json
+// @Produce json
+// @Param request body fhir.Encounter true "Encounter resource"
+// @Param If-None-Exist header string false "Search criteria for conditional create, e.g. identifier=urn:oid:1|123"
+// @Success 201 {object} fhir.Encounter
+// @Success 200 {object} fhir.Encounter "An Encounter already matched the criteria"
+// @Failure 400 {object} fhir.OperationOutcome "Invalid resource, unknown reference or schema violation"
+// @Failure 412 {object} fhir.OperationOutcome "Criteria match more than one Encounter"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
+// @Router /Encounter [post]
+func (c *EncounterController) CreateEncounter(ctx *gin.Context) {
+	var resource fhir.Encounter
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "invalid request: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	criteria, conditional, err := ifNoneExist(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if conditional {
+		encounter, created, err := c.service.ConditionalCreateEncounter(ctx.Request.Context(), &resource, criteria)
+		if err != nil {
+			ctx.Error(err)
 			return
 		}
+		respondWrite(ctx, encounter, created)
+		return
+	}
+
+	encounter, err := c.service.CreateEncounter(ctx.Request.Context(), &resource)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, encounter)
+	ctx.Header("Location", versionedURL(baseURL(ctx), encounter))
+	ctx.JSON(http.StatusCreated, encounter)
+}
+
+// ConditionalUpdateEncounter godoc
+// @Summary Update encounter by search criteria
+// @Description Updates the single Encounter matching the query criteria, or creates one when none matches
+// @Tags Encounters
+// @Accept json
+// @Produce json
+// @Param identifier query string false "Search criterion, e.g. urn:oid:1|123"
+// @Param request body fhir.Encounter true "Encounter resource"
+// @Param If-Match header string false "Expected version ETag, e.g. W/\"3\""
+// @Success 200 {object} fhir.Encounter
+// @Success 201 {object} fhir.Encounter "No Encounter matched; created"
+// @Failure 400 {object} fhir.OperationOutcome "Invalid resource or criteria"
+// @Failure 412 {object} fhir.OperationOutcome "Criteria match more than one Encounter"
+// @Failure 422 {object} fhir.OperationOutcome "Status transition not allowed"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
+// @Router /Encounter [put]
+func (c *EncounterController) ConditionalUpdateEncounter(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	var resource fhir.Encounter
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "invalid request: "+err.Error(), http.StatusBadRequest))
+		return
 	}
 
-	encounter, err := c.service.GetEncounter(ctx.Request.Context(), id, fields)
+	encounter, created, err := c.service.ConditionalUpdateEncounter(ctx.Request.Context(), ctx.Request.URL.Query(), &resource, expected)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "encounter not found"})
+		ctx.Error(err)
 		return
 	}
 
+	respondWrite(ctx, encounter, created)
+}
+
+// UpdateEncounter godoc
+// @Summary Update encounter
+// @Description Replaces an existing Encounter
+// @Tags Encounters
+// @Accept json
+// @Produce json
+// @Param id path string true "Encounter ID"
+// @Param request body fhir.Encounter true "Encounter resource"
+// @Param If-Match header string false "Expected version ETag, e.g. W/\"3\""
+// @Success 200 {object} fhir.Encounter
+// @Failure 400 {object} fhir.OperationOutcome "Invalid resource, unknown reference or schema violation"
+// @Failure 404 {object} fhir.OperationOutcome "Encounter not found"
+// @Failure 409 {object} fhir.OperationOutcome "Encounter changed concurrently"
+// @Failure 422 {object} fhir.OperationOutcome "Status transition not allowed"
+// @Failure 412 {object} fhir.OperationOutcome "Version given in If-Match is stale"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
+// @Router /Encounter/{id} [put]
+func (c *EncounterController) UpdateEncounter(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	var resource fhir.Encounter
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "invalid request: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	encounter, err := c.service.UpdateEncounter(ctx.Request.Context(), ctx.Param("id"), &resource, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, encounter)
+	ctx.Header("Location", versionedURL(baseURL(ctx), encounter))
+	ctx.JSON(http.StatusOK, encounter)
+}
+
+// PatchEncounter godoc
+// @Summary Patch encounter
+// @Description Applies a JSON Patch (application/json-patch+json) or FHIRPath Patch (Parameters as application/fhir+json) to the stored Encounter and saves it as a new version; status changes follow the transition graph
+// @Tags Encounters
+// @Accept json
+// @Produce json
+// @Param id path string true "Encounter ID"
+// @Param request body object true "JSON Patch operations or FHIRPath Patch Parameters"
+// @Param If-Match header string false "Expected version ETag, e.g. W/\"3\""
+// @Success 200 {object} fhir.Encounter
+// @Failure 400 {object} fhir.OperationOutcome "Malformed patch, invalid result or unknown reference"
+// @Failure 404 {object} fhir.OperationOutcome "Encounter not found"
+// @Failure 409 {object} fhir.OperationOutcome "Encounter changed concurrently"
+// @Failure 412 {object} fhir.OperationOutcome "Version given in If-Match is stale"
+// @Failure 415 {object} fhir.OperationOutcome "Unsupported Content-Type"
+// @Failure 422 {object} fhir.OperationOutcome "Patch does not apply or status transition not allowed"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
+// @Router /Encounter/{id} [patch]
+func (c *EncounterController) PatchEncounter(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	patch, err := readPatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	encounter, err := c.service.PatchEncounter(ctx.Request.Context(), ctx.Param("id"), patch, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, encounter)
+	ctx.Header("Location", versionedURL(baseURL(ctx), encounter))
 	ctx.JSON(http.StatusOK, encounter)
 }
 
 // UpdateEncounterStatus godoc
 // @Summary Update encounter status
-// @Description Updates the status of a specific encounter
+// @Description Updates the status of a specific encounter following the configured transition graph; the previous status is appended to statusHistory
 // @Tags Encounters
 // @Accept json
 // @Produce json
 // @Param id path string true "Encounter ID"
 // @Param request body models.EncounterUpdate true "Status update payload"
-// @Failure 400 {object} map[string]string "Invalid request payload"
-// @Failure 404 {object} map[string]string "Encounter not found"
+// @Param If-Match header string false "Expected version ETag, e.g. W/\"3\""
+// @Failure 400 {object} fhir.OperationOutcome "Invalid request payload"
+// @Failure 404 {object} fhir.OperationOutcome "Encounter not found"
+// @Failure 409 {object} fhir.OperationOutcome "Encounter changed concurrently"
+// @Failure 412 {object} fhir.OperationOutcome "Version given in If-Match is stale"
+// @Failure 422 {object} fhir.OperationOutcome "Status transition not allowed"
+// @Failure 500 {object} fhir.OperationOutcome "Database or internal error"
 func (c *EncounterController) UpdateEncounterStatus(ctx *gin.Context) {
 	id := ctx.Param("id")
 
 	var req models.EncounterUpdate
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		ctx.Error(models.NewAppError("INVALID_INPUT", "invalid request: "+err.Error(), http.StatusBadRequest))
 		return
 	}
 
-	if err := c.service.UpdateEncounterStatus(ctx.Request.Context(), id, req.Status); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "status updated successfully"})
-}
-
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+	encounter, err := c.service.UpdateEncounterStatus(ctx.Request.Context(), id, req.Status, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
 	}
-	return false
+
+	setVersionHeaders(ctx, encounter)
+	ctx.JSON(http.StatusOK, gin.H{"message": "status updated successfully"})
 }
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"net/http"
+
+	"fhir-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryController atende vread e _history dos tipos versionados. Os handlers
+// são gerados por tipo de recurso, um para cada rota da tabela.
+type HistoryController struct {
+	service *services.HistoryService
+}
+
+func NewHistoryController(service *services.HistoryService) *HistoryController {
+	return &HistoryController{service: service}
+}
+
+// ReadVersion godoc
+// @Summary Lê uma versão de um recurso (vread)
+// @Description Devolve o recurso como estava na versão informada; versões de remoção respondem 410.
+// @Tags history
+// @Produce json
+// @Param type path string true "Tipo do recurso (Patient, Practitioner, Encounter)"
+// @Param id path string true "ID do recurso"
+// @Param vid path string true "versionId"
+// @Success 200 {object} interface{}
+// @Failure 400 {object} fhir.OperationOutcome "ID ou versionId inválido"
+// @Failure 404 {object} fhir.OperationOutcome "Versão não encontrada"
+// @Failure 410 {object} fhir.OperationOutcome "Recurso removido nesta versão"
+// @Router /{type}/{id}/_history/{vid} [get]
+func (c *HistoryController) ReadVersion(resourceType string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		resource, err := c.service.ReadVersion(ctx.Request.Context(), resourceType, ctx.Param("id"), ctx.Param("vid"))
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		respondResource(ctx, resource, resource)
+	}
+}
+
+// InstanceHistory godoc
+// @Summary Histórico de um recurso
+// @Description Bundle history com todas as versões do recurso, da mais recente para a mais antiga.
+// @Tags history
+// @Produce json
+// @Param type path string true "Tipo do recurso (Patient, Practitioner, Encounter)"
+// @Param id path string true "ID do recurso"
+// @Param _since query string false "Somente versões gravadas a partir deste instante"
+// @Param _count query int false "Tamanho da página"
+// @Param _cursor query string false "Cursor opaco dos links next/previous"
+// @Success 200 {object} fhir.Bundle
+// @Failure 400 {object} fhir.OperationOutcome "Parâmetro inválido"
+// @Failure 404 {object} fhir.OperationOutcome "Recurso sem histórico"
+// @Router /{type}/{id}/_history [get]
+func (c *HistoryController) InstanceHistory(resourceType string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		result, err := c.service.History(ctx.Request.Context(), resourceType, ctx.Param("id"), ctx.Request.URL.Query())
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, historyBundle(ctx, result))
+	}
+}
+
+// TypeHistory godoc
+// @Summary Histórico de um tipo de recurso
+// @Description Bundle history com as versões de todos os recursos do tipo, da mais recente para a mais antiga.
+// @Tags history
+// @Produce json
+// @Param type path string true "Tipo do recurso (Patient, Practitioner, Encounter)"
+// @Param _since query string false "Somente versões gravadas a partir deste instante"
+// @Param _count query int false "Tamanho da página"
+// @Param _cursor query string false "Cursor opaco dos links next/previous"
+// @Success 200 {object} fhir.Bundle
+// @Failure 400 {object} fhir.OperationOutcome "Parâmetro inválido"
+// @Router /{type}/_history [get]
+func (c *HistoryController) TypeHistory(resourceType string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		result, err := c.service.History(ctx.Request.Context(), resourceType, "", ctx.Request.URL.Query())
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		ctx.JSON(http.StatusOK, historyBundle(ctx, result))
+	}
+}
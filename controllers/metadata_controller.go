@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+	"fhir-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MetadataController struct {
+	routes    []Route
+	security  *fhir.CapabilitySecurity
+	tokenURL  string
+	startedAt time.Time
+}
+
+// NewMetadataController recebe, em tokenURL, a URL pública de /auth/token
+// configurada; vazia, os endpoints OAuth são anunciados a partir da requisição.
+func NewMetadataController(routes []Route, security *fhir.CapabilitySecurity, tokenURL string) *MetadataController {
+	return &MetadataController{
+		routes:    routes,
+		security:  security,
+		tokenURL:  tokenURL,
+		startedAt: time.Now(),
+	}
+}
+
+// GetMetadata godoc
+// @Summary CapabilityStatement do servidor
+// @Description Descreve os recursos, interações e parâmetros de busca suportados, gerado a partir da tabela de rotas.
+// @Tags metadata
+// @Produce json
+// @Success 200 {object} fhir.CapabilityStatement
+// @Router /metadata [get]
+func (c *MetadataController) GetMetadata(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.capabilityStatement(baseURL(ctx)))
+}
+
+func (c *MetadataController) capabilityStatement(base string) *fhir.CapabilityStatement {
+	rest := fhir.CapabilityStatementRest{
+		Mode:     "server",
+		Security: c.security,
+	}
+
+	var patchFormat []string
+	resourceIndex := map[string]int{}
+	for _, route := range c.routes {
+		if route.Interaction == "" || route.Unlisted {
+			continue
+		}
+
+		interaction := fhir.CapabilityInteraction{Code: route.Interaction}
+		if route.ResourceType == "" {
+			rest.Interaction = append(rest.Interaction, interaction)
+			for _, code := range route.Also {
+				rest.Interaction = append(rest.Interaction, fhir.CapabilityInteraction{Code: code})
+			}
+			continue
+		}
+
+		i, ok := resourceIndex[route.ResourceType]
+		if !ok {
+			i = len(rest.Resource)
+			resourceIndex[route.ResourceType] = i
+			rest.Resource = append(rest.Resource, fhir.CapabilityResource{Type: route.ResourceType})
+		}
+
+		resource := &rest.Resource[i]
+		if !hasInteraction(resource.Interaction, route.Interaction) {
+			resource.Interaction = append(resource.Interaction, interaction)
+		}
+		switch route.Interaction {
+		case "vread":
+			resource.Versioning = "versioned"
+		case "patch":
+			patchFormat = []string{services.JSONPatchMediaType, services.FHIRJSONMediaType}
+		}
+		if route.Conditional {
+			switch route.Interaction {
+			case "create":
+				resource.ConditionalCreate = true
+			case "update":
+				resource.ConditionalUpdate = true
+			case "delete":
+				resource.ConditionalDelete = "single"
+			}
+		}
+		resource.SearchInclude = append(resource.SearchInclude, route.SearchInclude...)
+		resource.SearchRevInclude = append(resource.SearchRevInclude, route.SearchRevInclude...)
+		resource.SearchParam = append(resource.SearchParam, route.SearchParams...)
+	}
+
+	return &fhir.CapabilityStatement{
+		ResourceType: "CapabilityStatement",
+		Status:       "active",
+		Date:         fhir.FormatInstant(c.startedAt),
+		Kind:         "instance",
+		Software:     &fhir.CapabilitySoftware{Name: "fhir-api"},
+		Implementation: &fhir.CapabilityImplementation{
+			Description: "API para recursos FHIR",
+			URL:         base,
+		},
+		FhirVersion: fhir.FhirVersion,
+		Format:      []string{"json"},
+		PatchFormat: patchFormat,
+		Rest:        []fhir.CapabilityStatementRest{rest},
+	}
+}
+
+// GetSmartConfiguration godoc
+// @Summary Configuração SMART on FHIR
+// @Description Descoberta SMART Backend Services: endpoint de token, métodos de autenticação de cliente e scopes suportados, derivados da tabela de rotas.
+// @Tags metadata
+// @Produce json
+// @Success 200 {object} models.SmartConfiguration
+// @Router /.well-known/smart-configuration [get]
+func (c *MetadataController) GetSmartConfiguration(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.smartConfiguration(baseURL(ctx)))
+}
+
+func (c *MetadataController) smartConfiguration(base string) *models.SmartConfiguration {
+	var types []string
+	interactions := map[string][]string{}
+	for _, route := range c.routes {
+		if route.ResourceType == "" || route.Interaction == "" || route.Unlisted {
+			continue
+		}
+		if _, ok := interactions[route.ResourceType]; !ok {
+			types = append(types, route.ResourceType)
+		}
+		interactions[route.ResourceType] = append(interactions[route.ResourceType], route.Interaction)
+	}
+
+	var scopes []string
+	for _, resourceType := range types {
+		scopes = append(scopes, services.ResourceScopes(resourceType, interactions[resourceType])...)
+	}
+
+	tokenURL := base + "/auth/token"
+	if c.tokenURL != "" {
+		tokenURL = c.tokenURL
+	}
+	authBase := strings.TrimSuffix(tokenURL, "/token")
+
+	return &models.SmartConfiguration{
+		TokenEndpoint:                     tokenURL,
+		RevocationEndpoint:                authBase + "/revoke",
+		IntrospectionEndpoint:             authBase + "/introspect",
+		TokenEndpointAuthMethods:          []string{"client_secret_basic", "client_secret_post", "private_key_jwt"},
+		TokenEndpointAuthSigningAlgValues: services.AssertionSigningAlgs,
+		GrantTypesSupported:               []string{"client_credentials"},
+		ScopesSupported:                   scopes,
+		Capabilities:                      []string{"client-confidential-symmetric", "client-confidential-asymmetric", "permission-v2"},
+	}
+}
+
+// hasInteraction evita anunciar duas vezes a mesma interação quando ela é
+// atendida por mais de uma rota, como o update por id e o condicional.
+func hasInteraction(interactions []fhir.CapabilityInteraction, code string) bool {
+	for _, interaction := range interactions {
+		if interaction.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// requestURL devolve a URL absoluta da requisição atual, usada no link self.
+func requestURL(ctx *gin.Context) string {
+	return strings.TrimSuffix(baseURL(ctx), APIBasePath) + ctx.Request.URL.RequestURI()
+}
+
+// baseURL reconstrói a URL base do servidor FHIR (até /api/v1) a partir da requisição.
+func baseURL(ctx *gin.Context) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + ctx.Request.Host + APIBasePath
+}
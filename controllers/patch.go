@@ -0,0 +1,20 @@
+package controllers
+
+import (
+	"net/http"
+
+	"fhir-api/models"
+	"fhir-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readPatch lê o corpo de um PATCH; o Content-Type decide entre JSON Patch e
+// FHIRPath Patch.
+func readPatch(ctx *gin.Context) (services.Patch, error) {
+	body, err := ctx.GetRawData()
+	if err != nil || len(body) == 0 {
+		return services.Patch{}, models.NewAppError("INVALID_INPUT", "corpo do PATCH ausente ou ilegível", http.StatusBadRequest)
+	}
+	return services.Patch{ContentType: ctx.ContentType(), Body: body}, nil
+}
@@ -2,8 +2,9 @@ package controllers
 
 import (
 	"net/http"
-	"strings"
 
+	"fhir-api/fhir"
+	"fhir-api/models"
 	"fhir-api/services"
 
 	"github.com/gin-gonic/gin"
@@ -17,10 +18,6 @@ func NewPatientController(service *services.PatientService) *PatientController {
 	return &PatientController{service: service}
 }
 
-type GetPatientRequest struct {
-	Fields string `form:"fields" binding:"required"`
-}
-
 // GetPatientByID godoc
 // @Summary Retorna um paciente
 // @Description Busca paciente pelo ID
@@ -28,46 +25,284 @@ type GetPatientRequest struct {
 // @Accept json
 // @Produce json
 // @Param id path string true "ID do paciente"
-// @Success 200 {object} models.Patient
-// @Failure 400 {object} map[string]string
-// @Router /patients/{id} [get]
+// @Param _elements query string false "Elementos a retornar, separados por vírgula (elementos obrigatórios são sempre mantidos)"
+// @Param _summary query string false "true, text, data ou false"
+// @Param If-None-Match header string false "ETag conhecido pelo cliente; responde 304 se for a versão atual"
+// @Success 200 {object} fhir.Patient
+// @Success 304
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient/{id} [get]
 func (c *PatientController) GetPatient(ctx *gin.Context) {
-	id := ctx.Param("id")
+	subset, err := parseReadSubset(ctx, "Patient")
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
 
-	var req GetPatientRequest
-	if err := ctx.ShouldBindQuery(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "fields parameter is required"})
+	patient, err := c.service.GetPatient(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.Error(err)
 		return
 	}
 
-	fieldsParam := req.Fields
-	fields := strings.Split(fieldsParam, ",")
-	for i, field := range fields {
-		fields[i] = strings.TrimSpace(field)
+	respondResource(ctx, patient, subset.Apply(patient))
+}
+
+// SearchPatients godoc
+// @Summary Busca pacientes
+// @Description Busca FHIR de pacientes, retornando um Bundle searchset
+// @Tags Pacientes
+// @Produce json
+// @Param name query string false "Prefixo do nome ou sobrenome"
+// @Param family query string false "Prefixo do sobrenome"
+// @Param given query string false "Prefixo do nome"
+// @Param birthdate query string false "Data de nascimento (AAAA, AAAA-MM ou AAAA-MM-DD) com prefixo (eq, lt, ge...)"
+// @Param gender query string false "Gênero administrativo"
+// @Param identifier query string false "Identificador (system|value)"
+// @Param _sort query string false "Campos de ordenação separados por vírgula, '-' para descendente (ex.: family,-birthdate)"
+// @Param _count query int false "Tamanho da página (limitado por SEARCH_MAX_COUNT)"
+// @Param _cursor query string false "Cursor opaco das links next/previous"
+// @Param _elements query string false "Elementos a retornar em cada entrada, separados por vírgula"
+// @Param _summary query string false "true, text, data, count ou false"
+// @Param _revinclude query string false "Encounter:subject inclui os encounters dos pacientes da página (limitado por SEARCH_MAX_INCLUDE)"
+// @Success 200 {object} fhir.Bundle
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient [get]
+func (c *PatientController) SearchPatients(ctx *gin.Context) {
+	subset, err := parseSubset(ctx, "Patient")
+	if err != nil {
+		ctx.Error(err)
+		return
 	}
 
-	validFields := []string{"id", "fhirId", "givenName", "familyName", "birthDate", "gender"}
-	for _, field := range fields {
-		if !containsPatientFields(validFields, field) {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid field specified"})
+	result, err := c.service.SearchPatients(ctx.Request.Context(), searchQuery(ctx, subset))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, searchsetBundle(ctx, result, subset))
+}
+
+// CreatePatient godoc
+// @Summary Cria um paciente
+// @Description Cria um Patient com id atribuído pelo servidor, retornando Location. Com If-None-Exist, devolve o Patient existente (200) quando um corresponde aos critérios
+// @Tags Pacientes
+// @Accept json
+// @Produce json
+// @Param request body fhir.Patient true "Recurso Patient"
+// @Param If-None-Exist header string false "Critérios de busca do create condicional, ex.: identifier=urn:oid:1|123"
+// @Success 201 {object} fhir.Patient
+// @Success 200 {object} fhir.Patient
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 412 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient [post]
+func (c *PatientController) CreatePatient(ctx *gin.Context) {
+	var resource fhir.Patient
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	criteria, conditional, err := ifNoneExist(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if conditional {
+		patient, created, err := c.service.ConditionalCreatePatient(ctx.Request.Context(), &resource, criteria)
+		if err != nil {
+			ctx.Error(err)
 			return
 		}
+		respondWrite(ctx, patient, created)
+		return
 	}
 
-	patient, err := c.service.GetPatient(ctx.Request.Context(), id, fields)
+	patient, err := c.service.CreatePatient(ctx.Request.Context(), &resource)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
+		ctx.Error(err)
 		return
 	}
 
+	setVersionHeaders(ctx, patient)
+	ctx.Header("Location", versionedURL(baseURL(ctx), patient))
+	ctx.JSON(http.StatusCreated, patient)
+}
+
+// ConditionalUpdatePatient godoc
+// @Summary Atualiza um paciente por critérios de busca
+// @Description Atualiza o único Patient que corresponde aos critérios ou cria um novo quando nenhum corresponde
+// @Tags Pacientes
+// @Accept json
+// @Produce json
+// @Param identifier query string false "Critério de busca, ex.: urn:oid:1|123"
+// @Param request body fhir.Patient true "Recurso Patient"
+// @Param If-Match header string false "ETag da versão esperada, ex.: W/\"3\""
+// @Success 200 {object} fhir.Patient
+// @Success 201 {object} fhir.Patient
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 412 {object} fhir.OperationOutcome "Critérios correspondem a mais de um Patient"
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient [put]
+func (c *PatientController) ConditionalUpdatePatient(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	var resource fhir.Patient
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	patient, created, err := c.service.ConditionalUpdatePatient(ctx.Request.Context(), ctx.Request.URL.Query(), &resource, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	respondWrite(ctx, patient, created)
+}
+
+// UpdatePatient godoc
+// @Summary Atualiza um paciente
+// @Description Substitui o conteúdo de um Patient existente
+// @Tags Pacientes
+// @Accept json
+// @Produce json
+// @Param id path string true "ID do paciente"
+// @Param request body fhir.Patient true "Recurso Patient"
+// @Param If-Match header string false "ETag da versão esperada, ex.: W/\"3\""
+// @Success 200 {object} fhir.Patient
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 412 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient/{id} [put]
+func (c *PatientController) UpdatePatient(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	var resource fhir.Patient
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	patient, err := c.service.UpdatePatient(ctx.Request.Context(), ctx.Param("id"), &resource, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, patient)
+	ctx.Header("Location", versionedURL(baseURL(ctx), patient))
 	ctx.JSON(http.StatusOK, patient)
 }
 
-func containsPatientFields(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+// PatchPatient godoc
+// @Summary Aplica um patch a um paciente
+// @Description Aplica JSON Patch (application/json-patch+json) ou FHIRPath Patch (Parameters em application/fhir+json) ao Patient armazenado, gravando uma nova versão
+// @Tags Pacientes
+// @Accept json
+// @Produce json
+// @Param id path string true "ID do paciente"
+// @Param request body object true "Operações JSON Patch ou Parameters do FHIRPath Patch"
+// @Param If-Match header string false "ETag da versão esperada, ex.: W/\"3\""
+// @Success 200 {object} fhir.Patient
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 409 {object} fhir.OperationOutcome
+// @Failure 412 {object} fhir.OperationOutcome
+// @Failure 415 {object} fhir.OperationOutcome
+// @Failure 422 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient/{id} [patch]
+func (c *PatientController) PatchPatient(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	patch, err := readPatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
 	}
-	return false
+
+	patient, err := c.service.PatchPatient(ctx.Request.Context(), ctx.Param("id"), patch, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, patient)
+	ctx.Header("Location", versionedURL(baseURL(ctx), patient))
+	ctx.JSON(http.StatusOK, patient)
+}
+
+// DeletePatient godoc
+// @Summary Remove um paciente
+// @Description Pacientes ainda referenciados por encounters não são removidos (409)
+// @Tags Pacientes
+// @Param id path string true "ID do paciente"
+// @Param If-Match header string false "ETag da versão esperada, ex.: W/\"3\""
+// @Success 204
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 409 {object} fhir.OperationOutcome "Paciente referenciado por encounters"
+// @Failure 412 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient/{id} [delete]
+func (c *PatientController) DeletePatient(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if err := c.service.DeletePatient(ctx.Request.Context(), ctx.Param("id"), expected); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ConditionalDeletePatient godoc
+// @Summary Remove um paciente por critérios de busca
+// @Description Remove o único Patient que corresponde aos critérios; sem correspondência, nada é removido
+// @Tags Pacientes
+// @Param identifier query string false "Critério de busca, ex.: urn:oid:1|123"
+// @Param If-Match header string false "ETag da versão esperada, ex.: W/\"3\""
+// @Success 204
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 409 {object} fhir.OperationOutcome "Paciente referenciado por encounters"
+// @Failure 412 {object} fhir.OperationOutcome "Critérios correspondem a mais de um Patient"
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /Patient [delete]
+func (c *PatientController) ConditionalDeletePatient(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if err := c.service.ConditionalDeletePatient(ctx.Request.Context(), ctx.Request.URL.Query(), expected); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
 }
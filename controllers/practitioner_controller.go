@@ -2,9 +2,9 @@ package controllers
 
 import (
 	"net/http"
-	"strings"
 
-	_ "fhir-api/models"
+	"fhir-api/fhir"
+	"fhir-api/models"
 	"fhir-api/services"
 
 	"github.com/gin-gonic/gin"
@@ -18,58 +18,288 @@ func NewPractitionerController(service *services.PractitionerService) *Practitio
 	return &PractitionerController{service: service}
 }
 
-type GetPractitionerRequest struct {
-	Fields string `form:"fields" binding:"required"`
-}
-
 // GetPractitioner godoc
 // @Summary      Busca um Practitioner por ID
-// @Description  Retorna um Practitioner específico, filtrando os campos desejados.
+// @Description  Retorna um Practitioner específico, opcionalmente reduzido com _elements ou _summary.
 // @Tags         practitioners
 // @Accept       json
 // @Produce      json
 // @Param        id     path      string  true  "ID do Practitioner"
-// @Param        fields query     string  true  "Lista de campos separados por vírgula (ex: fhirId,fullUrl,status)"
-// @Failure      400    {object}  map[string]string "Erro de validação nos parâmetros"
-// @Failure      404    {object}  map[string]string "Practitioner não encontrado"
-// @Router       /practitioner/{id} [get]
+// @Param        _elements query  string  false "Elementos a retornar, separados por vírgula (ex: name,identifier)"
+// @Param        _summary  query  string  false "true, text, data ou false"
+// @Param        If-None-Match  header  string  false  "ETag conhecido pelo cliente; responde 304 se for a versão atual"
+// @Success      200    {object}  fhir.Practitioner
+// @Success      304
+// @Failure      400    {object}  fhir.OperationOutcome "Erro de validação nos parâmetros"
+// @Failure      404    {object}  fhir.OperationOutcome "Practitioner não encontrado"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner/{id} [get]
 func (c *PractitionerController) GetPractitioner(ctx *gin.Context) {
-	id := ctx.Param("id")
+	subset, err := parseReadSubset(ctx, "Practitioner")
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
 
-	var req GetPractitionerRequest
-	if err := ctx.ShouldBindQuery(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "fields parameter is required"})
+	practitioner, err := c.service.GetPractitioner(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.Error(err)
 		return
 	}
 
-	fieldsParam := req.Fields
-	fields := strings.Split(fieldsParam, ",")
-	for i, field := range fields {
-		fields[i] = strings.TrimSpace(field)
+	respondResource(ctx, practitioner, subset.Apply(practitioner))
+}
+
+// SearchPractitioners godoc
+// @Summary      Busca Practitioners
+// @Description  Busca FHIR de profissionais, retornando um Bundle searchset.
+// @Tags         practitioners
+// @Produce      json
+// @Param        name       query     string  false  "Prefixo do nome ou sobrenome"
+// @Param        family     query     string  false  "Prefixo do sobrenome"
+// @Param        given      query     string  false  "Prefixo do nome"
+// @Param        identifier query     string  false  "Identificador (system|value)"
+// @Param        _sort      query     string  false  "Campos de ordenação separados por vírgula, '-' para descendente"
+// @Param        _count     query     int     false  "Tamanho da página (limitado por SEARCH_MAX_COUNT)"
+// @Param        _cursor    query     string  false  "Cursor opaco das links next/previous"
+// @Param        _elements  query     string  false  "Elementos a retornar em cada entrada, separados por vírgula"
+// @Param        _summary   query     string  false  "true, text, data, count ou false"
+// @Success      200    {object}  fhir.Bundle
+// @Failure      400    {object}  fhir.OperationOutcome "Parâmetro de busca inválido"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner [get]
+func (c *PractitionerController) SearchPractitioners(ctx *gin.Context) {
+	subset, err := parseSubset(ctx, "Practitioner")
+	if err != nil {
+		ctx.Error(err)
+		return
 	}
 
-	validFields := []string{"fhirId", "fullUrl", "status", "class", "period", "practitionerId", "patientId"}
-	for _, field := range fields {
-		if !containsPractitionerFields(validFields, field) {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid field specified"})
+	result, err := c.service.SearchPractitioners(ctx.Request.Context(), searchQuery(ctx, subset))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, searchsetBundle(ctx, result, subset))
+}
+
+// CreatePractitioner godoc
+// @Summary      Cria um Practitioner
+// @Description  Cria um Practitioner com identificadores, qualificações e telecom, retornando Location. Com If-None-Exist, devolve o Practitioner existente (200) quando um corresponde aos critérios.
+// @Tags         practitioners
+// @Accept       json
+// @Produce      json
+// @Param        request body      fhir.Practitioner  true  "Recurso Practitioner"
+// @Param        If-None-Exist  header  string  false  "Critérios do create condicional, ex.: identifier=urn:oid:1|123"
+// @Success      201    {object}  fhir.Practitioner
+// @Success      200    {object}  fhir.Practitioner "Practitioner já existente para os critérios"
+// @Failure      400    {object}  fhir.OperationOutcome "Recurso inválido"
+// @Failure      412    {object}  fhir.OperationOutcome "Critérios correspondem a mais de um Practitioner"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner [post]
+func (c *PractitionerController) CreatePractitioner(ctx *gin.Context) {
+	var resource fhir.Practitioner
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	criteria, conditional, err := ifNoneExist(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if conditional {
+		practitioner, created, err := c.service.ConditionalCreatePractitioner(ctx.Request.Context(), &resource, criteria)
+		if err != nil {
+			ctx.Error(err)
 			return
 		}
+		respondWrite(ctx, practitioner, created)
+		return
 	}
 
-	practitioner, err := c.service.GetPractitioner(ctx.Request.Context(), id, fields)
+	practitioner, err := c.service.CreatePractitioner(ctx.Request.Context(), &resource)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "practitioner not found"})
+		ctx.Error(err)
 		return
 	}
 
+	setVersionHeaders(ctx, practitioner)
+	ctx.Header("Location", versionedURL(baseURL(ctx), practitioner))
+	ctx.JSON(http.StatusCreated, practitioner)
+}
+
+// ConditionalUpdatePractitioner godoc
+// @Summary      Atualiza um Practitioner por critérios de busca
+// @Description  Atualiza o único Practitioner que corresponde aos critérios da query, ou cria um novo quando nenhum corresponde.
+// @Tags         practitioners
+// @Accept       json
+// @Produce      json
+// @Param        identifier  query  string  false  "Critério de busca, ex.: urn:oid:1|123"
+// @Param        request body      fhir.Practitioner  true  "Recurso Practitioner"
+// @Param        If-Match  header  string  false  "ETag da versão esperada, ex.: W/\"3\""
+// @Success      200    {object}  fhir.Practitioner
+// @Success      201    {object}  fhir.Practitioner "Nenhum Practitioner correspondia; criado"
+// @Failure      400    {object}  fhir.OperationOutcome "Recurso ou critérios inválidos"
+// @Failure      412    {object}  fhir.OperationOutcome "Critérios correspondem a mais de um Practitioner"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner [put]
+func (c *PractitionerController) ConditionalUpdatePractitioner(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	var resource fhir.Practitioner
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	practitioner, created, err := c.service.ConditionalUpdatePractitioner(ctx.Request.Context(), ctx.Request.URL.Query(), &resource, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	respondWrite(ctx, practitioner, created)
+}
+
+// UpdatePractitioner godoc
+// @Summary      Atualiza um Practitioner
+// @Description  Substitui o conteúdo de um Practitioner existente.
+// @Tags         practitioners
+// @Accept       json
+// @Produce      json
+// @Param        id     path      string  true  "ID do Practitioner"
+// @Param        request body      fhir.Practitioner  true  "Recurso Practitioner"
+// @Param        If-Match  header  string  false  "ETag da versão esperada, ex.: W/\"3\""
+// @Success      200    {object}  fhir.Practitioner
+// @Failure      400    {object}  fhir.OperationOutcome "Recurso inválido"
+// @Failure      404    {object}  fhir.OperationOutcome "Practitioner não encontrado"
+// @Failure      412    {object}  fhir.OperationOutcome "Versão informada em If-Match desatualizada"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner/{id} [put]
+func (c *PractitionerController) UpdatePractitioner(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	var resource fhir.Practitioner
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		ctx.Error(models.NewAppError("INVALID_INPUT", "corpo da requisição inválido: "+err.Error(), http.StatusBadRequest))
+		return
+	}
+
+	practitioner, err := c.service.UpdatePractitioner(ctx.Request.Context(), ctx.Param("id"), &resource, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, practitioner)
+	ctx.Header("Location", versionedURL(baseURL(ctx), practitioner))
 	ctx.JSON(http.StatusOK, practitioner)
 }
 
-func containsPractitionerFields(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
+// PatchPractitioner godoc
+// @Summary      Aplica um patch a um Practitioner
+// @Description  Aplica JSON Patch (application/json-patch+json) ou FHIRPath Patch (Parameters em application/fhir+json) ao Practitioner armazenado, gravando uma nova versão.
+// @Tags         practitioners
+// @Accept       json
+// @Produce      json
+// @Param        id     path      string  true  "ID do Practitioner"
+// @Param        request body      object  true  "Operações JSON Patch ou Parameters do FHIRPath Patch"
+// @Param        If-Match  header  string  false  "ETag da versão esperada, ex.: W/\"3\""
+// @Success      200    {object}  fhir.Practitioner
+// @Failure      400    {object}  fhir.OperationOutcome "Patch malformado ou recurso resultante inválido"
+// @Failure      404    {object}  fhir.OperationOutcome "Practitioner não encontrado"
+// @Failure      409    {object}  fhir.OperationOutcome "Practitioner alterado concorrentemente"
+// @Failure      412    {object}  fhir.OperationOutcome "Versão informada em If-Match desatualizada"
+// @Failure      415    {object}  fhir.OperationOutcome "Content-Type não suportado"
+// @Failure      422    {object}  fhir.OperationOutcome "Patch não aplicável ao recurso"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner/{id} [patch]
+func (c *PractitionerController) PatchPractitioner(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	patch, err := readPatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
 	}
-	return false
+
+	practitioner, err := c.service.PatchPractitioner(ctx.Request.Context(), ctx.Param("id"), patch, expected)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	setVersionHeaders(ctx, practitioner)
+	ctx.Header("Location", versionedURL(baseURL(ctx), practitioner))
+	ctx.JSON(http.StatusOK, practitioner)
+}
+
+// DeletePractitioner godoc
+// @Summary      Remove um Practitioner
+// @Description  Practitioners ainda referenciados por encounters não são removidos (409).
+// @Tags         practitioners
+// @Param        id     path      string  true  "ID do Practitioner"
+// @Param        If-Match  header  string  false  "ETag da versão esperada, ex.: W/\"3\""
+// @Success      204
+// @Failure      400    {object}  fhir.OperationOutcome "ID inválido"
+// @Failure      404    {object}  fhir.OperationOutcome "Practitioner não encontrado"
+// @Failure      409    {object}  fhir.OperationOutcome "Practitioner referenciado por encounters"
+// @Failure      412    {object}  fhir.OperationOutcome "Versão informada em If-Match desatualizada"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner/{id} [delete]
+func (c *PractitionerController) DeletePractitioner(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if err := c.service.DeletePractitioner(ctx.Request.Context(), ctx.Param("id"), expected); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ConditionalDeletePractitioner godoc
+// @Summary      Remove um Practitioner por critérios de busca
+// @Description  Remove o único Practitioner que corresponde aos critérios; sem correspondência, nada é removido.
+// @Tags         practitioners
+// @Param        identifier  query  string  false  "Critério de busca, ex.: urn:oid:1|123"
+// @Param        If-Match  header  string  false  "ETag da versão esperada, ex.: W/\"3\""
+// @Success      204
+// @Failure      400    {object}  fhir.OperationOutcome "Critérios inválidos"
+// @Failure      409    {object}  fhir.OperationOutcome "Practitioner referenciado por encounters"
+// @Failure      412    {object}  fhir.OperationOutcome "Critérios correspondem a mais de um Practitioner"
+// @Failure      500    {object}  fhir.OperationOutcome "Falha de banco de dados ou erro interno"
+// @Router       /Practitioner [delete]
+func (c *PractitionerController) ConditionalDeletePractitioner(ctx *gin.Context) {
+	expected, err := ifMatch(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	if err := c.service.ConditionalDeletePractitioner(ctx.Request.Context(), ctx.Request.URL.Query(), expected); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
 }
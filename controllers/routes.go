@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"fhir-api/fhir"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIBasePath é o prefixo sob o qual o servidor FHIR é exposto.
+const APIBasePath = "/api/v1"
+
+// Route descreve uma rota registrada em App.Run. ResourceType e Interaction
+// alimentam o CapabilityStatement; rotas sem Interaction não são anunciadas.
+// Also lista outras interações atendidas pelo mesmo handler, como batch e
+// transaction no POST da raiz. Conditional indica que a interação aceita
+// critérios de busca (If-None-Exist no create, query no update e no delete).
+// SearchInclude e SearchRevInclude listam os valores aceitos em _include e
+// _revinclude pela rota de busca. Unlisted mantém fora do CapabilityStatement
+// rotas que ainda precisam de ResourceType e Interaction para exigir scope, como
+// as legadas.
+type Route struct {
+	Method           string
+	Path             string
+	ResourceType     string
+	Interaction      string
+	Also             []string
+	Conditional      bool
+	Unlisted         bool
+	SearchParams     []fhir.CapabilitySearchParam
+	SearchInclude    []string
+	SearchRevInclude []string
+	Handler          gin.HandlerFunc
+}
+
+// RegisterRoutes registra a tabela de rotas no grupo informado, com o handler
+// devolvido por guard para cada rota (a exigência de scope) antes do da rota.
+func RegisterRoutes(group *gin.RouterGroup, routes []Route, guard func(Route) gin.HandlerFunc) {
+	for _, route := range routes {
+		group.Handle(route.Method, route.Path, guard(route), route.Handler)
+	}
+}
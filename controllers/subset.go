@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseSubset lê _elements e _summary da requisição para o tipo de recurso informado.
+func parseSubset(ctx *gin.Context, resourceType string) (*fhir.Subset, error) {
+	subset, err := fhir.ParseSubset(ctx.Request.URL.Query(), resourceType)
+	if err != nil {
+		return nil, models.NewAppError("INVALID_PARAM", err.Error(), http.StatusBadRequest)
+	}
+	return subset, nil
+}
+
+// parseReadSubset é parseSubset para leituras, em que _summary=count não se aplica.
+func parseReadSubset(ctx *gin.Context, resourceType string) (*fhir.Subset, error) {
+	subset, err := parseSubset(ctx, resourceType)
+	if err != nil {
+		return nil, err
+	}
+	if subset.Summary == fhir.SummaryCount {
+		return nil, models.NewAppError("INVALID_PARAM", "_summary=count só é aceito em buscas", http.StatusBadRequest)
+	}
+	return subset, nil
+}
+
+// searchQuery devolve a query repassada ao serviço; com _summary=count apenas o
+// total é necessário, então nenhuma página é lida.
+func searchQuery(ctx *gin.Context, subset *fhir.Subset) url.Values {
+	query := ctx.Request.URL.Query()
+	if subset.Summary == fhir.SummaryCount {
+		query.Set("_count", "0")
+	}
+	return query
+}
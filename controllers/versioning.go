@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+	"fhir-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setVersionHeaders publica ETag e Last-Modified a partir de meta.versionId e meta.lastUpdated.
+func setVersionHeaders(ctx *gin.Context, resource fhir.Resource) {
+	meta := resource.ResourceMeta()
+	if meta == nil {
+		return
+	}
+
+	if meta.VersionID != "" {
+		ctx.Header("ETag", fhir.WeakETag(meta.VersionID))
+	}
+	if lastUpdated, err := time.Parse(fhir.InstantFormat, meta.LastUpdated); err == nil {
+		ctx.Header("Last-Modified", lastUpdated.UTC().Format(http.TimeFormat))
+	}
+}
+
+// versionedURL é a URL da versão corrente do recurso, usada em Location.
+func versionedURL(base string, resource fhir.Resource) string {
+	meta := resource.ResourceMeta()
+	if meta == nil || meta.VersionID == "" {
+		return fullURL(base, resource)
+	}
+	return fullURL(base, resource) + "/_history/" + meta.VersionID
+}
+
+// respondResource responde uma leitura com os cabeçalhos de versão, ou 304 quando
+// If-None-Match ou If-Modified-Since indicam que o cliente já tem a versão atual.
+// body é o que vai no corpo (o recurso, possivelmente subconjunto).
+func respondResource(ctx *gin.Context, resource fhir.Resource, body interface{}) {
+	setVersionHeaders(ctx, resource)
+
+	if notModified(ctx, resource.ResourceMeta()) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, body)
+}
+
+// notModified avalia as pré-condições de leitura. If-None-Match tem precedência
+// e, quando presente, If-Modified-Since é ignorado (RFC 9110).
+func notModified(ctx *gin.Context, meta *fhir.Meta) bool {
+	if meta == nil {
+		return false
+	}
+
+	if header := ctx.GetHeader("If-None-Match"); header != "" {
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || fhir.ETagVersion(tag) == meta.VersionID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if header := ctx.GetHeader("If-Modified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+		lastUpdated, err := time.Parse(fhir.InstantFormat, meta.LastUpdated)
+		if err != nil {
+			return false
+		}
+		return !lastUpdated.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// ifMatch lê o versionId exigido em If-Match (W/"3" ou "3"). Devolve 0 quando
+// o cabeçalho está ausente, caso em que a escrita não tem pré-condição.
+func ifMatch(ctx *gin.Context) (int64, error) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(fhir.ETagVersion(header), 10, 64)
+	if err != nil || version < 1 {
+		return 0, models.NewAppError("INVALID_INPUT", "If-Match inválido: "+header, http.StatusBadRequest)
+	}
+	return version, nil
+}
+
+// ifNoneExist lê os critérios do create condicional. ok é falso quando o
+// cabeçalho está ausente e o create é incondicional.
+func ifNoneExist(ctx *gin.Context) (url.Values, bool, error) {
+	header := strings.TrimSpace(ctx.GetHeader("If-None-Exist"))
+	if header == "" {
+		return nil, false, nil
+	}
+	criteria, err := services.ParseCriteria(header)
+	return criteria, err == nil, err
+}
+
+// respondWrite responde uma escrita condicional: 201 quando o recurso foi
+// criado e 200 quando um existente foi atualizado ou devolvido.
+func respondWrite(ctx *gin.Context, resource fhir.Resource, created bool) {
+	setVersionHeaders(ctx, resource)
+	ctx.Header("Location", versionedURL(baseURL(ctx), resource))
+	if created {
+		ctx.JSON(http.StatusCreated, resource)
+		return
+	}
+	ctx.JSON(http.StatusOK, resource)
+}
@@ -0,0 +1,42 @@
+package fhir
+
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	ID           string        `json:"id,omitempty"`
+	Meta         *Meta         `json:"meta,omitempty"`
+	Type         string        `json:"type"`
+	Total        *int64        `json:"total,omitempty"`
+	Link         []BundleLink  `json:"link,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+type BundleLink struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+type BundleEntry struct {
+	FullURL  string               `json:"fullUrl,omitempty"`
+	Resource interface{}          `json:"resource,omitempty"`
+	Search   *BundleEntrySearch   `json:"search,omitempty"`
+	Request  *BundleEntryRequest  `json:"request,omitempty"`
+	Response *BundleEntryResponse `json:"response,omitempty"`
+}
+
+type BundleEntrySearch struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+type BundleEntryRequest struct {
+	Method  string `json:"method"`
+	URL     string `json:"url"`
+	IfMatch string `json:"ifMatch,omitempty"`
+}
+
+type BundleEntryResponse struct {
+	Status       string            `json:"status"`
+	Location     string            `json:"location,omitempty"`
+	Etag         string            `json:"etag,omitempty"`
+	LastModified string            `json:"lastModified,omitempty"`
+	Outcome      *OperationOutcome `json:"outcome,omitempty"`
+}
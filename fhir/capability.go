@@ -0,0 +1,61 @@
+package fhir
+
+const FhirVersion = "4.0.1"
+
+type CapabilityStatement struct {
+	ResourceType   string                    `json:"resourceType"`
+	Status         string                    `json:"status"`
+	Date           string                    `json:"date"`
+	Kind           string                    `json:"kind"`
+	Software       *CapabilitySoftware       `json:"software,omitempty"`
+	Implementation *CapabilityImplementation `json:"implementation,omitempty"`
+	FhirVersion    string                    `json:"fhirVersion"`
+	Format         []string                  `json:"format"`
+	PatchFormat    []string                  `json:"patchFormat,omitempty"`
+	Rest           []CapabilityStatementRest `json:"rest,omitempty"`
+}
+
+type CapabilitySoftware struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type CapabilityImplementation struct {
+	Description string `json:"description"`
+	URL         string `json:"url,omitempty"`
+}
+
+type CapabilityStatementRest struct {
+	Mode        string                  `json:"mode"`
+	Security    *CapabilitySecurity     `json:"security,omitempty"`
+	Resource    []CapabilityResource    `json:"resource,omitempty"`
+	Interaction []CapabilityInteraction `json:"interaction,omitempty"`
+}
+
+type CapabilitySecurity struct {
+	Cors        bool              `json:"cors"`
+	Service     []CodeableConcept `json:"service,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+type CapabilityResource struct {
+	Type              string                  `json:"type"`
+	Interaction       []CapabilityInteraction `json:"interaction,omitempty"`
+	Versioning        string                  `json:"versioning,omitempty"`
+	ConditionalCreate bool                    `json:"conditionalCreate,omitempty"`
+	ConditionalUpdate bool                    `json:"conditionalUpdate,omitempty"`
+	ConditionalDelete string                  `json:"conditionalDelete,omitempty"`
+	SearchInclude     []string                `json:"searchInclude,omitempty"`
+	SearchRevInclude  []string                `json:"searchRevInclude,omitempty"`
+	SearchParam       []CapabilitySearchParam `json:"searchParam,omitempty"`
+}
+
+type CapabilityInteraction struct {
+	Code string `json:"code"`
+}
+
+type CapabilitySearchParam struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Documentation string `json:"documentation,omitempty"`
+}
@@ -0,0 +1,130 @@
+// Package fhir contém os tipos de recursos e datatypes FHIR R4 expostos pela API.
+// Os documentos persistidos no MongoDB ficam em models e são convertidos para
+// estes tipos pela camada de serviços.
+package fhir
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// InstantFormat é o formato usado em meta.lastUpdated e nos dateTime emitidos.
+	InstantFormat = time.RFC3339
+
+	ActCodeSystem = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+
+	// HapiIdentifierSystem identifica o fhirId atribuído pelo servidor Hapi de origem.
+	HapiIdentifierSystem = "urn:hapi:fhir-id"
+)
+
+type Meta struct {
+	VersionID   string   `json:"versionId,omitempty"`
+	LastUpdated string   `json:"lastUpdated,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Tag         []Coding `json:"tag,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Identifier struct {
+	Use    string           `json:"use,omitempty"`
+	Type   *CodeableConcept `json:"type,omitempty"`
+	System string           `json:"system,omitempty"`
+	Value  string           `json:"value,omitempty"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Text   string   `json:"text,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Use    string `json:"use,omitempty"`
+}
+
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// FormatInstant converte um time.Time para o formato instant/dateTime do FHIR.
+// Retorna string vazia para o valor zero, permitindo omitir o elemento.
+func FormatInstant(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(InstantFormat)
+}
+
+// NewReference monta uma referência relativa no formato "Tipo/id".
+func NewReference(resourceType, id string) *Reference {
+	return &Reference{Reference: resourceType + "/" + id, Type: resourceType}
+}
+
+var datePattern = regexp.MustCompile(`^([0-9]([0-9]([0-9][1-9]|[1-9]0)|[1-9]00)|[1-9]000)(-(0[1-9]|1[0-2])(-(0[1-9]|[1-2][0-9]|3[0-1]))?)?$`)
+
+// ValidDate verifica se o valor é um date FHIR (YYYY, YYYY-MM ou YYYY-MM-DD) e,
+// quando completo, uma data existente no calendário.
+func ValidDate(value string) bool {
+	if !datePattern.MatchString(value) {
+		return false
+	}
+	if len(value) == len("2006-01-02") {
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	}
+	return true
+}
+
+// dateTimeLayouts são as precisões aceitas para date e dateTime FHIR.
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// ParseDateTime converte um date ou dateTime FHIR em time.Time (UTC).
+func ParseDateTime(value string) (time.Time, error) {
+	var lastErr error
+	for _, layout := range dateTimeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+		lastErr = err
+	}
+	return time.Time{}, lastErr
+}
+
+// WeakETag monta o ETag fraco W/"versionId" usado em leituras, escritas e Bundles.
+func WeakETag(versionID string) string {
+	return `W/"` + versionID + `"`
+}
+
+// ETagVersion extrai o versionId de W/"3", "3" ou 3.
+func ETagVersion(tag string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+}
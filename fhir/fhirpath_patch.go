@@ -0,0 +1,541 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FHIRPathOperation é uma operação de FHIRPath Patch já validada. Index,
+// Source e Destination só são usados por insert e move.
+type FHIRPathOperation struct {
+	Type        string
+	Path        string
+	Name        string
+	Value       interface{}
+	Index       int
+	Source      int
+	Destination int
+
+	steps []pathStep
+}
+
+// FHIRPathPatch é o patch baseado em Parameters definido pela especificação
+// FHIR (http://hl7.org/fhir/R4/fhirpatch.html). Os caminhos aceitam o
+// subconjunto de FHIRPath usado na prática: navegação por elementos,
+// indexadores, where(elemento='valor'), first() e last().
+type FHIRPathPatch []FHIRPathOperation
+
+// requiredParts lista as partes exigidas por tipo de operação.
+var requiredParts = map[string][]string{
+	"add":     {"path", "name", "value"},
+	"insert":  {"path", "index", "value"},
+	"delete":  {"path"},
+	"replace": {"path", "value"},
+	"move":    {"path", "source", "destination"},
+}
+
+// ParseFHIRPathPatch decodifica um Parameters com operações de FHIRPath Patch
+// e valida tipos, partes obrigatórias e caminhos.
+func ParseFHIRPathPatch(data []byte) (FHIRPathPatch, error) {
+	var parameters Parameters
+	if err := json.Unmarshal(data, &parameters); err != nil {
+		return nil, fmt.Errorf("FHIRPath Patch inválido: %v", err)
+	}
+	if parameters.ResourceType != "Parameters" {
+		return nil, fmt.Errorf("FHIRPath Patch deve ser um recurso Parameters")
+	}
+
+	patch := make(FHIRPathPatch, 0, len(parameters.Parameter))
+	for i, parameter := range parameters.Parameter {
+		if parameter.Name != "operation" {
+			return nil, fmt.Errorf("parâmetro %d: esperado operation, recebido %q", i, parameter.Name)
+		}
+		operation, err := parseFHIRPathOperation(parameter)
+		if err != nil {
+			return nil, fmt.Errorf("operação %d: %v", i, err)
+		}
+		patch = append(patch, operation)
+	}
+	return patch, nil
+}
+
+func parseFHIRPathOperation(parameter ParametersParameter) (FHIRPathOperation, error) {
+	var operation FHIRPathOperation
+
+	typePart, _ := parameter.Lookup("type")
+	if err := json.Unmarshal(typePart.Value, &operation.Type); err != nil || typePart.ValueType != "Code" {
+		return operation, fmt.Errorf("type deve ser informado como valueCode")
+	}
+	required, ok := requiredParts[operation.Type]
+	if !ok {
+		return operation, fmt.Errorf("type não suportado: %q", operation.Type)
+	}
+
+	for _, name := range required {
+		part, ok := parameter.Lookup(name)
+		if !ok {
+			return operation, fmt.Errorf("%s é obrigatório em %s", name, operation.Type)
+		}
+
+		var err error
+		switch name {
+		case "path":
+			err = json.Unmarshal(part.Value, &operation.Path)
+		case "name":
+			err = json.Unmarshal(part.Value, &operation.Name)
+		case "index":
+			err = json.Unmarshal(part.Value, &operation.Index)
+		case "source":
+			err = json.Unmarshal(part.Value, &operation.Source)
+		case "destination":
+			err = json.Unmarshal(part.Value, &operation.Destination)
+		case "value":
+			operation.Value, err = partValue(part)
+		}
+		if err != nil {
+			return operation, fmt.Errorf("%s inválido: %v", name, err)
+		}
+	}
+
+	steps, err := parseFHIRPath(operation.Path)
+	if err != nil {
+		return operation, err
+	}
+	operation.steps = steps
+	return operation, nil
+}
+
+// partValue converte o value[x] de uma parte, ou as suas sub-partes quando o
+// valor é um datatype complexo, em JSON genérico.
+func partValue(part ParametersParameter) (interface{}, error) {
+	if part.ValueType != "" {
+		return decodeJSON(part.Value)
+	}
+	if len(part.Part) == 0 {
+		return nil, fmt.Errorf("%s sem valor", part.Name)
+	}
+
+	object := map[string]interface{}{}
+	for _, sub := range part.Part {
+		value, err := partValue(sub)
+		if err != nil {
+			return nil, err
+		}
+		switch existing := object[sub.Name].(type) {
+		case nil:
+			object[sub.Name] = value
+		case []interface{}:
+			object[sub.Name] = append(existing, value)
+		default:
+			object[sub.Name] = []interface{}{existing, value}
+		}
+	}
+	return object, nil
+}
+
+// Apply aplica as operações ao documento JSON de um recurso. Qualquer falha
+// descarta o patch inteiro.
+func (p FHIRPathPatch) Apply(document []byte) ([]byte, error) {
+	decoded, err := decodeJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("o documento não é um recurso")
+	}
+	resourceType, _ := root["resourceType"].(string)
+	if resourceTypes[resourceType] == nil {
+		return nil, fmt.Errorf("tipo de recurso não suportado: %q", resourceType)
+	}
+
+	for i, operation := range p {
+		if err := operation.apply(root, resourceType); err != nil {
+			return nil, fmt.Errorf("operação %d (%s %s): %v", i, operation.Type, operation.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func (o FHIRPathOperation) apply(root map[string]interface{}, resourceType string) error {
+	switch o.Type {
+	case "add":
+		node, err := singleNode(root, resourceType, o.steps)
+		if err != nil {
+			return err
+		}
+		object, ok := node.value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("o caminho não aponta para um elemento complexo")
+		}
+		field, err := childType(node.typ, o.Name)
+		if err != nil {
+			return err
+		}
+		if field.Kind() == reflect.Slice {
+			list, _ := object[o.Name].([]interface{})
+			object[o.Name] = append(list, conform(o.Value, field.Elem()))
+			return nil
+		}
+		if _, exists := object[o.Name]; exists {
+			return fmt.Errorf("%s já possui valor, use replace", o.Name)
+		}
+		object[o.Name] = conform(o.Value, field)
+		return nil
+
+	case "insert", "move":
+		object, name, field, err := listParent(root, resourceType, o.steps)
+		if err != nil {
+			return err
+		}
+		list, _ := object[name].([]interface{})
+		var value interface{}
+		index := o.Index
+		if o.Type == "insert" {
+			value = conform(o.Value, field.Elem())
+		} else {
+			if o.Source < 0 || o.Source >= len(list) {
+				return fmt.Errorf("source fora do limite: %d", o.Source)
+			}
+			value = list[o.Source]
+			list = append(list[:o.Source:o.Source], list[o.Source+1:]...)
+			index = o.Destination
+		}
+		if index < 0 || index > len(list) {
+			return fmt.Errorf("índice fora do limite: %d", index)
+		}
+		object[name] = append(list[:index:index], append([]interface{}{value}, list[index:]...)...)
+		return nil
+
+	case "delete":
+		nodes, err := evaluatePath(root, resourceType, o.steps)
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(nodes) == 0:
+			return nil
+		case len(nodes) > 1:
+			return fmt.Errorf("o caminho aponta para %d elementos", len(nodes))
+		case nodes[0].remove == nil:
+			return fmt.Errorf("não é possível remover o recurso")
+		}
+		nodes[0].remove()
+		return nil
+
+	default:
+		node, err := singleNode(root, resourceType, o.steps)
+		if err != nil {
+			return err
+		}
+		if node.set == nil {
+			return fmt.Errorf("não é possível substituir o recurso")
+		}
+		node.set(conform(o.Value, node.typ))
+		return nil
+	}
+}
+
+// pathStep é um passo do caminho: um elemento ou uma função, com indexador
+// opcional (index -1 quando ausente).
+type pathStep struct {
+	name     string
+	function string
+	field    string
+	literal  string
+	index    int
+}
+
+func parseFHIRPath(path string) ([]pathStep, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, fmt.Errorf("path vazio")
+	}
+
+	var steps []pathStep
+	for _, token := range splitPath(path) {
+		step := pathStep{index: -1}
+
+		if open := strings.LastIndex(token, "["); open > 0 && strings.HasSuffix(token, "]") {
+			index, err := strconv.Atoi(token[open+1 : len(token)-1])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("indexador inválido em %q", token)
+			}
+			step.index = index
+			token = token[:open]
+		}
+
+		if open := strings.Index(token, "("); open > 0 && strings.HasSuffix(token, ")") {
+			step.function = token[:open]
+			argument := strings.TrimSpace(token[open+1 : len(token)-1])
+			switch step.function {
+			case "first", "last":
+				if argument != "" {
+					return nil, fmt.Errorf("%s() não recebe argumentos", step.function)
+				}
+			case "where":
+				field, literal, ok := strings.Cut(argument, "=")
+				field, literal = strings.TrimSpace(field), strings.TrimSpace(literal)
+				if !ok || !isIdentifier(field) || len(literal) < 2 || literal[0] != '\'' || literal[len(literal)-1] != '\'' {
+					return nil, fmt.Errorf("where suporta apenas elemento='valor': %q", argument)
+				}
+				step.field, step.literal = field, literal[1:len(literal)-1]
+			default:
+				return nil, fmt.Errorf("função não suportada: %s()", step.function)
+			}
+		} else if isIdentifier(token) {
+			step.name = token
+		} else {
+			return nil, fmt.Errorf("path inválido: %q", path)
+		}
+
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// splitPath separa o caminho nos pontos fora de parênteses e literais.
+func splitPath(path string) []string {
+	var tokens []string
+	depth, quoted, start := 0, false, 0
+	for i, r := range path {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == '.' && depth == 0:
+			tokens = append(tokens, strings.TrimSpace(path[start:i]))
+			start = i + 1
+		}
+	}
+	return append(tokens, strings.TrimSpace(path[start:]))
+}
+
+func isIdentifier(token string) bool {
+	if token == "" {
+		return false
+	}
+	for i, r := range token {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// pathNode é um elemento alcançado pelo caminho, com as funções que o
+// substituem ou removem no documento.
+type pathNode struct {
+	value  interface{}
+	typ    reflect.Type
+	set    func(interface{})
+	remove func()
+}
+
+func evaluatePath(root map[string]interface{}, resourceType string, steps []pathStep) ([]pathNode, error) {
+	first := steps[0]
+	if first.name == "" || !unicode.IsUpper(rune(first.name[0])) {
+		return nil, fmt.Errorf("o caminho deve começar pelo tipo do recurso (%s)", resourceType)
+	}
+	if first.name != resourceType {
+		return nil, fmt.Errorf("o caminho se refere a %s, não a %s", first.name, resourceType)
+	}
+
+	nodes := selectIndex([]pathNode{{value: root, typ: resourceTypes[resourceType]}}, first.index)
+	for _, step := range steps[1:] {
+		var err error
+		if nodes, err = evaluateStep(nodes, step); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func evaluateStep(nodes []pathNode, step pathStep) ([]pathNode, error) {
+	var result []pathNode
+
+	switch step.function {
+	case "":
+		for _, node := range nodes {
+			children, err := childNodes(node, step.name)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, children...)
+		}
+	case "where":
+		for _, node := range nodes {
+			object, ok := node.value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, err := childType(node.typ, step.field); err != nil {
+				return nil, err
+			}
+			if value, ok := object[step.field]; ok && fmt.Sprint(value) == step.literal {
+				result = append(result, node)
+			}
+		}
+	case "first":
+		result = nodes[:min(len(nodes), 1)]
+	case "last":
+		result = nodes[max(len(nodes)-1, 0):]
+	}
+
+	return selectIndex(result, step.index), nil
+}
+
+func selectIndex(nodes []pathNode, index int) []pathNode {
+	if index < 0 {
+		return nodes
+	}
+	if index >= len(nodes) {
+		return nil
+	}
+	return nodes[index : index+1]
+}
+
+// childNodes navega até o elemento name de node; listas são achatadas, como
+// na navegação FHIRPath.
+func childNodes(node pathNode, name string) ([]pathNode, error) {
+	object, ok := node.value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	field, err := childType(node.typ, name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := object[name]
+	if !ok {
+		return nil, nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return []pathNode{{
+			value:  value,
+			typ:    field,
+			set:    func(v interface{}) { object[name] = v },
+			remove: func() { delete(object, name) },
+		}}, nil
+	}
+
+	children := make([]pathNode, len(list))
+	for i, item := range list {
+		i := i
+		children[i] = pathNode{
+			value: item,
+			typ:   field.Elem(),
+			set:   func(v interface{}) { list[i] = v },
+			remove: func() {
+				if len(list) == 1 {
+					delete(object, name)
+					return
+				}
+				object[name] = append(list[:i:i], list[i+1:]...)
+			},
+		}
+	}
+	return children, nil
+}
+
+func singleNode(root map[string]interface{}, resourceType string, steps []pathStep) (pathNode, error) {
+	nodes, err := evaluatePath(root, resourceType, steps)
+	if err != nil {
+		return pathNode{}, err
+	}
+	if len(nodes) != 1 {
+		return pathNode{}, fmt.Errorf("o caminho deve apontar para um único elemento, encontrados %d", len(nodes))
+	}
+	return nodes[0], nil
+}
+
+// listParent resolve o caminho de insert e move: o elemento pai e o nome da
+// lista, que deve ser o último passo do caminho.
+func listParent(root map[string]interface{}, resourceType string, steps []pathStep) (map[string]interface{}, string, reflect.Type, error) {
+	last := steps[len(steps)-1]
+	if len(steps) < 2 || last.name == "" || last.index >= 0 {
+		return nil, "", nil, fmt.Errorf("o caminho deve terminar no nome da lista")
+	}
+
+	parent, err := singleNode(root, resourceType, steps[:len(steps)-1])
+	if err != nil {
+		return nil, "", nil, err
+	}
+	object, ok := parent.value.(map[string]interface{})
+	if !ok {
+		return nil, "", nil, fmt.Errorf("o caminho não aponta para um elemento complexo")
+	}
+	field, err := childType(parent.typ, last.name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if field.Kind() != reflect.Slice {
+		return nil, "", nil, fmt.Errorf("%s não é uma lista", last.name)
+	}
+	return object, last.name, field, nil
+}
+
+// childType devolve o tipo do elemento name em typ, sem ponteiros. Listas
+// mantêm o tipo slice para que o chamador saiba a cardinalidade.
+func childType(typ reflect.Type, name string) (reflect.Type, error) {
+	if typ != nil && typ.Kind() == reflect.Struct {
+		for i := 0; i < typ.NumField(); i++ {
+			if jsonName(typ.Field(i)) == name {
+				return derefType(typ.Field(i).Type), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("elemento desconhecido: %s", name)
+}
+
+func derefType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Slice {
+		return reflect.SliceOf(derefType(typ.Elem()))
+	}
+	return typ
+}
+
+// conform ajusta a cardinalidade do valor ao tipo de destino: valores únicos
+// viram listas onde o elemento repete, inclusive dentro de datatypes montados
+// a partir de parts.
+func conform(value interface{}, typ reflect.Type) interface{} {
+	switch typ.Kind() {
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		conformed := make([]interface{}, len(list))
+		for i, item := range list {
+			conformed[i] = conform(item, typ.Elem())
+		}
+		return conformed
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		for name, item := range object {
+			if field, err := childType(typ, name); err == nil {
+				object[name] = conform(item, field)
+			}
+		}
+		return object
+	default:
+		return value
+	}
+}
@@ -0,0 +1,383 @@
+package fhir
+
+import (
+	"strings"
+	"testing"
+)
+
+const patchPatient = `{
+	"resourceType": "Patient",
+	"id": "p1",
+	"gender": "female",
+	"identifier": [
+		{"system": "urn:cpf", "value": "1"},
+		{"system": "urn:cns", "value": "2"}
+	],
+	"name": [
+		{"use": "official", "family": "Silva", "given": ["Ana", "Maria"]},
+		{"use": "nickname", "given": ["Aninha"]}
+	]
+}`
+
+// fhirPathPatch monta o Parameters de um FHIRPath Patch com as operações informadas.
+func fhirPathPatch(operations ...string) []byte {
+	return []byte(`{"resourceType":"Parameters","parameter":[` + strings.Join(operations, ",") + `]}`)
+}
+
+// patchOperation monta uma operação com type e path; parts são as demais partes em JSON.
+func patchOperation(typ, path string, parts ...string) string {
+	all := append([]string{
+		`{"name":"type","valueCode":"` + typ + `"}`,
+		`{"name":"path","valueString":"` + path + `"}`,
+	}, parts...)
+	return `{"name":"operation","part":[` + strings.Join(all, ",") + `]}`
+}
+
+func TestFHIRPathPatchApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name: "add a single element",
+			operation: patchOperation("add", "Patient",
+				`{"name":"name","valueString":"birthDate"}`,
+				`{"name":"value","valueDate":"1990-01-01"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female","birthDate":"1990-01-01",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name: "add appends to a list",
+			operation: patchOperation("add", "Patient",
+				`{"name":"name","valueString":"identifier"}`,
+				`{"name":"value","part":[{"name":"system","valueUri":"urn:rg"},{"name":"value","valueString":"3"}]}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"},{"system":"urn:rg","value":"3"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name: "add a datatype conforms repeating parts to lists",
+			operation: patchOperation("add", "Patient",
+				`{"name":"name","valueString":"name"}`,
+				`{"name":"value","part":[{"name":"family","valueString":"Souza"},{"name":"given","valueString":"João"}]}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]},{"family":"Souza","given":["João"]}]}`,
+		},
+		{
+			name: "add through where()",
+			operation: patchOperation("add", "Patient.name.where(use='nickname')",
+				`{"name":"name","valueString":"given"}`,
+				`{"name":"value","valueString":"Nina"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha","Nina"]}]}`,
+		},
+		{
+			name: "add to an element that already has a value",
+			operation: patchOperation("add", "Patient",
+				`{"name":"name","valueString":"gender"}`,
+				`{"name":"value","valueCode":"male"}`),
+			wantErr: true,
+		},
+		{
+			name: "add an unknown element",
+			operation: patchOperation("add", "Patient",
+				`{"name":"name","valueString":"deceasedBoolean"}`,
+				`{"name":"value","valueBoolean":true}`),
+			wantErr: true,
+		},
+		{
+			name: "add to a path with several elements",
+			operation: patchOperation("add", "Patient.name",
+				`{"name":"name","valueString":"text"}`,
+				`{"name":"value","valueString":"Ana"}`),
+			wantErr: true,
+		},
+		{
+			name: "insert at the start of a list",
+			operation: patchOperation("insert", "Patient.identifier",
+				`{"name":"index","valueInteger":0}`,
+				`{"name":"value","part":[{"name":"system","valueUri":"urn:rg"},{"name":"value","valueString":"3"}]}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:rg","value":"3"},{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name: "insert through first()",
+			operation: patchOperation("insert", "Patient.name.first().given",
+				`{"name":"index","valueInteger":1}`,
+				`{"name":"value","valueString":"Clara"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Clara","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name: "insert at the end of a list",
+			operation: patchOperation("insert", "Patient.name[1].given",
+				`{"name":"index","valueInteger":1}`,
+				`{"name":"value","valueString":"Nina"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha","Nina"]}]}`,
+		},
+		{
+			name: "insert past the end of a list",
+			operation: patchOperation("insert", "Patient.identifier",
+				`{"name":"index","valueInteger":3}`,
+				`{"name":"value","part":[{"name":"value","valueString":"3"}]}`),
+			wantErr: true,
+		},
+		{
+			name: "insert into an element that is not a list",
+			operation: patchOperation("insert", "Patient.gender",
+				`{"name":"index","valueInteger":0}`,
+				`{"name":"value","valueCode":"male"}`),
+			wantErr: true,
+		},
+		{
+			name:      "delete through where()",
+			operation: patchOperation("delete", "Patient.identifier.where(system='urn:cns')"),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name:      "delete by index",
+			operation: patchOperation("delete", "Patient.name[1]"),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]}]}`,
+		},
+		{
+			name:      "delete the last item removes the list",
+			operation: patchOperation("delete", "Patient.name[1].given[0]"),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname"}]}`,
+		},
+		{
+			name:      "delete a single element",
+			operation: patchOperation("delete", "Patient.gender"),
+			want: `{"resourceType":"Patient","id":"p1",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name:      "delete a missing element is a no-op",
+			operation: patchOperation("delete", "Patient.birthDate"),
+			want:      patchPatient,
+		},
+		{
+			name:      "delete several elements",
+			operation: patchOperation("delete", "Patient.identifier"),
+			wantErr:   true,
+		},
+		{
+			name:      "delete the resource",
+			operation: patchOperation("delete", "Patient"),
+			wantErr:   true,
+		},
+		{
+			name:      "replace a single element",
+			operation: patchOperation("replace", "Patient.gender", `{"name":"value","valueCode":"male"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"male",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name:      "replace through where()",
+			operation: patchOperation("replace", "Patient.name.where(use='official').family", `{"name":"value","valueString":"Souza"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Souza","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name:      "replace through first() and last()",
+			operation: patchOperation("replace", "Patient.name.first().given.last()", `{"name":"value","valueString":"Mariana"}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Mariana"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name:      "replace a missing element",
+			operation: patchOperation("replace", "Patient.birthDate", `{"name":"value","valueDate":"1990-01-01"}`),
+			wantErr:   true,
+		},
+		{
+			name:      "replace where() without matches",
+			operation: patchOperation("replace", "Patient.name.where(use='maiden').family", `{"name":"value","valueString":"Souza"}`),
+			wantErr:   true,
+		},
+		{
+			name:      "replace the resource",
+			operation: patchOperation("replace", "Patient", `{"name":"value","valueString":"x"}`),
+			wantErr:   true,
+		},
+		{
+			name: "move inside a list",
+			operation: patchOperation("move", "Patient.identifier",
+				`{"name":"source","valueInteger":0}`,
+				`{"name":"destination","valueInteger":1}`),
+			want: `{"resourceType":"Patient","id":"p1","gender":"female",
+				"identifier":[{"system":"urn:cns","value":"2"},{"system":"urn:cpf","value":"1"}],
+				"name":[{"use":"official","family":"Silva","given":["Ana","Maria"]},{"use":"nickname","given":["Aninha"]}]}`,
+		},
+		{
+			name: "move with source out of bounds",
+			operation: patchOperation("move", "Patient.identifier",
+				`{"name":"source","valueInteger":2}`,
+				`{"name":"destination","valueInteger":0}`),
+			wantErr: true,
+		},
+		{
+			name: "move with destination out of bounds",
+			operation: patchOperation("move", "Patient.identifier",
+				`{"name":"source","valueInteger":0}`,
+				`{"name":"destination","valueInteger":2}`),
+			wantErr: true,
+		},
+		{
+			name:      "where() on an unknown element",
+			operation: patchOperation("delete", "Patient.name.where(period='x')"),
+			wantErr:   true,
+		},
+		{
+			name:      "path for another resource type",
+			operation: patchOperation("delete", "Practitioner.gender"),
+			wantErr:   true,
+		},
+		{
+			name:      "path not starting at the resource",
+			operation: patchOperation("delete", "gender"),
+			wantErr:   true,
+		},
+		{
+			name:      "navigation to an unknown element",
+			operation: patchOperation("delete", "Patient.telecom"),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := ParseFHIRPathPatch(fhirPathPatch(tt.operation))
+			if err != nil {
+				t.Fatalf("ParseFHIRPathPatch: %v", err)
+			}
+			got, err := patch.Apply([]byte(patchPatient))
+			if tt.wantErr {
+				if err == nil || got != nil {
+					t.Fatalf("Apply = %s, %v, want no result and an error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestFHIRPathPatchAppliesInOrder(t *testing.T) {
+	patch, err := ParseFHIRPathPatch(fhirPathPatch(
+		patchOperation("delete", "Patient.name[0]"),
+		patchOperation("replace", "Patient.name.first().use", `{"name":"value","valueCode":"official"}`),
+	))
+	if err != nil {
+		t.Fatalf("ParseFHIRPathPatch: %v", err)
+	}
+	got, err := patch.Apply([]byte(patchPatient))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertJSONEqual(t, got, `{"resourceType":"Patient","id":"p1","gender":"female",
+		"identifier":[{"system":"urn:cpf","value":"1"},{"system":"urn:cns","value":"2"}],
+		"name":[{"use":"official","given":["Aninha"]}]}`)
+
+	patch, err = ParseFHIRPathPatch(fhirPathPatch(
+		patchOperation("replace", "Patient.gender", `{"name":"value","valueCode":"male"}`),
+		patchOperation("replace", "Patient.birthDate", `{"name":"value","valueDate":"1990-01-01"}`),
+	))
+	if err != nil {
+		t.Fatalf("ParseFHIRPathPatch: %v", err)
+	}
+	if got, err := patch.Apply([]byte(patchPatient)); err == nil {
+		t.Errorf("Apply = %s, want the failing second operation to discard the patch", got)
+	}
+}
+
+func TestFHIRPathPatchRejectsDocument(t *testing.T) {
+	patch, err := ParseFHIRPathPatch(fhirPathPatch(patchOperation("delete", "Observation.status")))
+	if err != nil {
+		t.Fatalf("ParseFHIRPathPatch: %v", err)
+	}
+	for _, document := range []string{
+		`{"resourceType":"Observation","status":"final"}`,
+		`["Patient"]`,
+		`{"resourceType":`,
+	} {
+		if got, err := patch.Apply([]byte(document)); err == nil {
+			t.Errorf("Apply(%s) = %s, want error", document, got)
+		}
+	}
+}
+
+func TestParseFHIRPathPatchRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch []byte
+	}{
+		{"not JSON", []byte(`{"resourceType":`)},
+		{"not a Parameters", []byte(`{"resourceType":"Patient"}`)},
+		{"parameter other than operation", []byte(`{"resourceType":"Parameters","parameter":[{"name":"op","part":[]}]}`)},
+		{"type as valueString", fhirPathPatch(`{"name":"operation","part":[{"name":"type","valueString":"delete"},{"name":"path","valueString":"Patient.gender"}]}`)},
+		{"unknown type", fhirPathPatch(patchOperation("remove", "Patient.gender"))},
+		{"missing path", fhirPathPatch(`{"name":"operation","part":[{"name":"type","valueCode":"delete"}]}`)},
+		{"add without name", fhirPathPatch(patchOperation("add", "Patient", `{"name":"value","valueCode":"male"}`))},
+		{"replace without value", fhirPathPatch(patchOperation("replace", "Patient.gender"))},
+		{"value without value[x] or parts", fhirPathPatch(patchOperation("replace", "Patient.gender", `{"name":"value"}`))},
+		{"insert without index", fhirPathPatch(patchOperation("insert", "Patient.identifier", `{"name":"value","valueString":"x"}`))},
+		{"move without destination", fhirPathPatch(patchOperation("move", "Patient.identifier", `{"name":"source","valueInteger":0}`))},
+		{"index that is not an integer", fhirPathPatch(patchOperation("insert", "Patient.identifier", `{"name":"index","valueString":"0"}`, `{"name":"value","valueString":"x"}`))},
+		{"empty path", fhirPathPatch(patchOperation("delete", " "))},
+		{"empty path step", fhirPathPatch(patchOperation("delete", "Patient..gender"))},
+		{"negative indexer", fhirPathPatch(patchOperation("delete", "Patient.name[-1]"))},
+		{"unsupported function", fhirPathPatch(patchOperation("delete", "Patient.name.exists()"))},
+		{"first() with argument", fhirPathPatch(patchOperation("delete", "Patient.name.first(1)"))},
+		{"where() with another operator", fhirPathPatch(patchOperation("delete", "Patient.name.where(use!='official')"))},
+		{"where() without a quoted literal", fhirPathPatch(patchOperation("delete", "Patient.name.where(use=official)"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if patch, err := ParseFHIRPathPatch(tt.patch); err == nil {
+				t.Errorf("ParseFHIRPathPatch accepted %s: %+v", tt.patch, patch)
+			}
+		})
+	}
+}
+
+func TestParseFHIRPath(t *testing.T) {
+	steps, err := parseFHIRPath("Patient.name.where(use = 'a.b(c)').given[1].first()")
+	if err != nil {
+		t.Fatalf("parseFHIRPath: %v", err)
+	}
+	want := []pathStep{
+		{name: "Patient", index: -1},
+		{name: "name", index: -1},
+		{function: "where", field: "use", literal: "a.b(c)", index: -1},
+		{name: "given", index: 1},
+		{function: "first", index: -1},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("parseFHIRPath = %+v, want %+v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d = %+v, want %+v", i, steps[i], want[i])
+		}
+	}
+}
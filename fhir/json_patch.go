@@ -0,0 +1,325 @@
+package fhir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONPatchOperation é uma operação de JSON Patch (RFC 6902).
+type JSONPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch é um documento JSON Patch (RFC 6902): uma lista de operações
+// aplicadas em sequência.
+type JSONPatch []JSONPatchOperation
+
+// ParseJSONPatch decodifica o documento e valida as operações antes de qualquer
+// aplicação, separando um patch malformado de um que não se aplica ao recurso.
+func ParseJSONPatch(data []byte) (JSONPatch, error) {
+	var patch JSONPatch
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, fmt.Errorf("JSON Patch inválido: %v", err)
+	}
+
+	for i, operation := range patch {
+		if _, err := parsePointer(operation.Path); err != nil {
+			return nil, fmt.Errorf("operação %d: %v", i, err)
+		}
+		switch operation.Op {
+		case "add", "replace", "test":
+			if len(operation.Value) == 0 {
+				return nil, fmt.Errorf("operação %d: value é obrigatório em %s", i, operation.Op)
+			}
+		case "move", "copy":
+			if _, err := parsePointer(operation.From); err != nil || operation.From == "" {
+				return nil, fmt.Errorf("operação %d: from inválido em %s", i, operation.Op)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("operação %d: op não suportada: %q", i, operation.Op)
+		}
+	}
+	return patch, nil
+}
+
+// Apply aplica as operações ao documento JSON. Qualquer falha, inclusive um
+// test que não confere, descarta o patch inteiro.
+func (p JSONPatch) Apply(document []byte) ([]byte, error) {
+	root, err := decodeJSON(document)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, operation := range p {
+		if root, err = operation.apply(root); err != nil {
+			return nil, fmt.Errorf("operação %d (%s %s): %v", i, operation.Op, operation.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func (o JSONPatchOperation) apply(root interface{}) (interface{}, error) {
+	path, err := parsePointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.Op {
+	case "add", "replace", "test":
+		value, err := decodeJSON(o.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch o.Op {
+		case "add":
+			return pointerAdd(root, path, value)
+		case "replace":
+			if len(path) == 0 {
+				return value, nil
+			}
+			if _, err := pointerRemove(root, path); err != nil {
+				return nil, err
+			}
+			return pointerAdd(root, path, value)
+		default:
+			current, err := pointerGet(root, path)
+			if err != nil {
+				return nil, err
+			}
+			if !jsonEqual(current, value) {
+				return nil, fmt.Errorf("test falhou: valor diferente do esperado")
+			}
+			return root, nil
+		}
+	case "remove":
+		return pointerRemove(root, path)
+	default:
+		from, _ := parsePointer(o.From)
+		value, err := pointerGet(root, from)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			if isPrefix(from, path) {
+				return nil, fmt.Errorf("não é possível mover um elemento para dentro de si mesmo")
+			}
+			if root, err = pointerRemove(root, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = cloneJSON(value)
+		}
+		return pointerAdd(root, path, value)
+	}
+}
+
+// parsePointer separa um JSON Pointer (RFC 6901) em tokens já sem escape.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("JSON Pointer inválido: %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func pointerGet(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch current := node.(type) {
+		case map[string]interface{}:
+			value, ok := current[token]
+			if !ok {
+				return nil, fmt.Errorf("caminho inexistente: %s", token)
+			}
+			node = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(current), false)
+			if err != nil {
+				return nil, err
+			}
+			node = current[index]
+		default:
+			return nil, fmt.Errorf("caminho inexistente: %s", token)
+		}
+	}
+	return node, nil
+}
+
+// pointerAdd insere value no caminho e devolve a nova raiz; arrays recebem o
+// valor na posição indicada ("-" acrescenta ao final).
+func pointerAdd(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+		return root, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(container), true)
+		if err != nil {
+			return nil, err
+		}
+		updated := append(container[:index:index], append([]interface{}{value}, container[index:]...)...)
+		return replaceAt(root, path[:len(path)-1], updated)
+	default:
+		return nil, fmt.Errorf("o destino de %s não é objeto nem array", token)
+	}
+}
+
+func pointerRemove(root interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("não é possível remover a raiz")
+	}
+
+	parent, err := pointerGet(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := container[token]; !ok {
+			return nil, fmt.Errorf("caminho inexistente: %s", token)
+		}
+		delete(container, token)
+		return root, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		updated := append(container[:index:index], container[index+1:]...)
+		return replaceAt(root, path[:len(path)-1], updated)
+	default:
+		return nil, fmt.Errorf("caminho inexistente: %s", token)
+	}
+}
+
+// replaceAt substitui o nó no caminho; usado quando um array muda de tamanho e
+// precisa ser regravado no objeto (ou array) que o contém.
+func replaceAt(root interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+	case []interface{}:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = value
+	}
+	return root, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("índice de array inválido: %s", token)
+	}
+
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("índice de array fora do limite: %d", index)
+	}
+	return index, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("JSON inválido: %v", err)
+	}
+	return value, nil
+}
+
+func cloneJSON(value interface{}) interface{} {
+	data, _ := json.Marshal(value)
+	clone, _ := decodeJSON(data)
+	return clone
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+// normalizeJSON converte json.Number em float64 para que 1 e 1.0 sejam iguais.
+func normalizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = normalizeJSON(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeJSON(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
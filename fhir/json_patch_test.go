@@ -0,0 +1,383 @@
+package fhir
+
+import (
+	"testing"
+)
+
+func applyJSONPatch(document, patch string) ([]byte, error) {
+	operations, err := ParseJSONPatch([]byte(patch))
+	if err != nil {
+		return nil, err
+	}
+	return operations.Apply([]byte(document))
+}
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	gotValue, err := decodeJSON(got)
+	if err != nil {
+		t.Fatalf("result is not JSON: %v", err)
+	}
+	wantValue, err := decodeJSON([]byte(want))
+	if err != nil {
+		t.Fatalf("expected value is not JSON: %v", err)
+	}
+	if !jsonEqual(gotValue, wantValue) {
+		t.Errorf("result = %s, want %s", got, want)
+	}
+}
+
+// TestJSONPatchRFC6902Examples cobre os exemplos do apêndice A da RFC 6902.
+func TestJSONPatchRFC6902Examples(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "A.1 adding an object member",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:     `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:     "A.2 adding an array element",
+			document: `{"foo":["bar","baz"]}`,
+			patch:    `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:     `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:     "A.3 removing an object member",
+			document: `{"baz":"qux","foo":"bar"}`,
+			patch:    `[{"op":"remove","path":"/baz"}]`,
+			want:     `{"foo":"bar"}`,
+		},
+		{
+			name:     "A.4 removing an array element",
+			document: `{"foo":["bar","qux","baz"]}`,
+			patch:    `[{"op":"remove","path":"/foo/1"}]`,
+			want:     `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:     "A.5 replacing a value",
+			document: `{"baz":"qux","foo":"bar"}`,
+			patch:    `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:     `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:     "A.6 moving a value",
+			document: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch:    `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:     `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:     "A.7 moving an array element",
+			document: `{"foo":["all","grass","cows","eat"]}`,
+			patch:    `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:     `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:     "A.8 testing a value: success",
+			document: `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch:    `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:     `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:     "A.9 testing a value: error",
+			document: `{"baz":"qux"}`,
+			patch:    `[{"op":"test","path":"/baz","value":"bar"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "A.10 adding a nested member object",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:     `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:     "A.11 ignoring unrecognized elements",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:     `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:     "A.12 adding to a nonexistent target",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "A.13 invalid JSON Patch document",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "A.14 ~ escape ordering",
+			document: `{"/":9,"~1":10}`,
+			patch:    `[{"op":"test","path":"/~01","value":10}]`,
+			want:     `{"/":9,"~1":10}`,
+		},
+		{
+			name:     "A.15 comparing strings and numbers",
+			document: `{"/":9,"~1":10}`,
+			patch:    `[{"op":"test","path":"/~01","value":"10"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "A.16 adding an array value",
+			document: `{"foo":["bar"]}`,
+			patch:    `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:     `{"foo":["bar",["abc","def"]]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch(tt.document, tt.patch)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("patch applied, want error; result = %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestJSONPatchApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "pointer escapes ~1 as slash",
+			document: `{"a/b":1}`,
+			patch:    `[{"op":"replace","path":"/a~1b","value":2}]`,
+			want:     `{"a/b":2}`,
+		},
+		{
+			name:     "pointer escapes ~0 as tilde",
+			document: `{"m~n":1}`,
+			patch:    `[{"op":"remove","path":"/m~0n"}]`,
+			want:     `{}`,
+		},
+		{
+			name:     "empty key",
+			document: `{"":1}`,
+			patch:    `[{"op":"replace","path":"/","value":2}]`,
+			want:     `{"":2}`,
+		},
+		{
+			name:     "add appends with -",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"add","path":"/foo/-","value":3}]`,
+			want:     `{"foo":[1,2,3]}`,
+		},
+		{
+			name:     "add at array length",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"add","path":"/foo/2","value":3}]`,
+			want:     `{"foo":[1,2,3]}`,
+		},
+		{
+			name:     "add past array length",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"add","path":"/foo/3","value":3}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "remove at array length",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"remove","path":"/foo/2"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "remove with -",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"remove","path":"/foo/-"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "index with leading zero",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"replace","path":"/foo/01","value":3}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "negative index",
+			document: `{"foo":[1,2]}`,
+			patch:    `[{"op":"add","path":"/foo/-1","value":3}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "add inside a nested array",
+			document: `{"m":[[1],[2]]}`,
+			patch:    `[{"op":"add","path":"/m/1/-","value":3}]`,
+			want:     `{"m":[[1],[2,3]]}`,
+		},
+		{
+			name:     "add replaces an existing member",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/foo","value":"baz"}]`,
+			want:     `{"foo":"baz"}`,
+		},
+		{
+			name:     "add null value",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":null}]`,
+			want:     `{"foo":"bar","baz":null}`,
+		},
+		{
+			name:     "replace is remove then add",
+			document: `{"foo":[1,2,3]}`,
+			patch:    `[{"op":"replace","path":"/foo/1","value":9}]`,
+			want:     `{"foo":[1,9,3]}`,
+		},
+		{
+			name:     "replace a missing member",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"replace","path":"/baz","value":"qux"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "replace the root",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"replace","path":"","value":{"baz":1}}]`,
+			want:     `{"baz":1}`,
+		},
+		{
+			name:     "remove the root",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"remove","path":""}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "move into its own child",
+			document: `{"a":{"b":{}}}`,
+			patch:    `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "move to the same location",
+			document: `{"a":{"b":1}}`,
+			patch:    `[{"op":"move","from":"/a","path":"/a"}]`,
+			want:     `{"a":{"b":1}}`,
+		},
+		{
+			name:     "move from a missing location",
+			document: `{"a":1}`,
+			patch:    `[{"op":"move","from":"/b","path":"/c"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "copy is independent from the source",
+			document: `{"a":{"x":1}}`,
+			patch:    `[{"op":"copy","from":"/a","path":"/b"},{"op":"replace","path":"/b/x","value":2}]`,
+			want:     `{"a":{"x":1},"b":{"x":2}}`,
+		},
+		{
+			name:     "test compares numbers by value",
+			document: `{"n":1}`,
+			patch:    `[{"op":"test","path":"/n","value":1.0}]`,
+			want:     `{"n":1}`,
+		},
+		{
+			name:     "test compares objects regardless of key order",
+			document: `{"o":{"a":1,"b":[1,2]}}`,
+			patch:    `[{"op":"test","path":"/o","value":{"b":[1,2],"a":1}}]`,
+			want:     `{"o":{"a":1,"b":[1,2]}}`,
+		},
+		{
+			name:     "test with different array order",
+			document: `{"a":[1,2]}`,
+			patch:    `[{"op":"test","path":"/a","value":[2,1]}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "a failing operation discards earlier ones",
+			document: `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz","value":1},{"op":"test","path":"/foo","value":"qux"}]`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch(tt.document, tt.patch)
+			if tt.wantErr {
+				if err == nil || got != nil {
+					t.Fatalf("Apply = %s, %v, want no result and an error", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertJSONEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestParseJSONPatchRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"not JSON", `[{"op":`},
+		{"not an array", `{"op":"add","path":"/a","value":1}`},
+		{"unknown op", `[{"op":"merge","path":"/a","value":1}]`},
+		{"missing op", `[{"path":"/a","value":1}]`},
+		{"pointer without leading slash", `[{"op":"remove","path":"a"}]`},
+		{"add without value", `[{"op":"add","path":"/a"}]`},
+		{"replace without value", `[{"op":"replace","path":"/a"}]`},
+		{"test without value", `[{"op":"test","path":"/a"}]`},
+		{"move without from", `[{"op":"move","path":"/a"}]`},
+		{"copy with invalid from", `[{"op":"copy","from":"a","path":"/b"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if patch, err := ParseJSONPatch([]byte(tt.patch)); err == nil {
+				t.Errorf("ParseJSONPatch accepted %s: %+v", tt.patch, patch)
+			}
+		})
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+	}{
+		{"", nil},
+		{"/", []string{""}},
+		{"/foo/0", []string{"foo", "0"}},
+		{"/a~1b/m~0n", []string{"a/b", "m~n"}},
+		{"/~01", []string{"~1"}},
+		{"/~10", []string{"/0"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePointer(tt.pointer)
+		if err != nil {
+			t.Errorf("parsePointer(%q): %v", tt.pointer, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+				break
+			}
+		}
+	}
+}
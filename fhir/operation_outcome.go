@@ -0,0 +1,38 @@
+package fhir
+
+// ErrorCodeSystem identifica os códigos internos de erro (models.AppError.Code)
+// publicados em OperationOutcome.issue.details.
+const ErrorCodeSystem = "urn:fhir-api:error-code"
+
+type OperationOutcome struct {
+	ResourceType string                  `json:"resourceType"`
+	Issue        []OperationOutcomeIssue `json:"issue"`
+}
+
+type OperationOutcomeIssue struct {
+	Severity    string           `json:"severity"`
+	Code        string           `json:"code"`
+	Details     *CodeableConcept `json:"details,omitempty"`
+	Diagnostics string           `json:"diagnostics,omitempty"`
+	Expression  []string         `json:"expression,omitempty"`
+}
+
+// NewOperationOutcome cria um OperationOutcome com uma única issue.
+func NewOperationOutcome(severity, code, errorCode, diagnostics string) *OperationOutcome {
+	issue := OperationOutcomeIssue{
+		Severity:    severity,
+		Code:        code,
+		Diagnostics: diagnostics,
+	}
+
+	if errorCode != "" {
+		issue.Details = &CodeableConcept{
+			Coding: []Coding{{System: ErrorCodeSystem, Code: errorCode}},
+		}
+	}
+
+	return &OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue:        []OperationOutcomeIssue{issue},
+	}
+}
@@ -0,0 +1,68 @@
+package fhir
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Parameters é o recurso usado como entrada e saída de operações, e como corpo
+// do FHIRPath Patch.
+type Parameters struct {
+	ResourceType string                `json:"resourceType"`
+	Parameter    []ParametersParameter `json:"parameter,omitempty"`
+}
+
+// ParametersParameter é um parâmetro nomeado. Value guarda o value[x] tal como
+// recebido e ValueType o sufixo do elemento (String, Code, Coding...).
+type ParametersParameter struct {
+	Name      string                `json:"name"`
+	ValueType string                `json:"-"`
+	Value     json.RawMessage       `json:"-"`
+	Part      []ParametersParameter `json:"part,omitempty"`
+}
+
+func (p *ParametersParameter) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	*p = ParametersParameter{}
+	for key, raw := range fields {
+		switch {
+		case key == "name":
+			if err := json.Unmarshal(raw, &p.Name); err != nil {
+				return err
+			}
+		case key == "part":
+			if err := json.Unmarshal(raw, &p.Part); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, "value") && len(key) > len("value"):
+			p.ValueType = strings.TrimPrefix(key, "value")
+			p.Value = raw
+		}
+	}
+	return nil
+}
+
+func (p ParametersParameter) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{"name": p.Name}
+	if p.ValueType != "" {
+		fields["value"+p.ValueType] = p.Value
+	}
+	if len(p.Part) > 0 {
+		fields["part"] = p.Part
+	}
+	return json.Marshal(fields)
+}
+
+// Lookup devolve a primeira parte com o nome informado.
+func (p ParametersParameter) Lookup(name string) (ParametersParameter, bool) {
+	for _, part := range p.Part {
+		if part.Name == name {
+			return part, true
+		}
+	}
+	return ParametersParameter{}, false
+}
@@ -0,0 +1,80 @@
+package fhir
+
+type Patient struct {
+	ResourceType string       `json:"resourceType"`
+	ID           string       `json:"id,omitempty"`
+	Meta         *Meta        `json:"meta,omitempty"`
+	Identifier   []Identifier `json:"identifier,omitempty"`
+	Name         []HumanName  `json:"name,omitempty"`
+	Gender       string       `json:"gender,omitempty"`
+	BirthDate    string       `json:"birthDate,omitempty"`
+}
+
+type Practitioner struct {
+	ResourceType  string                      `json:"resourceType"`
+	ID            string                      `json:"id,omitempty"`
+	Meta          *Meta                       `json:"meta,omitempty"`
+	Identifier    []Identifier                `json:"identifier,omitempty"`
+	Name          []HumanName                 `json:"name,omitempty"`
+	Telecom       []ContactPoint              `json:"telecom,omitempty"`
+	Gender        string                      `json:"gender,omitempty"`
+	BirthDate     string                      `json:"birthDate,omitempty"`
+	Qualification []PractitionerQualification `json:"qualification,omitempty"`
+}
+
+type PractitionerQualification struct {
+	Identifier []Identifier     `json:"identifier,omitempty"`
+	Code       *CodeableConcept `json:"code,omitempty"`
+	Period     *Period          `json:"period,omitempty"`
+	Issuer     *Reference       `json:"issuer,omitempty"`
+}
+
+type Encounter struct {
+	ResourceType  string                   `json:"resourceType"`
+	ID            string                   `json:"id,omitempty"`
+	Meta          *Meta                    `json:"meta,omitempty"`
+	Identifier    []Identifier             `json:"identifier,omitempty"`
+	Status        string                   `json:"status,omitempty"`
+	StatusHistory []EncounterStatusHistory `json:"statusHistory,omitempty"`
+	Class         *Coding                  `json:"class,omitempty"`
+	Subject       *Reference               `json:"subject,omitempty"`
+	Participant   []EncounterParticipant   `json:"participant,omitempty"`
+	Period        *Period                  `json:"period,omitempty"`
+}
+
+type EncounterStatusHistory struct {
+	Status string `json:"status"`
+	Period Period `json:"period"`
+}
+
+type EncounterParticipant struct {
+	Individual *Reference `json:"individual,omitempty"`
+}
+
+// Binary transporta conteúdo arbitrário; em Bundles, o corpo de um JSON Patch.
+// Data é serializado em base64.
+type Binary struct {
+	ResourceType string `json:"resourceType"`
+	ContentType  string `json:"contentType"`
+	Data         []byte `json:"data,omitempty"`
+}
+
+// Resource é implementado pelos recursos servidos pela API, permitindo montar
+// Bundles e referências sem conhecer o tipo concreto.
+type Resource interface {
+	ResourceTypeName() string
+	ResourceID() string
+	ResourceMeta() *Meta
+}
+
+func (p *Patient) ResourceTypeName() string { return "Patient" }
+func (p *Patient) ResourceID() string       { return p.ID }
+func (p *Patient) ResourceMeta() *Meta      { return p.Meta }
+
+func (p *Practitioner) ResourceTypeName() string { return "Practitioner" }
+func (p *Practitioner) ResourceID() string       { return p.ID }
+func (p *Practitioner) ResourceMeta() *Meta      { return p.Meta }
+
+func (e *Encounter) ResourceTypeName() string { return "Encounter" }
+func (e *Encounter) ResourceID() string       { return e.ID }
+func (e *Encounter) ResourceMeta() *Meta      { return e.Meta }
@@ -0,0 +1,165 @@
+package fhir
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+const (
+	SummaryTrue  = "true"
+	SummaryText  = "text"
+	SummaryData  = "data"
+	SummaryCount = "count"
+	SummaryFalse = "false"
+)
+
+// SubsettedTag marca recursos devolvidos parcialmente (_elements/_summary).
+var SubsettedTag = Coding{
+	System:  "http://terminology.hl7.org/CodeSystem/v3-ObservationValue",
+	Code:    "SUBSETTED",
+	Display: "subsetted",
+}
+
+// resourceTypes registra os recursos servidos, usado para validar os elementos pedidos.
+var resourceTypes = map[string]reflect.Type{
+	"Patient":      reflect.TypeOf(Patient{}),
+	"Practitioner": reflect.TypeOf(Practitioner{}),
+	"Encounter":    reflect.TypeOf(Encounter{}),
+}
+
+// mandatoryElements são mantidos em qualquer subconjunto: os elementos de
+// controle e os de cardinalidade mínima 1 de cada recurso.
+var mandatoryElements = map[string][]string{
+	"Patient":      {},
+	"Practitioner": {},
+	"Encounter":    {"status", "class"},
+}
+
+// summaryElements são os elementos marcados como isSummary na especificação R4.
+var summaryElements = map[string][]string{
+	"Patient":      {"identifier", "active", "name", "telecom", "gender", "birthDate", "deceased", "address", "managingOrganization", "link"},
+	"Practitioner": {"identifier", "active", "name", "telecom", "address", "gender", "birthDate"},
+	"Encounter":    {"identifier", "status", "class", "type", "serviceType", "priority", "subject", "episodeOfCare", "basedOn", "participant", "appointment", "period", "length", "reasonCode", "reasonReference", "diagnosis", "account", "serviceProvider", "partOf"},
+}
+
+// Subset representa os parâmetros _elements e _summary de uma requisição.
+// O valor zero devolve o recurso completo.
+type Subset struct {
+	Elements []string
+	Summary  string
+}
+
+// ParseSubset lê _elements e _summary da query, validando os elementos contra
+// a definição do recurso informado.
+func ParseSubset(query url.Values, resourceType string) (*Subset, error) {
+	subset := &Subset{Summary: query.Get("_summary")}
+
+	switch subset.Summary {
+	case "", SummaryTrue, SummaryText, SummaryData, SummaryCount, SummaryFalse:
+	default:
+		return nil, fmt.Errorf("_summary inválido: %s", subset.Summary)
+	}
+
+	if value := query.Get("_elements"); value != "" {
+		if subset.Summary != "" && subset.Summary != SummaryFalse {
+			return nil, fmt.Errorf("_elements não pode ser combinado com _summary=%s", subset.Summary)
+		}
+
+		valid := elementNames(resourceTypes[resourceType])
+		for _, element := range strings.Split(value, ",") {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			if !valid[element] {
+				return nil, fmt.Errorf("elemento inválido para %s: %s", resourceType, element)
+			}
+			subset.Elements = append(subset.Elements, element)
+		}
+	}
+
+	return subset, nil
+}
+
+// Apply devolve uma cópia do recurso contendo apenas os elementos selecionados,
+// com meta.tag SUBSETTED. Sem seleção, devolve o próprio recurso.
+func (s *Subset) Apply(resource Resource) Resource {
+	keep := s.keep(resource.ResourceTypeName())
+	if keep == nil {
+		return resource
+	}
+
+	original := reflect.ValueOf(resource).Elem()
+	copied := reflect.New(original.Type())
+	copied.Elem().Set(original)
+
+	value := copied.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := jsonName(value.Type().Field(i))
+		if name != "" && !keep[name] {
+			value.Field(i).Set(reflect.Zero(value.Field(i).Type()))
+		}
+	}
+
+	if field := value.FieldByName("Meta"); field.IsValid() {
+		meta := &Meta{}
+		if current, ok := field.Interface().(*Meta); ok && current != nil {
+			*meta = *current
+		}
+		meta.Tag = append(append([]Coding{}, meta.Tag...), SubsettedTag)
+		field.Set(reflect.ValueOf(meta))
+	}
+
+	return copied.Interface().(Resource)
+}
+
+// keep devolve os elementos mantidos para o tipo, ou nil quando nada é removido.
+func (s *Subset) keep(resourceType string) map[string]bool {
+	var selected []string
+	switch {
+	case len(s.Elements) > 0:
+		selected = s.Elements
+	case s.Summary == SummaryTrue:
+		selected = summaryElements[resourceType]
+	case s.Summary == SummaryText:
+		selected = []string{"text"}
+	case s.Summary == SummaryData:
+		keep := elementNames(resourceTypes[resourceType])
+		delete(keep, "text")
+		return keep
+	default:
+		return nil
+	}
+
+	keep := map[string]bool{"resourceType": true, "id": true, "meta": true}
+	for _, element := range mandatoryElements[resourceType] {
+		keep[element] = true
+	}
+	for _, element := range selected {
+		keep[element] = true
+	}
+	return keep
+}
+
+func elementNames(resourceType reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	if resourceType == nil {
+		return names
+	}
+	for i := 0; i < resourceType.NumField(); i++ {
+		if name := jsonName(resourceType.Field(i)); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
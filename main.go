@@ -2,11 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
 
 	_ "fhir-api/docs"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatalf("Error running command: %v", err)
+		}
+		return
+	}
+
 	app := RunApp()
 	if err := app.Run(); err != nil {
 		log.Fatalf("Error running application: %v", err)
@@ -1,46 +1,78 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
+	"fhir-api/fhir"
+	"fhir-api/models"
+	"fhir-api/services"
+	"fhir-api/utils"
+
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 )
 
-func AuthMiddleware(jwtSecret, clientCode string) gin.HandlerFunc {
+// AuthMiddleware aceita só tokens aprovados pelo TokenVerifier e deixa o
+// principal disponível para os handlers (utils.CurrentPrincipal), para o log de
+// requisições e para os serviços (services.PrincipalFromContext).
+func AuthMiddleware(verifier *services.TokenVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+			utils.AbortWithError(c, models.NewAppError("UNAUTHORIZED", "Authorization header is required", http.StatusUnauthorized))
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Bearer token not found"})
+			utils.AbortWithError(c, models.NewAppError("UNAUTHORIZED", "Bearer token not found", http.StatusUnauthorized))
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(jwtSecret), nil
-		})
-
+		principal, err := verifier.Verify(tokenString)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			utils.AbortWithError(c, err)
+			return
+		}
+
+		utils.SetPrincipal(c, principal)
+		c.Request = c.Request.WithContext(services.WithPrincipal(c.Request.Context(), principal))
+		c.Next()
+	}
+}
+
+// RequireScope exige que os scopes do principal, guardado por AuthMiddleware,
+// concedam a interação sobre o tipo de recurso da rota. Rotas de sistema, como o
+// POST de Bundles, passam direto: cada entrada é autorizada pelo serviço.
+func RequireScope(resourceType, interaction string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if resourceType == "" {
+			c.Next()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if code, ok := claims["client_code"].(string); ok && code == clientCode {
-				c.Next()
-				return
-			}
+		if !services.ScopesFromContext(c.Request.Context()).Allows(resourceType, interaction) {
+			required := services.RequiredScope(resourceType, interaction)
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required))
+			utils.AbortWithError(c, models.NewAppError("FORBIDDEN", fmt.Sprintf("insufficient scope: %s on %s requires %s", interaction, resourceType, required), http.StatusForbidden))
+			return
 		}
+		c.Next()
+	}
+}
 
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+// AuthSecurity descreve, para o CapabilityStatement, a autenticação exigida por AuthMiddleware.
+func AuthSecurity() *fhir.CapabilitySecurity {
+	return &fhir.CapabilitySecurity{
+		Cors: false,
+		Service: []fhir.CodeableConcept{{
+			Coding: []fhir.Coding{{
+				System: "http://terminology.hl7.org/CodeSystem/restful-security-service",
+				Code:   "SMART-on-FHIR",
+			}},
+			Text: "SMART Backend Services",
+		}},
+		Description: "Token JWT (RS256 ou ES256, chaves em /.well-known/jwks.json) emitido em POST /auth/token pelo grant client_credentials do OAuth2 (client_secret ou private_key_jwt), enviado no header Authorization: Bearer <token>. Cada interação exige um scope SMART v2 (system/Patient.r, user/*.cruds...); a configuração está em /.well-known/smart-configuration.",
 	}
 }
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"fhir-api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorHandler renderiza como OperationOutcome o último erro registrado pelos
+// handlers via ctx.Error, usando o StatusCode/Code decidido pelo serviço.
+// Erros que não são *models.AppError resultam em 500.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		status, outcome := utils.OperationOutcomeFromError(c.Errors.Last().Err)
+		c.JSON(status, outcome)
+	}
+}
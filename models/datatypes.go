@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Identifier guarda identificadores de negócio além do fhirId do Hapi.
+type Identifier struct {
+	System string `bson:"system,omitempty" json:"system,omitempty"`
+	Value  string `bson:"value" json:"value"`
+}
+
+type Coding struct {
+	System  string `bson:"system,omitempty" json:"system,omitempty"`
+	Code    string `bson:"code,omitempty" json:"code,omitempty"`
+	Display string `bson:"display,omitempty" json:"display,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `bson:"system,omitempty" json:"system,omitempty"`
+	Value  string `bson:"value" json:"value"`
+	Use    string `bson:"use,omitempty" json:"use,omitempty"`
+}
+
+type Reference struct {
+	Reference string `bson:"reference,omitempty" json:"reference,omitempty"`
+	Display   string `bson:"display,omitempty" json:"display,omitempty"`
+}
+
+// DateRange é um período opcional nas duas pontas, usado em qualificações.
+type DateRange struct {
+	Start time.Time `bson:"start,omitempty" json:"start,omitempty"`
+	End   time.Time `bson:"end,omitempty" json:"end,omitempty"`
+}
+
+// ResourceMeta guarda a versão corrente do documento. Documentos anteriores ao
+// versionamento não têm versionId e são tratados como versão 1.
+type ResourceMeta struct {
+	VersionID   int64     `bson:"versionId,omitempty" json:"versionId,omitempty"`
+	LastUpdated time.Time `bson:"lastUpdated,omitempty" json:"lastUpdated,omitempty"`
+}
+
+// Version devolve a versão corrente, considerando 1 para documentos sem versionId.
+func (m ResourceMeta) Version() int64 {
+	if m.VersionID == 0 {
+		return 1
+	}
+	return m.VersionID
+}
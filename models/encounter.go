@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Period struct {
 	Start time.Time `bson:"start" json:"start"`
@@ -8,25 +12,25 @@ type Period struct {
 }
 
 type Encounter struct {
-	FhirId         string `bson:"fhirId" json:"fhirId"`
-	FullUrl        string `bson:"fullUrl" json:"fullUrl"`
-	Status         string `bson:"status" json:"status"`
-	Class          string `bson:"class" json:"class"`
-	Period         Period `bson:"period" json:"period"`
-	PractitionerID string `bson:"practitionerId,omitempty" json:"practitionerId,omitempty"`
-	PatientID      string `bson:"patientId,omitempty" json:"patientId,omitempty"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Meta           ResourceMeta       `bson:",inline" json:"meta"`
+	FhirId         string             `bson:"fhirId" json:"fhirId"`
+	Identifiers    []Identifier       `bson:"identifiers,omitempty" json:"identifiers,omitempty"`
+	FullUrl        string             `bson:"fullUrl" json:"fullUrl"`
+	Status         string             `bson:"status" json:"status"`
+	StatusHistory  []StatusHistory    `bson:"statusHistory,omitempty" json:"statusHistory,omitempty"`
+	Class          Coding             `bson:"class" json:"class"`
+	Period         Period             `bson:"period" json:"period"`
+	PractitionerID primitive.ObjectID `bson:"practitionerId,omitempty" json:"practitionerId,omitempty"`
+	PatientID      primitive.ObjectID `bson:"patientId,omitempty" json:"patientId,omitempty"`
 }
 
-type EncounterUpdate struct {
-	Status string `json:"status" binding:"required"`
+// StatusHistory registra um status anterior do encounter e o período em que vigorou.
+type StatusHistory struct {
+	Status string    `bson:"status" json:"status"`
+	Period DateRange `bson:"period" json:"period"`
 }
 
-type EncounterResponse struct {
-	FhirId         *string `json:"fhirId,omitempty"`
-	FullURL        *string `json:"fullUrl,omitempty"`
-	Status         *string `json:"status,omitempty"`
-	Class          *string `json:"class,omitempty"`
-	Period         *Period `json:"period,omitempty"`
-	PractitionerID *string `json:"practitionerId,omitempty"`
-	PatientID      *string `json:"patientId,omitempty"`
+type EncounterUpdate struct {
+	Status string `json:"status" binding:"required"`
 }
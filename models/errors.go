@@ -10,12 +10,27 @@ type AppError struct {
 	Code       string `json:"code"`    // Machine-readable error code
 	Message    string `json:"message"` // Human-readable message
 	StatusCode int    `json:"-"`       // HTTP status code
+	Err        error  `json:"-"`       // Underlying cause, if any
 }
 
 func (e *AppError) Error() string {
 	return fmt.Sprintf("%s: %s (status: %d)", e.Code, e.Message, e.StatusCode)
 }
 
+// Unwrap exposes the underlying cause to errors.Is and errors.As.
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns a copy of the error that keeps err as its cause, so callers
+// further up (such as the MongoDB driver's transaction retry, which looks for
+// error labels) can still inspect it.
+func (e *AppError) Wrap(err error) *AppError {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
 func NewAppError(code, message string, statusCode int) *AppError {
 	return &AppError{
 		Code:       code,
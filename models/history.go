@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResourceVersion é uma versão de um recurso gravada na coleção history a cada
+// escrita. Document guarda o documento da coleção de origem naquela versão e
+// fica vazio nas remoções.
+type ResourceVersion struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ResourceType string             `bson:"resourceType" json:"resourceType"`
+	ResourceID   primitive.ObjectID `bson:"resourceId" json:"resourceId"`
+	VersionID    int64              `bson:"versionId" json:"versionId"`
+	LastUpdated  time.Time          `bson:"lastUpdated" json:"lastUpdated"`
+	Method       string             `bson:"method" json:"method"`
+	Document     bson.Raw           `bson:"document,omitempty" json:"-"`
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Client é um cliente OAuth2 registrado na coleção clients. SecretHash guarda o
+// bcrypt do client_secret e PublicKey, em PEM, habilita a autenticação por
+// private_key_jwt. Scopes limita o que o cliente pode pedir em /auth/token.
+type Client struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ClientID   string             `bson:"clientId" json:"clientId"`
+	Name       string             `bson:"name,omitempty" json:"name,omitempty"`
+	SecretHash string             `bson:"secretHash,omitempty" json:"-"`
+	PublicKey  string             `bson:"publicKey,omitempty" json:"publicKey,omitempty"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	Active     bool               `bson:"active" json:"active"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// UsedAssertion registra o jti de um client_assertion já aceito, para recusar
+// reenvios. O documento some da coleção client_assertions (índice TTL) quando a
+// assertion expira.
+type UsedAssertion struct {
+	ClientID  string    `bson:"clientId" json:"clientId"`
+	JTI       string    `bson:"jti" json:"jti"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    time.Time `bson:"usedAt" json:"usedAt"`
+}
+
+// TokenResponse é a resposta de sucesso do endpoint de token (RFC 6749, seção 5.1).
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// SmartConfiguration é o documento de descoberta SMART servido em
+// /.well-known/smart-configuration.
+type SmartConfiguration struct {
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	TokenEndpointAuthMethods          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValues []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	Capabilities                      []string `json:"capabilities"`
+}
+
+// JSONWebKey é a parte pública de uma chave de assinatura de tokens (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet é o documento servido em /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// OAuthError é um erro do endpoint de token, renderizado no formato da RFC 6749
+// (seção 5.2) em vez de OperationOutcome.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	StatusCode  int    `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func NewOAuthError(code, description string, statusCode int) *OAuthError {
+	return &OAuthError{
+		Code:        code,
+		Description: description,
+		StatusCode:  statusCode,
+	}
+}
+
+// ErrInvalidClient é devolvido para qualquer falha de autenticação do cliente,
+// sem distinguir cliente inexistente de credencial errada.
+var ErrInvalidClient = NewOAuthError("invalid_client", "client authentication failed", http.StatusUnauthorized)
+
+// RevokedToken registra o jti de um token revogado antes de expirar. O
+// documento some da coleção revoked_tokens (índice TTL) em ExpiresAt, quando o
+// token deixaria de ser aceito mesmo com a tolerância de relógio.
+type RevokedToken struct {
+	JTI       string    `bson:"jti" json:"jti"`
+	ClientID  string    `bson:"clientId" json:"clientId"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt time.Time `bson:"revokedAt" json:"revokedAt"`
+}
+
+// Introspection é a resposta de /auth/introspect (RFC 7662). Tokens inválidos,
+// expirados ou revogados respondem apenas active=false.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	JTI       string `json:"jti,omitempty"`
+}
+
+// Principal é o cliente autenticado pelo access token da requisição, montado
+// pelo TokenVerifier a partir das claims já validadas. Scope mantém o formato
+// do token, separado por espaços.
+type Principal struct {
+	ClientID  string
+	Subject   string
+	Scope     string
+	TokenID   string
+	Issuer    string
+	Audience  string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+}
@@ -1,17 +1,14 @@
 package models
 
-type Patient struct {
-	FhirId     string `bson:"fhirId" json:"fhirId"`
-	GivenName  string `bson:"givenName" json:"givenName"`
-	FamilyName string `bson:"familyName" json:"familyName"`
-	BirthDate  string `bson:"birthDate" json:"birthDate"`
-	Gender     string `bson:"gender" json:"gender"`
-}
+import "go.mongodb.org/mongo-driver/bson/primitive"
 
-type PatientResponse struct {
-	FhirId     *string `json:"fhirId,omitempty"`
-	GivenName  *string `json:"givenName,omitempty"`
-	FamilyName *string `json:"familyName,omitempty"`
-	BirthDate  *string `json:"birthDate,omitempty"`
-	Gender     *string `json:"gender,omitempty"`
+type Patient struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Meta        ResourceMeta       `bson:",inline" json:"meta"`
+	FhirId      string             `bson:"fhirId" json:"fhirId"`
+	Identifiers []Identifier       `bson:"identifiers,omitempty" json:"identifiers,omitempty"`
+	GivenName   string             `bson:"givenName" json:"givenName"`
+	FamilyName  string             `bson:"familyName" json:"familyName"`
+	BirthDate   string             `bson:"birthDate" json:"birthDate"`
+	Gender      string             `bson:"gender" json:"gender"`
 }
@@ -1,13 +1,25 @@
 package models
 
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
 type Practitioner struct {
-	FhirId     string `bson:"fhirId" json:"fhirId"`
-	GivenName  string `bson:"givenName" json:"givenName"`
-	FamilyName string `bson:"familyName" json:"familyName"`
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Meta           ResourceMeta       `bson:",inline" json:"meta"`
+	FhirId         string             `bson:"fhirId" json:"fhirId"`
+	Identifiers    []Identifier       `bson:"identifiers,omitempty" json:"identifiers,omitempty"`
+	GivenName      string             `bson:"givenName" json:"givenName"`
+	FamilyName     string             `bson:"familyName" json:"familyName"`
+	Telecom        []ContactPoint     `bson:"telecom,omitempty" json:"telecom,omitempty"`
+	Gender         string             `bson:"gender,omitempty" json:"gender,omitempty"`
+	BirthDate      string             `bson:"birthDate,omitempty" json:"birthDate,omitempty"`
+	Qualifications []Qualification    `bson:"qualifications,omitempty" json:"qualifications,omitempty"`
 }
 
-type PractitionerRespose struct {
-	FhirId     *string `json:"fhirId,omitempty"`
-	GivenName  *string `json:"givenName,omitempty"`
-	FamilyName *string `json:"familyName,omitempty"`
+// Qualification é uma certificação ou registro profissional (ex.: CRM, COREN).
+type Qualification struct {
+	Identifiers []Identifier `bson:"identifiers,omitempty" json:"identifiers,omitempty"`
+	Code        []Coding     `bson:"code,omitempty" json:"code,omitempty"`
+	CodeText    string       `bson:"codeText,omitempty" json:"codeText,omitempty"`
+	Period      DateRange    `bson:"period,omitempty" json:"period,omitempty"`
+	Issuer      *Reference   `bson:"issuer,omitempty" json:"issuer,omitempty"`
 }
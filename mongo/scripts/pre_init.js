@@ -1,42 +1,17 @@
 db = db.getSiblingDB('fhir_hca');
-db.createCollection("encounters", {
-  validator: {
-    $jsonSchema: {
-      bsonType: "object",
-      required: ["fhirId", "fullUrl", "status", "class", "period"],
-      properties: {
-        fhirId: { bsonType: "string", description: "Hapi Api FhirID" },
-        fullUrl: { bsonType: "string", description: "FullUrl of the resource at Api Hapi" },
-        status: {
-          enum: ["planned", "in-progress", "on-hold", "discharged", "completed", "finished", "cancelled", "discontinued", "entered-in-error", "unknown"],
-          description: "Encounter Status"
-        },
-        class: {
-          enum: ["IMP", "AMB", "OBSENC", "EMER", "VR", "HH"],
-          description: "Encounter Class"
-        },
-        period: {
-          bsonType: "object",
-          required: ["start"],
-          properties: {
-            start: { bsonType: "date" },
-            end: { bsonType: ["date", "null"] }
-          }
-        },
-        practitionerId: {bsonType: "objectId", description: "Internal Reference to Practitioner Resource"},
-        patientId: {bsonType: "objectId", description: "Internal Reference to Patient Resource"}
-      }
-    }
-  },
-  validationLevel: "strict", 
-  validationAction: "error"  
-});
-
-
+// O validator $jsonSchema de encounters é aplicado pela API na inicialização
+// (EncounterService.EnsureSchema), a partir dos mesmos status e classes que ela valida.
+db.createCollection('encounters');
 db.createCollection('patients');
 db.createCollection('practitioners');
+db.createCollection('clients');
+db.createCollection('revoked_tokens');
+db.createCollection('client_assertions');
 
 db = db.getSiblingDB('fhir_hcb');
 db.createCollection('encounters');
 db.createCollection('patients');
 db.createCollection('practitioners');
+db.createCollection('clients');
+db.createCollection('revoked_tokens');
+db.createCollection('client_assertions');
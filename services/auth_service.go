@@ -1,49 +1,400 @@
 package services
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
 	"time"
 
+	"fhir-api/models"
+
 	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// JWTBearerAssertion é o client_assertion_type do private_key_jwt (RFC 7523).
+const JWTBearerAssertion = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// TokenSettings configura os tokens emitidos e aceitos: iss e aud esperados,
+// validade e a tolerância de relógio aplicada a exp, nbf e iat. TokenURL é a URL
+// pública de /auth/token; o aud de um private_key_jwt precisa ser ela ou Issuer,
+// nunca algo derivado dos headers da requisição.
+type TokenSettings struct {
+	Issuer    string
+	Audience  string
+	TokenURL  string
+	Lifetime  time.Duration
+	ClockSkew time.Duration
+}
+
+// AuthService emite os tokens que o TokenVerifier aceita: iss, aud, client_code
+// e validade vêm da configuração do próprio verifier.
 type AuthService struct {
-	secretKey  string
-	clientCode string
-	expiresIn  time.Duration
+	keys        *KeyStore
+	verifier    *TokenVerifier
+	clients     *ClientService
+	revocations *RevocationList
+	logger      *logrus.Logger
 }
 
-func NewAuthService(secretKey, clientCode string, expiresIn time.Duration) *AuthService {
+func NewAuthService(keys *KeyStore, verifier *TokenVerifier, clients *ClientService, revocations *RevocationList, logger *logrus.Logger) *AuthService {
 	return &AuthService{
-		secretKey:  secretKey,
-		clientCode: clientCode,
-		expiresIn:  expiresIn,
+		keys:        keys,
+		verifier:    verifier,
+		clients:     clients,
+		revocations: revocations,
+		logger:      logger,
 	}
 }
 
-func (s *AuthService) GenerateToken() (string, error) {
+// AssertionSigningAlgs são os algoritmos aceitos em client_assertion: RS256
+// para chaves RSA e ES256 para chaves EC P-256, como nas chaves de tokens.
+var AssertionSigningAlgs = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+
+// maxAssertionLifetime limita a validade de um client_assertion: exp não pode
+// estar mais longe que isso de iat nem do instante em que a assertion é usada.
+const maxAssertionLifetime = 5 * time.Minute
+
+// ClientCredentials é como o cliente se autentica em /auth/token, /auth/revoke
+// e /auth/introspect: ClientSecret (no corpo ou via Basic) ou ClientAssertion.
+type ClientCredentials struct {
+	ClientID            string
+	ClientSecret        string
+	ClientAssertionType string
+	ClientAssertion     string
+}
+
+// TokenRequest reúne os parâmetros do POST /auth/token.
+type TokenRequest struct {
+	ClientCredentials
+	GrantType string
+	Scope     string
+}
+
+// IssueToken atende o grant client_credentials: autentica o cliente no registro,
+// restringe os scopes pedidos aos que ele tem e emite o access token.
+func (s *AuthService) IssueToken(ctx context.Context, req TokenRequest) (*models.TokenResponse, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "IssueToken",
+		"client_id": req.ClientID,
+	}
+
+	switch req.GrantType {
+	case "client_credentials":
+	case "":
+		return nil, models.NewOAuthError("invalid_request", "grant_type is required", http.StatusBadRequest)
+	default:
+		return nil, models.NewOAuthError("unsupported_grant_type", "only client_credentials is supported", http.StatusBadRequest)
+	}
+
+	client, err := s.authenticate(ctx, req.ClientCredentials)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("autenticação do client falhou")
+		return nil, err
+	}
+	logFields["client_id"] = client.ClientID
+
+	scope, err := grantScopes(client.Scopes, req.Scope)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("scope não permitido para o client")
+		return nil, err
+	}
+
+	jti, err := newTokenID()
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao gerar jti")
+		return nil, models.NewAppError("INTERNAL_ERROR", "failed to generate token", http.StatusInternalServerError)
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
-		"client_code": s.clientCode,
-		"exp":         time.Now().Add(s.expiresIn).Unix(),
+		"iss":         s.verifier.settings.Issuer,
+		"aud":         s.verifier.settings.Audience,
+		"sub":         client.ClientID,
+		"client_id":   client.ClientID,
+		"client_code": s.verifier.clientCode,
+		"scope":       scope,
+		"jti":         jti,
+		"iat":         now.Unix(),
+		"nbf":         now.Unix(),
+		"exp":         now.Add(s.verifier.settings.Lifetime).Unix(),
+	}
+	token, err := s.keys.Sign(claims)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao assinar token")
+		return nil, models.NewAppError("INTERNAL_ERROR", "failed to generate token", http.StatusInternalServerError)
+	}
+
+	logFields["scope"] = scope
+	logFields["jti"] = jti
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("token emitido com sucesso")
+
+	return &models.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.verifier.settings.Lifetime / time.Second),
+		Scope:       scope,
+	}, nil
+}
+
+// Revoke atende /auth/revoke (RFC 7009). Tokens inválidos, expirados ou de
+// outro cliente são ignorados: a resposta é a mesma para não revelar nada
+// sobre eles.
+func (s *AuthService) Revoke(ctx context.Context, creds ClientCredentials, tokenString, hint string) error {
+	logFields := logrus.Fields{
+		"operation": "Revoke",
+		"client_id": creds.ClientID,
+	}
+
+	if tokenString == "" {
+		return models.NewOAuthError("invalid_request", "token is required", http.StatusBadRequest)
+	}
+	if hint != "" && hint != "access_token" {
+		return models.NewOAuthError("unsupported_token_type", "only access tokens can be revoked", http.StatusBadRequest)
+	}
+
+	client, err := s.authenticate(ctx, creds)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("autenticação do client falhou")
+		return err
+	}
+
+	principal, err := s.verifier.Verify(tokenString)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Info("revogação de token inválido ignorada")
+		return nil
+	}
+	if principal.Subject != client.ClientID {
+		s.logger.WithFields(logFields).Warn("revogação de token de outro client ignorada")
+		return nil
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.secretKey))
+	return s.revocations.Revoke(ctx, principal.TokenID, client.ClientID, s.verifier.acceptedUntil(principal))
 }
 
-func (s *AuthService) ValidateToken(tokenString string) (bool, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.secretKey), nil
-	})
+// Introspect atende /auth/introspect (RFC 7662) para clientes autenticados.
+func (s *AuthService) Introspect(ctx context.Context, creds ClientCredentials, tokenString string) (*models.Introspection, error) {
+	if tokenString == "" {
+		return nil, models.NewOAuthError("invalid_request", "token is required", http.StatusBadRequest)
+	}
+	if _, err := s.authenticate(ctx, creds); err != nil {
+		s.logger.WithField("client_id", creds.ClientID).WithError(err).Warn("autenticação do client falhou")
+		return nil, err
+	}
+
+	principal, err := s.verifier.Verify(tokenString)
+	if err != nil {
+		return &models.Introspection{Active: false}, nil
+	}
+
+	return &models.Introspection{
+		Active:    true,
+		TokenType: "Bearer",
+		Scope:     principal.Scope,
+		ClientID:  principal.ClientID,
+		Sub:       principal.Subject,
+		Aud:       principal.Audience,
+		Iss:       principal.Issuer,
+		JTI:       principal.TokenID,
+		Exp:       unixTime(principal.ExpiresAt),
+		Iat:       unixTime(principal.IssuedAt),
+		Nbf:       unixTime(principal.NotBefore),
+	}, nil
+}
+
+// unixTime converte um instante do principal para o formato das claims; claims
+// ausentes ficam zeradas e são omitidas na resposta.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// authenticate identifica o cliente pelo método de autenticação usado.
+func (s *AuthService) authenticate(ctx context.Context, creds ClientCredentials) (*models.Client, error) {
+	switch {
+	case creds.ClientAssertion != "" && creds.ClientSecret != "":
+		return nil, models.NewOAuthError("invalid_request", "only one client authentication method may be used", http.StatusBadRequest)
+	case creds.ClientAssertion != "":
+		return s.authenticateAssertion(ctx, creds)
+	default:
+		return s.authenticateSecret(ctx, creds)
+	}
+}
+
+// authenticateSecret confere o client_secret contra o bcrypt do registro.
+func (s *AuthService) authenticateSecret(ctx context.Context, creds ClientCredentials) (*models.Client, error) {
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return nil, models.ErrInvalidClient
+	}
+
+	client, err := s.clients.FindClient(ctx, creds.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || client.SecretHash == "" {
+		return nil, models.ErrInvalidClient
+	}
+	if bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(creds.ClientSecret)) != nil {
+		return nil, models.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// authenticateAssertion autentica por private_key_jwt: a assertion é verificada
+// por verifyAssertion e seu jti é registrado, para que não seja aceita de novo.
+func (s *AuthService) authenticateAssertion(ctx context.Context, creds ClientCredentials) (*models.Client, error) {
+	if creds.ClientAssertionType != JWTBearerAssertion {
+		return nil, models.NewOAuthError("invalid_request", "client_assertion_type must be "+JWTBearerAssertion, http.StatusBadRequest)
+	}
+
+	find := func(clientID string) (*models.Client, error) {
+		return s.clients.FindClient(ctx, clientID)
+	}
+	assertion, err := verifyAssertion(creds.ClientAssertion, creds.ClientID, find, s.verifier.settings, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	fresh, err := s.clients.UseAssertion(ctx, assertion.client.ClientID, assertion.jti, assertion.expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		s.logger.WithFields(logrus.Fields{"client_id": assertion.client.ClientID, "jti": assertion.jti}).Warn("client_assertion reutilizado")
+		return nil, models.ErrInvalidClient
+	}
+	return assertion.client, nil
+}
+
+// clientAssertion é um private_key_jwt verificado, com o jti a registrar até
+// expiresAt.
+type clientAssertion struct {
+	client    *models.Client
+	jti       string
+	expiresAt time.Time
+}
 
+// verifyAssertion confere o private_key_jwt sem registrar o jti: assinatura com
+// a chave pública do cliente devolvido por find, iss e sub iguais ao client_id,
+// aud igual ao TokenURL ou ao Issuer configurados, exp no máximo
+// maxAssertionLifetime à frente (e de iat) e jti presente.
+func verifyAssertion(raw, clientID string, find func(string) (*models.Client, error), settings TokenSettings, now time.Time) (*clientAssertion, error) {
+	var client *models.Client
+	var lookupErr error
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		sub, _ := token.Claims.(jwt.MapClaims)["sub"].(string)
+		if sub == "" || (clientID != "" && sub != clientID) {
+			return nil, errors.New("sub does not identify the client")
+		}
+
+		client, lookupErr = find(sub)
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		if client == nil || client.PublicKey == "" {
+			return nil, errors.New("client has no registered public key")
+		}
+
+		key, err := parsePublicKey(client.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != assertionAlg(key) {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key, nil
+	})
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
 	if err != nil {
-		return false, err
+		return nil, models.ErrInvalidClient
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if iss, _ := claims["iss"].(string); iss != client.ClientID {
+		return nil, models.ErrInvalidClient
+	}
+	if !claims.VerifyAudience(settings.Issuer, true) && (settings.TokenURL == "" || !claims.VerifyAudience(settings.TokenURL, true)) {
+		return nil, models.ErrInvalidClient
+	}
+
+	exp, iat := numericClaim(claims, "exp"), numericClaim(claims, "iat")
+	switch {
+	case exp == 0 || exp < now.Add(-settings.ClockSkew).Unix():
+		return nil, models.ErrInvalidClient
+	case exp > now.Add(maxAssertionLifetime+settings.ClockSkew).Unix():
+		return nil, models.ErrInvalidClient
+	case iat != 0 && exp-iat > int64(maxAssertionLifetime/time.Second):
+		return nil, models.ErrInvalidClient
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, models.ErrInvalidClient
 	}
+	return &clientAssertion{client: client, jti: jti, expiresAt: time.Unix(exp, 0).Add(settings.ClockSkew)}, nil
+}
+
+// assertionAlg é o único alg aceito para assertions assinadas com a chave.
+func assertionAlg(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PublicKey:
+		return jwt.SigningMethodES256.Alg()
+	}
+	return ""
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if code, ok := claims["client_code"].(string); ok && code == s.clientCode {
-			return true, nil
+func numericClaim(claims jwt.MapClaims, name string) int64 {
+	value, _ := claims[name].(float64)
+	return int64(value)
+}
+
+// grantScopes devolve os scopes concedidos, separados por espaço. Sem scope no
+// pedido o cliente recebe todos os que tem registrados; um scope pedido pode ser
+// mais restrito que o registrado (system/Patient.r dentro de system/*.cruds).
+func grantScopes(allowed []string, requested string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), nil
+	}
+
+	permitted := ParseScopes(strings.Join(allowed, " "))
+	var granted []string
+	for _, item := range strings.Fields(requested) {
+		scope, err := ParseScope(item)
+		if err != nil {
+			return "", models.NewOAuthError("invalid_scope", err.Error(), http.StatusBadRequest)
+		}
+		if !permitted.Covers(scope) {
+			return "", models.NewOAuthError("invalid_scope", "scope not allowed for this client: "+item, http.StatusBadRequest)
 		}
+		granted = append(granted, item)
 	}
+	return strings.Join(granted, " "), nil
+}
 
-	return false, nil
+// JWKS devolve as chaves públicas com que os tokens emitidos podem ser verificados.
+func (s *AuthService) JWKS() models.JSONWebKeySet {
+	return s.keys.JWKS()
 }
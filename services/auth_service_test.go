@@ -0,0 +1,224 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"fhir-api/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const testTokenURL = "https://fhir.example.org/api/v1/auth/token"
+
+var testSettings = TokenSettings{
+	Issuer:    "fhir-api-hca",
+	Audience:  "fhir-api-hca",
+	TokenURL:  testTokenURL,
+	Lifetime:  time.Hour,
+	ClockSkew: time.Minute,
+}
+
+// publicKeyPEM devolve a chave pública no formato gravado em Client.PublicKey.
+func publicKeyPEM(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func assertionClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": "backend",
+		"sub": "backend",
+		"aud": testTokenURL,
+		"jti": "assertion-1",
+		"iat": now.Unix(),
+		"exp": now.Add(2 * time.Minute).Unix(),
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	now := time.Now()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &models.Client{ClientID: "backend", PublicKey: publicKeyPEM(t, &private.PublicKey), Active: true}
+	find := func(clientID string) (*models.Client, error) {
+		if clientID == client.ClientID {
+			return client, nil
+		}
+		return nil, nil
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	with := func(change func(jwt.MapClaims)) string {
+		claims := assertionClaims(now)
+		change(claims)
+		return sign(jwt.SigningMethodRS256, private, claims)
+	}
+
+	tests := []struct {
+		name      string
+		assertion string
+		clientID  string
+		settings  TokenSettings
+		valid     bool
+	}{
+		{name: "aud is the configured token URL", assertion: with(func(jwt.MapClaims) {}), valid: true},
+		{name: "aud is the issuer", assertion: with(func(c jwt.MapClaims) { c["aud"] = testSettings.Issuer }), valid: true},
+		{name: "client_id matches sub", assertion: with(func(jwt.MapClaims) {}), clientID: "backend", valid: true},
+		{name: "aud from a spoofed Host header", assertion: with(func(c jwt.MapClaims) { c["aud"] = "https://attacker.example/api/v1/auth/token" })},
+		{name: "token URL not configured", assertion: with(func(jwt.MapClaims) {}), settings: TokenSettings{Issuer: testSettings.Issuer, ClockSkew: time.Minute}},
+		{name: "missing jti", assertion: with(func(c jwt.MapClaims) { delete(c, "jti") })},
+		{name: "missing exp", assertion: with(func(c jwt.MapClaims) { delete(c, "exp") })},
+		{name: "expired", assertion: with(func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * time.Minute).Unix() })},
+		{name: "exp too far ahead", assertion: with(func(c jwt.MapClaims) { c["exp"] = now.Add(time.Hour).Unix() })},
+		{name: "exp - iat over the maximum", assertion: with(func(c jwt.MapClaims) {
+			c["iat"] = now.Add(-10 * time.Minute).Unix()
+			c["exp"] = now.Add(time.Minute).Unix()
+		})},
+		{name: "iss is not the client", assertion: with(func(c jwt.MapClaims) { c["iss"] = "other" })},
+		{name: "client_id does not match sub", assertion: with(func(jwt.MapClaims) {}), clientID: "other"},
+		{name: "unknown client", assertion: with(func(c jwt.MapClaims) { c["sub"], c["iss"] = "other", "other" })},
+		{name: "HMAC signed with the public key", assertion: sign(jwt.SigningMethodHS256, []byte(client.PublicKey), assertionClaims(now))},
+		{name: "not a JWT", assertion: "not-a-jwt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := tt.settings
+			if settings.Issuer == "" {
+				settings = testSettings
+			}
+
+			assertion, err := verifyAssertion(tt.assertion, tt.clientID, find, settings, now)
+			if !tt.valid {
+				if !errors.Is(err, models.ErrInvalidClient) {
+					t.Fatalf("err = %v, want invalid_client", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if assertion.client != client || assertion.jti != "assertion-1" {
+				t.Errorf("assertion = %+v, want client backend with jti assertion-1", assertion)
+			}
+			if want := time.Unix(now.Add(2*time.Minute).Unix(), 0).Add(settings.ClockSkew); !assertion.expiresAt.Equal(want) {
+				t.Errorf("expiresAt = %v, want %v", assertion.expiresAt, want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionPropagatesLookupErrors(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, assertionClaims(time.Now())).SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbErr := models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError)
+	find := func(string) (*models.Client, error) { return nil, dbErr }
+	if _, err := verifyAssertion(signed, "", find, testSettings, time.Now()); err != dbErr {
+		t.Errorf("err = %v, want the lookup error", err)
+	}
+}
+
+// TestVerifyAssertionAlgorithms garante que cada alg anunciado na
+// smart-configuration é aceito e que os demais são recusados.
+func TestVerifyAssertionAlgorithms(t *testing.T) {
+	now := time.Now()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := map[string]struct {
+		method  jwt.SigningMethod
+		private interface{}
+		public  interface{}
+	}{
+		"RS256": {jwt.SigningMethodRS256, rsaKey, &rsaKey.PublicKey},
+		"ES256": {jwt.SigningMethodES256, ecKey, &ecKey.PublicKey},
+		"RS384": {jwt.SigningMethodRS384, rsaKey, &rsaKey.PublicKey},
+		"RS512": {jwt.SigningMethodRS512, rsaKey, &rsaKey.PublicKey},
+		"PS256": {jwt.SigningMethodPS256, rsaKey, &rsaKey.PublicKey},
+	}
+
+	accepted := map[string]bool{}
+	for _, alg := range AssertionSigningAlgs {
+		accepted[alg] = true
+		if _, ok := signers[alg]; !ok {
+			t.Fatalf("advertised alg %s has no test signer", alg)
+		}
+	}
+
+	for alg, signer := range signers {
+		t.Run(alg, func(t *testing.T) {
+			client := &models.Client{ClientID: "backend", PublicKey: publicKeyPEM(t, signer.public), Active: true}
+			find := func(string) (*models.Client, error) { return client, nil }
+			signed, err := jwt.NewWithClaims(signer.method, assertionClaims(now)).SignedString(signer.private)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = verifyAssertion(signed, "", find, testSettings, now)
+			if accepted[alg] && err != nil {
+				t.Errorf("advertised alg rejected: %v", err)
+			}
+			if !accepted[alg] && !errors.Is(err, models.ErrInvalidClient) {
+				t.Errorf("err = %v, want invalid_client for an alg that is not advertised", err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	weakRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parsePublicKey(publicKeyPEM(t, &p256.PublicKey)); err != nil {
+		t.Errorf("P-256 key rejected: %v", err)
+	}
+	for name, key := range map[string]interface{}{"RSA 1024": &weakRSA.PublicKey, "EC P-384": &p384.PublicKey} {
+		if _, err := parsePublicKey(publicKeyPEM(t, key)); err == nil {
+			t.Errorf("%s key accepted, but it cannot sign with RS256 or ES256", name)
+		}
+	}
+	if _, err := parsePublicKey("not a key"); err == nil {
+		t.Error("invalid PEM accepted")
+	}
+}
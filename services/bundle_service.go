@@ -0,0 +1,509 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	BundleTypeBatch       = "batch"
+	BundleTypeTransaction = "transaction"
+)
+
+// illegalOperation é o código devolvido pelo MongoDB ao iniciar uma transação
+// num servidor standalone.
+const illegalOperation = 20
+
+// bundleHandler adapta um serviço de recurso às interações permitidas numa
+// entrada de Bundle.
+type bundleHandler struct {
+	create func(ctx context.Context, id primitive.ObjectID, data []byte) (fhir.Resource, error)
+	update func(ctx context.Context, id string, data []byte, expected int64) (fhir.Resource, error)
+	patch  func(ctx context.Context, id string, patch Patch, expected int64) (fhir.Resource, error)
+	delete func(ctx context.Context, id string, expected int64) error
+	read   func(ctx context.Context, id string) (fhir.Resource, error)
+	search func(ctx context.Context, query url.Values) (*SearchResult, error)
+}
+
+// EntryResult é o resultado de uma entrada de batch ou transaction. Resource é o
+// recurso gravado ou lido e Search o resultado de um GET de busca. Err só é
+// preenchido em batch, já que em transaction qualquer falha desfaz o Bundle.
+type EntryResult struct {
+	Status   int
+	Resource fhir.Resource
+	Search   *SearchResult
+	Err      error
+}
+
+// BundleResult reúne os resultados na ordem das entradas recebidas.
+type BundleResult struct {
+	Type    string
+	Entries []EntryResult
+}
+
+// bundleEntry é uma entrada já interpretada: tipo, id e query da request.url e
+// o recurso em JSON, com as referências urn:uuid resolvidas.
+type bundleEntry struct {
+	index        int
+	method       string
+	resourceType string
+	id           string
+	query        url.Values
+	expected     int64
+	assigned     primitive.ObjectID
+	resource     []byte
+	handler      bundleHandler
+}
+
+type BundleService struct {
+	client   *mongo.Client
+	logger   *logrus.Logger
+	handlers map[string]bundleHandler
+}
+
+func NewBundleService(db *mongo.Database, logger *logrus.Logger, patients *PatientService, practitioners *PractitionerService, encounters *EncounterService) *BundleService {
+	return &BundleService{
+		client: db.Client(),
+		logger: logger,
+		handlers: map[string]bundleHandler{
+			"Patient": {
+				create: func(ctx context.Context, id primitive.ObjectID, data []byte) (fhir.Resource, error) {
+					var resource fhir.Patient
+					if err := decodeEntryResource(data, &resource); err != nil {
+						return nil, err
+					}
+					return asResource(patients.createPatient(ctx, id, &resource))
+				},
+				update: func(ctx context.Context, id string, data []byte, expected int64) (fhir.Resource, error) {
+					var resource fhir.Patient
+					if err := decodeEntryResource(data, &resource); err != nil {
+						return nil, err
+					}
+					return asResource(patients.UpdatePatient(ctx, id, &resource, expected))
+				},
+				patch: func(ctx context.Context, id string, patch Patch, expected int64) (fhir.Resource, error) {
+					return asResource(patients.PatchPatient(ctx, id, patch, expected))
+				},
+				delete: patients.DeletePatient,
+				read: func(ctx context.Context, id string) (fhir.Resource, error) {
+					return asResource(patients.GetPatient(ctx, id))
+				},
+				search: patients.SearchPatients,
+			},
+			"Practitioner": {
+				create: func(ctx context.Context, id primitive.ObjectID, data []byte) (fhir.Resource, error) {
+					var resource fhir.Practitioner
+					if err := decodeEntryResource(data, &resource); err != nil {
+						return nil, err
+					}
+					return asResource(practitioners.createPractitioner(ctx, id, &resource))
+				},
+				update: func(ctx context.Context, id string, data []byte, expected int64) (fhir.Resource, error) {
+					var resource fhir.Practitioner
+					if err := decodeEntryResource(data, &resource); err != nil {
+						return nil, err
+					}
+					return asResource(practitioners.UpdatePractitioner(ctx, id, &resource, expected))
+				},
+				patch: func(ctx context.Context, id string, patch Patch, expected int64) (fhir.Resource, error) {
+					return asResource(practitioners.PatchPractitioner(ctx, id, patch, expected))
+				},
+				delete: practitioners.DeletePractitioner,
+				read: func(ctx context.Context, id string) (fhir.Resource, error) {
+					return asResource(practitioners.GetPractitioner(ctx, id))
+				},
+				search: practitioners.SearchPractitioners,
+			},
+			"Encounter": {
+				create: func(ctx context.Context, id primitive.ObjectID, data []byte) (fhir.Resource, error) {
+					var resource fhir.Encounter
+					if err := decodeEntryResource(data, &resource); err != nil {
+						return nil, err
+					}
+					return asResource(encounters.createEncounter(ctx, id, &resource))
+				},
+				update: func(ctx context.Context, id string, data []byte, expected int64) (fhir.Resource, error) {
+					var resource fhir.Encounter
+					if err := decodeEntryResource(data, &resource); err != nil {
+						return nil, err
+					}
+					return asResource(encounters.UpdateEncounter(ctx, id, &resource, expected))
+				},
+				patch: func(ctx context.Context, id string, patch Patch, expected int64) (fhir.Resource, error) {
+					return asResource(encounters.PatchEncounter(ctx, id, patch, expected))
+				},
+				read: func(ctx context.Context, id string) (fhir.Resource, error) {
+					return asResource(encounters.GetEncounter(ctx, id))
+				},
+				search: encounters.SearchEncounters,
+			},
+		},
+	}
+}
+
+// asResource evita que um ponteiro nil tipado vire um fhir.Resource não nil.
+func asResource[T fhir.Resource](resource T, err error) (fhir.Resource, error) {
+	if err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+func decodeEntryResource(data []byte, resource fhir.Resource) error {
+	if len(data) == 0 {
+		return models.NewAppError("INVALID_INPUT", "entrada sem resource", http.StatusBadRequest)
+	}
+	if err := json.Unmarshal(data, resource); err != nil {
+		return models.NewAppError("INVALID_INPUT", "resource inválido: "+err.Error(), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// ProcessBundle executa um Bundle batch ou transaction. Em batch cada entrada é
+// independente e falhas viram respostas de erro da própria entrada. Em
+// transaction as entradas rodam numa única transação do MongoDB, na ordem
+// DELETE, POST, PUT/PATCH e GET, e qualquer falha desfaz todas e é devolvida
+// como erro.
+func (s *BundleService) ProcessBundle(ctx context.Context, bundle *fhir.Bundle) (*BundleResult, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":  "ProcessBundle",
+		"bundleType": bundle.Type,
+		"entries":    len(bundle.Entry),
+	}
+
+	if bundle.ResourceType != "Bundle" {
+		return nil, models.NewAppError("INVALID_INPUT", "resourceType deve ser Bundle", http.StatusBadRequest)
+	}
+	if bundle.Type != BundleTypeBatch && bundle.Type != BundleTypeTransaction {
+		return nil, models.NewAppError("INVALID_INPUT", "Bundle.type deve ser batch ou transaction", http.StatusBadRequest)
+	}
+
+	result := &BundleResult{Type: bundle.Type, Entries: make([]EntryResult, len(bundle.Entry))}
+	entries := make([]*bundleEntry, len(bundle.Entry))
+	for i, raw := range bundle.Entry {
+		entry, err := s.parseEntry(i, raw)
+		if err == nil {
+			err = entryError(entry, authorize(ctx, entry.resourceType, entry.interaction()))
+		}
+		if err != nil {
+			if bundle.Type == BundleTypeTransaction {
+				s.logger.WithFields(logFields).WithError(err).Warn("entrada de transaction inválida")
+				return nil, err
+			}
+			result.Entries[i] = EntryResult{Err: err}
+			continue
+		}
+		entries[i] = entry
+	}
+
+	if bundle.Type == BundleTypeBatch {
+		for i, entry := range entries {
+			if entry != nil {
+				result.Entries[i] = s.executeEntry(ctx, entry)
+			}
+		}
+
+		logFields["duration"] = time.Since(startTime).String()
+		s.logger.WithFields(logFields).Info("batch processado com sucesso")
+		return result, nil
+	}
+
+	if err := resolveReferences(bundle, entries); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("referências da transaction inválidas")
+		return nil, err
+	}
+
+	order := make([]*bundleEntry, len(entries))
+	copy(order, entries)
+	sort.SliceStable(order, func(a, b int) bool {
+		return transactionOrder[order[a].method] < transactionOrder[order[b].method]
+	})
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao iniciar sessão no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	defer session.EndSession(ctx)
+
+	// O callback devolve o erro da entrada sem reembrulhá-lo: o driver procura nele
+	// o rótulo TransientTransactionError para repetir a transação. A entrada que
+	// falhou só é anexada à mensagem depois.
+	var failed *bundleEntry
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		failed = nil
+		for _, entry := range order {
+			entryResult := s.executeEntry(sessionCtx, entry)
+			if entryResult.Err != nil {
+				failed = entry
+				return nil, entryResult.Err
+			}
+			result.Entries[entry.index] = entryResult
+		}
+		return nil, nil
+	})
+	if err != nil {
+		err = transactionError(err)
+		if failed != nil {
+			err = entryError(failed, err)
+		}
+		s.logger.WithFields(logFields).WithError(err).Warn("transaction desfeita")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("transaction processada com sucesso")
+	return result, nil
+}
+
+// transactionOrder é a ordem de processamento das entradas de uma transaction
+// definida pela especificação.
+var transactionOrder = map[string]int{
+	http.MethodDelete: 0,
+	http.MethodPost:   1,
+	http.MethodPut:    2,
+	http.MethodPatch:  2,
+	http.MethodGet:    3,
+}
+
+// parseEntry interpreta request.method e request.url de uma entrada. Só são
+// aceitas URLs relativas: Tipo, Tipo?query e Tipo/id.
+func (s *BundleService) parseEntry(index int, raw fhir.BundleEntry) (*bundleEntry, error) {
+	if raw.Request == nil || raw.Request.Method == "" || raw.Request.URL == "" {
+		return nil, entryAppError(index, "INVALID_INPUT", "request.method e request.url são obrigatórios", http.StatusBadRequest)
+	}
+
+	entry := &bundleEntry{index: index, method: strings.ToUpper(raw.Request.Method)}
+	if _, ok := transactionOrder[entry.method]; !ok {
+		return nil, entryAppError(index, "INVALID_INPUT", "request.method não suportado: "+raw.Request.Method, http.StatusBadRequest)
+	}
+
+	target, err := url.Parse(raw.Request.URL)
+	if err != nil || target.IsAbs() {
+		return nil, entryAppError(index, "INVALID_INPUT", "request.url deve ser relativa: "+raw.Request.URL, http.StatusBadRequest)
+	}
+	segments := strings.Split(strings.Trim(target.Path, "/"), "/")
+	entry.resourceType = segments[0]
+	entry.query = target.Query()
+
+	handler, ok := s.handlers[entry.resourceType]
+	if !ok {
+		return nil, entryAppError(index, "NOT_FOUND", "tipo de recurso não suportado: "+entry.resourceType, http.StatusNotFound)
+	}
+	entry.handler = handler
+
+	switch {
+	case len(segments) == 1 && (entry.method == http.MethodPost || entry.method == http.MethodGet):
+	case len(segments) == 2 && entry.method != http.MethodPost:
+		entry.id = segments[1]
+	default:
+		return nil, entryAppError(index, "INVALID_INPUT", fmt.Sprintf("%s %s não é uma interação suportada", entry.method, raw.Request.URL), http.StatusBadRequest)
+	}
+	if entry.method == http.MethodDelete && handler.delete == nil {
+		return nil, entryAppError(index, "NOT_SUPPORTED", entry.resourceType+" não aceita delete", http.StatusMethodNotAllowed)
+	}
+
+	if raw.Request.IfMatch != "" {
+		entry.expected, err = strconv.ParseInt(fhir.ETagVersion(raw.Request.IfMatch), 10, 64)
+		if err != nil || entry.expected < 1 {
+			return nil, entryAppError(index, "INVALID_INPUT", "request.ifMatch inválido: "+raw.Request.IfMatch, http.StatusBadRequest)
+		}
+	}
+
+	if raw.Resource != nil {
+		if entry.resource, err = json.Marshal(raw.Resource); err != nil {
+			return nil, entryAppError(index, "INVALID_INPUT", "resource inválido", http.StatusBadRequest)
+		}
+	}
+	if entry.method == http.MethodPost {
+		entry.assigned = primitive.NewObjectID()
+	}
+	return entry, nil
+}
+
+// interaction é a interação FHIR da entrada, usada para conferir os scopes do
+// token contra cada entrada do Bundle.
+func (e *bundleEntry) interaction() string {
+	switch e.method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+	if e.id != "" {
+		return "read"
+	}
+	return "search-type"
+}
+
+// resolveReferences troca as referências a fullUrl urn:uuid de entradas POST
+// pelo Tipo/id reservado para elas, em todos os recursos da transaction.
+func resolveReferences(bundle *fhir.Bundle, entries []*bundleEntry) error {
+	references := map[string]string{}
+	for i, raw := range bundle.Entry {
+		if !strings.HasPrefix(raw.FullURL, "urn:uuid:") {
+			continue
+		}
+		if _, exists := references[raw.FullURL]; exists {
+			return entryAppError(i, "INVALID_INPUT", "fullUrl repetido: "+raw.FullURL, http.StatusBadRequest)
+		}
+		if entries[i].method == http.MethodPost {
+			references[raw.FullURL] = entries[i].resourceType + "/" + entries[i].assigned.Hex()
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if len(entry.resource) == 0 {
+			continue
+		}
+		var document interface{}
+		if err := json.Unmarshal(entry.resource, &document); err != nil {
+			return entryAppError(entry.index, "INVALID_INPUT", "resource inválido", http.StatusBadRequest)
+		}
+		if !replaceReferences(document, references) {
+			continue
+		}
+		resolved, err := json.Marshal(document)
+		if err != nil {
+			return entryAppError(entry.index, "INVALID_INPUT", "resource inválido", http.StatusBadRequest)
+		}
+		entry.resource = resolved
+	}
+	return nil
+}
+
+// replaceReferences percorre o JSON trocando Reference.reference; devolve se
+// alguma referência foi alterada.
+func replaceReferences(node interface{}, references map[string]string) bool {
+	changed := false
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if reference, ok := child.(string); ok && key == "reference" {
+				if resolved, ok := references[reference]; ok {
+					value[key] = resolved
+					changed = true
+				}
+				continue
+			}
+			changed = replaceReferences(child, references) || changed
+		}
+	case []interface{}:
+		for _, child := range value {
+			changed = replaceReferences(child, references) || changed
+		}
+	}
+	return changed
+}
+
+func (s *BundleService) executeEntry(ctx context.Context, entry *bundleEntry) EntryResult {
+	var (
+		resource fhir.Resource
+		search   *SearchResult
+		err      error
+		status   = http.StatusOK
+	)
+
+	switch entry.method {
+	case http.MethodPost:
+		resource, err = entry.handler.create(ctx, entry.assigned, entry.resource)
+		status = http.StatusCreated
+	case http.MethodPut:
+		resource, err = entry.handler.update(ctx, entry.id, entry.resource, entry.expected)
+	case http.MethodPatch:
+		var patch Patch
+		if patch, err = entryPatch(entry.resource); err == nil {
+			resource, err = entry.handler.patch(ctx, entry.id, patch, entry.expected)
+		}
+	case http.MethodDelete:
+		err = entry.handler.delete(ctx, entry.id, entry.expected)
+		status = http.StatusNoContent
+	case http.MethodGet:
+		if entry.id != "" {
+			resource, err = entry.handler.read(ctx, entry.id)
+		} else {
+			search, err = entry.handler.search(ctx, entry.query)
+		}
+	}
+
+	if err != nil {
+		return EntryResult{Err: err}
+	}
+	return EntryResult{Status: status, Resource: resource, Search: search}
+}
+
+// entryPatch extrai o patch de uma entrada PATCH: um Parameters (FHIRPath
+// Patch) ou um Binary com o JSON Patch em data.
+func entryPatch(data []byte) (Patch, error) {
+	var binary fhir.Binary
+	if err := json.Unmarshal(data, &binary); err != nil || len(data) == 0 {
+		return Patch{}, models.NewAppError("INVALID_INPUT", "entrada PATCH exige um Parameters ou Binary", http.StatusBadRequest)
+	}
+
+	switch binary.ResourceType {
+	case "Parameters":
+		return Patch{ContentType: FHIRJSONMediaType, Body: data}, nil
+	case "Binary":
+		return Patch{ContentType: binary.ContentType, Body: binary.Data}, nil
+	default:
+		return Patch{}, models.NewAppError("INVALID_INPUT", "entrada PATCH exige um Parameters ou Binary", http.StatusBadRequest)
+	}
+}
+
+// entryError identifica a entrada que desfez a transaction, preservando o
+// código e o status do erro original.
+func entryError(entry *bundleEntry, err error) error {
+	var appErr *models.AppError
+	if !errors.As(err, &appErr) {
+		return err
+	}
+	return entryAppError(entry.index, appErr.Code, appErr.Message, appErr.StatusCode).Wrap(appErr.Err)
+}
+
+func entryAppError(index int, code, message string, status int) *models.AppError {
+	return models.NewAppError(code, fmt.Sprintf("entry[%d]: %s", index, message), status)
+}
+
+// transactionError mantém os erros das entradas e traduz as falhas da própria
+// transação, como a ausência de replica set. Um conflito de escrita que persistiu
+// após as novas tentativas do driver vira 409.
+func transactionError(err error) error {
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && labeled.HasErrorLabel("TransientTransactionError") {
+		return models.NewAppError("CONFLICT", "a transação conflitou com outra escrita, tente novamente", http.StatusConflict).Wrap(err)
+	}
+
+	var appErr *models.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(illegalOperation) {
+		return models.NewAppError("NOT_SUPPORTED", "transações exigem MongoDB em replica set", http.StatusNotImplemented)
+	}
+	return models.NewAppError("DATABASE_ERROR", "erro ao executar a transação", http.StatusInternalServerError).Wrap(err)
+}
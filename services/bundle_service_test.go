@@ -0,0 +1,51 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTransactionErrorsKeepDriverLabels(t *testing.T) {
+	writeConflict := mongo.CommandError{Code: 112, Name: "WriteConflict", Labels: []string{"TransientTransactionError"}}
+	dbErr := models.NewAppError("DATABASE_ERROR", "erro ao gravar no banco de dados", http.StatusInternalServerError).Wrap(writeConflict)
+
+	var labeled mongo.LabeledError
+	if !errors.As(dbErr, &labeled) || !labeled.HasErrorLabel("TransientTransactionError") {
+		t.Fatalf("wrapped service error lost the driver label: %v", dbErr)
+	}
+
+	err := entryError(&bundleEntry{index: 2}, transactionError(dbErr))
+	var appErr *models.AppError
+	if !errors.As(err, &appErr) || appErr.Code != "CONFLICT" || appErr.StatusCode != http.StatusConflict {
+		t.Fatalf("err = %v, want CONFLICT 409", err)
+	}
+	if appErr.Message != "entry[2]: a transação conflitou com outra escrita, tente novamente" {
+		t.Errorf("message = %q, want the entry index prefix", appErr.Message)
+	}
+	if !errors.As(err, &labeled) || !labeled.HasErrorLabel("TransientTransactionError") {
+		t.Errorf("entryError dropped the driver label: %v", err)
+	}
+}
+
+func TestTransactionErrorKeepsEntryErrors(t *testing.T) {
+	notFound := models.NewAppError("NOT_FOUND", "patient não encontrado", http.StatusNotFound)
+	if err := transactionError(notFound); err != notFound {
+		t.Errorf("transactionError(%v) = %v, want the entry error unchanged", notFound, err)
+	}
+
+	err := transactionError(mongo.CommandError{Code: 20, Name: "IllegalOperation"})
+	var appErr *models.AppError
+	if !errors.As(err, &appErr) || appErr.Code != "NOT_SUPPORTED" {
+		t.Errorf("err = %v, want NOT_SUPPORTED without a replica set", err)
+	}
+
+	err = transactionError(mongo.CommandError{Code: 8000, Name: "AtlasError"})
+	if !errors.As(err, &appErr) || appErr.Code != "DATABASE_ERROR" || appErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("err = %v, want DATABASE_ERROR 500", err)
+	}
+}
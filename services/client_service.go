@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"fhir-api/models"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	clientsCollection    = "clients"
+	assertionsCollection = "client_assertions"
+)
+
+// ClientService mantém o registro de clientes OAuth2 autorizados a pedir tokens.
+type ClientService struct {
+	db     *mongo.Database
+	logger *logrus.Logger
+}
+
+func NewClientService(db *mongo.Database, logger *logrus.Logger) *ClientService {
+	return &ClientService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// EnsureIndexes garante que clientId é único no registro e que cada jti de
+// client_assertion só é aceito uma vez, até a assertion expirar (índice TTL).
+func (s *ClientService) EnsureIndexes(ctx context.Context) error {
+	name, err := s.db.Collection(clientsCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "clientId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("falha ao criar índices de clients")
+		return err
+	}
+
+	names, err := s.db.Collection(assertionsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "clientId", Value: 1}, {Key: "jti", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("falha ao criar índices de client_assertions")
+		return err
+	}
+
+	s.logger.WithField("indexes", append([]string{name}, names...)).Info("índices de clients verificados")
+	return nil
+}
+
+// UseAssertion registra o jti de um client_assertion até expiresAt. Devolve false
+// quando o mesmo cliente já usou esse jti, o que indica uma assertion reenviada.
+func (s *ClientService) UseAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) (bool, error) {
+	_, err := s.db.Collection(assertionsCollection).InsertOne(ctx, models.UsedAssertion{
+		ClientID:  clientID,
+		JTI:       jti,
+		ExpiresAt: expiresAt.UTC(),
+		UsedAt:    time.Now().UTC(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		s.logger.WithField("client_id", clientID).WithError(err).Error("falha ao registrar client_assertion no MongoDB")
+		return false, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return true, nil
+}
+
+// FindClient devolve o cliente ativo com o client_id informado, ou nil quando
+// não há nenhum.
+func (s *ClientService) FindClient(ctx context.Context, clientID string) (*models.Client, error) {
+	var client models.Client
+	err := s.db.Collection(clientsCollection).FindOne(ctx, bson.M{"clientId": clientID, "active": true}).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		s.logger.WithField("client_id", clientID).WithError(err).Error("falha ao buscar client no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return &client, nil
+}
+
+// RegisterClient grava um novo cliente e devolve o client_secret gerado, que só
+// é conhecido neste momento. Com publicKey (PEM RSA ou EC) o cliente também
+// pode se autenticar por private_key_jwt.
+func (s *ClientService) RegisterClient(ctx context.Context, clientID, name string, scopes []string, publicKey string) (string, error) {
+	logFields := logrus.Fields{
+		"operation": "RegisterClient",
+		"client_id": clientID,
+	}
+
+	if clientID == "" || len(scopes) == 0 {
+		return "", models.NewAppError("INVALID_INPUT", "client_id e ao menos um scope são obrigatórios", http.StatusBadRequest)
+	}
+	for _, scope := range scopes {
+		if _, err := ParseScope(scope); err != nil {
+			return "", models.NewAppError("INVALID_INPUT", err.Error(), http.StatusBadRequest)
+		}
+	}
+	if publicKey != "" {
+		if _, err := parsePublicKey(publicKey); err != nil {
+			return "", models.NewAppError("INVALID_INPUT", "chave pública inválida: "+err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	client := models.Client{
+		ClientID:   clientID,
+		Name:       name,
+		SecretHash: string(hash),
+		PublicKey:  publicKey,
+		Scopes:     scopes,
+		Active:     true,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if _, err := s.db.Collection(clientsCollection).InsertOne(ctx, client); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", models.NewAppError("CONFLICT", "client_id já registrado: "+clientID, http.StatusConflict)
+		}
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao registrar client no MongoDB")
+		return "", models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	s.logger.WithFields(logFields).Info("client registrado com sucesso")
+	return secret, nil
+}
+
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// parsePublicKey aceita chaves públicas RSA (2048 bits ou mais) ou EC P-256 em
+// PEM, as que podem assinar com os algoritmos de AssertionSigningAlgs.
+func parsePublicKey(pem string) (interface{}, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem)); err == nil {
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("chaves RSA precisam de ao menos 2048 bits")
+		}
+		return key, nil
+	}
+	key, err := jwt.ParseECPublicKeyFromPEM([]byte(pem))
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("chaves EC precisam usar a curva P-256")
+	}
+	return key, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+)
+
+// searchFunc é a busca de um tipo de recurso, reutilizada para resolver os
+// critérios das operações condicionais.
+type searchFunc func(ctx context.Context, query url.Values) (*SearchResult, error)
+
+// ParseCriteria lê os critérios de If-None-Exist, aceitos como "a=b",
+// "?a=b" ou "Tipo?a=b".
+func ParseCriteria(value string) (url.Values, error) {
+	if i := strings.Index(value, "?"); i >= 0 {
+		value = value[i+1:]
+	}
+	criteria, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, models.NewAppError("INVALID_PARAM", "critérios condicionais inválidos: "+value, http.StatusBadRequest)
+	}
+	return criteria, nil
+}
+
+// matchCriteria resolve os critérios com a busca do tipo. Devolve nil quando
+// nenhum recurso corresponde e 412 quando mais de um corresponde. Parâmetros de
+// resultado (_count, _sort...) são descartados; ao menos um parâmetro de busca
+// é exigido, já que critérios vazios corresponderiam a todos os recursos. Como
+// o resultado revela se há recursos que correspondem, os scopes precisam
+// permitir a busca no tipo.
+func matchCriteria(ctx context.Context, resourceType string, criteria url.Values, search searchFunc) (fhir.Resource, error) {
+	if err := authorize(ctx, resourceType, "search-type"); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	for key, values := range criteria {
+		if !strings.HasPrefix(key, "_") {
+			query[key] = values
+		}
+	}
+	if len(query) == 0 {
+		return nil, models.NewAppError("INVALID_PARAM", "operação condicional exige ao menos um parâmetro de busca", http.StatusBadRequest)
+	}
+	query.Set("_count", "2")
+
+	result, err := search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case result.Total == 0:
+		return nil, nil
+	case result.Total > 1 || len(result.Resources) != 1:
+		message := fmt.Sprintf("os critérios correspondem a %d recursos %s", result.Total, resourceType)
+		return nil, models.NewAppError("PRECONDITION_FAILED", message, http.StatusPreconditionFailed)
+	default:
+		return result.Resources[0], nil
+	}
+}
+
+// conditionalCreate cria o recurso apenas se nenhum corresponder aos critérios;
+// se um corresponder, ele é devolvido com created falso. A verificação e a
+// inserção não são atômicas: envios simultâneos ainda podem duplicar.
+func conditionalCreate[T fhir.Resource](ctx context.Context, resourceType string, criteria url.Values, search searchFunc, create func() (T, error)) (resource T, created bool, err error) {
+	existing, err := matchCriteria(ctx, resourceType, criteria, search)
+	if err != nil {
+		return resource, false, err
+	}
+	if existing != nil {
+		return existing.(T), false, nil
+	}
+
+	resource, err = create()
+	return resource, err == nil, err
+}
+
+// conditionalUpdate atualiza o recurso que corresponde aos critérios, ou cria
+// um novo quando nenhum corresponde, o que exige também o scope de criação. O
+// id do corpo, se informado, deve ser o do recurso encontrado.
+func conditionalUpdate[T fhir.Resource](ctx context.Context, resourceType string, criteria url.Values, bodyID string, search searchFunc, update func(id string) (T, error), create func() (T, error)) (resource T, created bool, err error) {
+	existing, err := matchCriteria(ctx, resourceType, criteria, search)
+	if err != nil {
+		return resource, false, err
+	}
+
+	if existing == nil {
+		if bodyID != "" {
+			return resource, false, models.NewAppError("INVALID_INPUT", "nenhum "+resourceType+" corresponde aos critérios e o servidor não aceita ids atribuídos pelo cliente", http.StatusBadRequest)
+		}
+		if err := authorize(ctx, resourceType, "create"); err != nil {
+			return resource, false, err
+		}
+		resource, err = create()
+		return resource, err == nil, err
+	}
+
+	if bodyID != "" && bodyID != existing.ResourceID() {
+		return resource, false, models.NewAppError("INVALID_INPUT", "id do recurso difere do recurso encontrado pelos critérios", http.StatusBadRequest)
+	}
+	resource, err = update(existing.ResourceID())
+	return resource, false, err
+}
+
+// conditionalDelete remove o recurso que corresponde aos critérios. Nenhuma
+// correspondência não é erro: o resultado já é o pedido.
+func conditionalDelete(ctx context.Context, resourceType string, criteria url.Values, search searchFunc, remove func(id string) error) error {
+	existing, err := matchCriteria(ctx, resourceType, criteria, search)
+	if err != nil || existing == nil {
+		return err
+	}
+	return remove(existing.ResourceID())
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+)
+
+// searchReturning simula a busca do tipo devolvendo os recursos informados.
+func searchReturning(resources ...fhir.Resource) searchFunc {
+	return func(context.Context, url.Values) (*SearchResult, error) {
+		return &SearchResult{Total: int64(len(resources)), Resources: resources}, nil
+	}
+}
+
+func scopedContext(scope string) context.Context {
+	return WithPrincipal(context.Background(), &models.Principal{ClientID: "backend", Scope: scope})
+}
+
+func TestConditionalUpdateRequiresCreateScopeToCreate(t *testing.T) {
+	criteria := url.Values{"identifier": {"urn:system|123"}}
+	existing := &fhir.Patient{ResourceType: "Patient", ID: "p1"}
+
+	tests := []struct {
+		name        string
+		scope       string
+		matches     []fhir.Resource
+		wantCreated bool
+		wantUpdated bool
+		wantCode    string
+		wantStatus  int
+	}{
+		{name: "update-only token updates the match", scope: "system/Patient.us", matches: []fhir.Resource{existing}, wantUpdated: true},
+		{name: "update-only token cannot create", scope: "system/Patient.us", wantCode: "FORBIDDEN", wantStatus: http.StatusForbidden},
+		{name: "token with create creates", scope: "system/Patient.cus", wantCreated: true},
+		{name: "token without search cannot resolve criteria", scope: "system/Patient.cu", matches: []fhir.Resource{existing}, wantCode: "FORBIDDEN", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created, updated bool
+			_, wasCreated, err := conditionalUpdate(scopedContext(tt.scope), "Patient", criteria, "", searchReturning(tt.matches...),
+				func(id string) (*fhir.Patient, error) {
+					updated = true
+					return &fhir.Patient{ResourceType: "Patient", ID: id}, nil
+				},
+				func() (*fhir.Patient, error) {
+					created = true
+					return &fhir.Patient{ResourceType: "Patient", ID: "new"}, nil
+				})
+
+			if tt.wantCode != "" {
+				assertAppError(t, err, tt.wantCode, tt.wantStatus)
+				if created || updated {
+					t.Errorf("created = %v, updated = %v, want neither", created, updated)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if created != tt.wantCreated || wasCreated != tt.wantCreated || updated != tt.wantUpdated {
+				t.Errorf("created = %v (reported %v), updated = %v, want created %v and updated %v", created, wasCreated, updated, tt.wantCreated, tt.wantUpdated)
+			}
+		})
+	}
+}
+
+// TestConditionalOperationsRequireSearchScope garante que os critérios só são
+// resolvidos quando os scopes permitem buscar no tipo, já que o resultado
+// revela a existência de recursos correspondentes.
+func TestConditionalOperationsRequireSearchScope(t *testing.T) {
+	criteria := url.Values{"identifier": {"urn:system|123"}}
+	existing := &fhir.Patient{ResourceType: "Patient", ID: "p1"}
+	create := func() (*fhir.Patient, error) { return &fhir.Patient{ResourceType: "Patient", ID: "new"}, nil }
+	remove := func(string) error { return nil }
+
+	operations := map[string]func(ctx context.Context, search searchFunc) error{
+		"create": func(ctx context.Context, search searchFunc) error {
+			_, _, err := conditionalCreate(ctx, "Patient", criteria, search, create)
+			return err
+		},
+		"update": func(ctx context.Context, search searchFunc) error {
+			_, _, err := conditionalUpdate(ctx, "Patient", criteria, "", search, func(id string) (*fhir.Patient, error) { return existing, nil }, create)
+			return err
+		},
+		"delete": func(ctx context.Context, search searchFunc) error {
+			return conditionalDelete(ctx, "Patient", criteria, search, remove)
+		},
+	}
+	for name, operation := range operations {
+		t.Run(name, func(t *testing.T) {
+			searched := false
+			search := func(ctx context.Context, query url.Values) (*SearchResult, error) {
+				searched = true
+				return searchReturning(existing)(ctx, query)
+			}
+
+			err := operation(scopedContext("system/Patient.cud"), search)
+			assertAppError(t, err, "FORBIDDEN", http.StatusForbidden)
+			if searched {
+				t.Error("criteria were resolved without the search scope")
+			}
+
+			if err := operation(scopedContext("system/Patient.cuds"), search); err != nil {
+				t.Errorf("unexpected error with the search scope: %v", err)
+			}
+		})
+	}
+}
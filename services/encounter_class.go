@@ -0,0 +1,92 @@
+package services
+
+import (
+	"strings"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// encounterClass é um código v3-ActCode aceito em Encounter.class. aliases são
+// os termos antigos gravados antes de class virar Coding.
+type encounterClass struct {
+	code    string
+	display string
+	aliases []string
+}
+
+// encounterClasses é a fonte única dos valores de class: validação da API,
+// display emitido, busca, migração e o validator $jsonSchema da coleção.
+var encounterClasses = []encounterClass{
+	{code: "IMP", display: "inpatient encounter", aliases: []string{"inpatient"}},
+	{code: "AMB", display: "ambulatory", aliases: []string{"ambulatory"}},
+	{code: "OBSENC", display: "observation encounter", aliases: []string{"observation"}},
+	{code: "EMER", display: "emergency", aliases: []string{"emergency"}},
+	{code: "VR", display: "virtual", aliases: []string{"virtual"}},
+	{code: "HH", display: "home health", aliases: []string{"home-health"}},
+}
+
+// lookupEncounterClass encontra a class pelo código, display ou alias, sem
+// distinção de maiúsculas.
+func lookupEncounterClass(value string) (encounterClass, bool) {
+	for _, class := range encounterClasses {
+		if strings.EqualFold(value, class.code) || strings.EqualFold(value, class.display) {
+			return class, true
+		}
+		for _, alias := range class.aliases {
+			if strings.EqualFold(value, alias) {
+				return class, true
+			}
+		}
+	}
+	return encounterClass{}, false
+}
+
+// resolveEncounterClass aceita o Coding recebido com o código ou apenas o display.
+func resolveEncounterClass(coding *fhir.Coding) (encounterClass, bool) {
+	if coding.Code != "" {
+		return lookupEncounterClass(coding.Code)
+	}
+	return lookupEncounterClass(coding.Display)
+}
+
+// coding devolve a forma canônica persistida em class.
+func (c encounterClass) coding() models.Coding {
+	return models.Coding{System: fhir.ActCodeSystem, Code: c.code, Display: c.display}
+}
+
+// terms lista todos os valores antigos que a migração converte para esta class.
+func (c encounterClass) terms() []string {
+	return append([]string{c.code, c.display}, c.aliases...)
+}
+
+func encounterClassCodes() []string {
+	codes := make([]string, 0, len(encounterClasses))
+	for _, class := range encounterClasses {
+		codes = append(codes, class.code)
+	}
+	return codes
+}
+
+// classFilter implementa o parâmetro class: aceita código ou display e, quando
+// informado, o sistema deve ser v3-ActCode.
+func classFilter(value, modifier string) (bson.M, error) {
+	if modifier != "" {
+		return nil, invalidModifier(modifier)
+	}
+
+	if system, code, ok := strings.Cut(value, "|"); ok {
+		if system != "" && system != fhir.ActCodeSystem {
+			return matchNothing("class.code"), nil
+		}
+		value = code
+	}
+
+	class, ok := lookupEncounterClass(value)
+	if !ok {
+		return matchNothing("class.code"), nil
+	}
+	return bson.M{"class.code": class.code}, nil
+}
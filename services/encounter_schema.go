@@ -0,0 +1,51 @@
+package services
+
+import (
+	"sort"
+
+	"fhir-api/fhir"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// encounterSchema gera o validator $jsonSchema da coleção encounters a partir
+// dos status e classes aceitos pelo serviço, para que banco e API não divirjam.
+func (s *EncounterService) encounterSchema() bson.M {
+	statuses := make([]string, 0, len(s.validStatus))
+	for status := range s.validStatus {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+
+	return bson.M{
+		"$jsonSchema": bson.M{
+			"bsonType": "object",
+			"required": bson.A{"fhirId", "fullUrl", "status", "class", "period"},
+			"properties": bson.M{
+				"fhirId":  bson.M{"bsonType": "string", "description": "Hapi Api FhirID"},
+				"fullUrl": bson.M{"bsonType": "string", "description": "FullUrl of the resource at Api Hapi"},
+				"status":  bson.M{"enum": statuses, "description": "Encounter Status"},
+				"class": bson.M{
+					"bsonType":    "object",
+					"required":    bson.A{"system", "code"},
+					"description": "Encounter Class (v3-ActCode Coding)",
+					"properties": bson.M{
+						"system":  bson.M{"enum": bson.A{fhir.ActCodeSystem}},
+						"code":    bson.M{"enum": encounterClassCodes()},
+						"display": bson.M{"bsonType": "string"},
+					},
+				},
+				"period": bson.M{
+					"bsonType": "object",
+					"required": bson.A{"start"},
+					"properties": bson.M{
+						"start": bson.M{"bsonType": "date"},
+						"end":   bson.M{"bsonType": bson.A{"date", "null"}},
+					},
+				},
+				"practitionerId": bson.M{"bsonType": "objectId", "description": "Internal Reference to Practitioner Resource"},
+				"patientId":      bson.M{"bsonType": "objectId", "description": "Internal Reference to Patient Resource"},
+			},
+		},
+	}
+}
@@ -3,9 +3,12 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
+	"fhir-api/fhir"
 	"fhir-api/models"
 
 	"github.com/sirupsen/logrus"
@@ -20,10 +23,14 @@ type EncounterService struct {
 	logger       *logrus.Logger
 	validFields  map[string]bool
 	validStatus  map[string]bool
-	validClasses map[string]bool
+	transitions  StatusTransitions
+	versions     *versionStore
+	searchParams searchParams
+	sortAliases  map[string]string
+	limits       SearchLimits
 }
 
-func NewEncounterService(db *mongo.Database, logger *logrus.Logger) *EncounterService {
+func NewEncounterService(db *mongo.Database, logger *logrus.Logger, limits SearchLimits, transitions StatusTransitions) *EncounterService {
 	validFields := map[string]bool{
 		"fhirId":         true,
 		"fullUrl":        true,
@@ -34,55 +41,235 @@ func NewEncounterService(db *mongo.Database, logger *logrus.Logger) *EncounterSe
 		"patientId":      true,
 	}
 
-	validStatus := map[string]bool{
-		"planned":          true,
-		"in-progress":      true,
-		"on-hold":          true,
-		"discharged":       true,
-		"completed":        true,
-		"finished":         true,
-		"cancelled":        true,
-		"discontinued":     true,
-		"entered-in-error": true,
-		"unknown":          true,
+	searchParams := searchParams{
+		"patient": {
+			paramType:     "reference",
+			documentation: "Paciente do encounter (Patient/id)",
+			filter:        referenceFilter("patientId", "Patient"),
+		},
+		"practitioner": {
+			paramType:     "reference",
+			documentation: "Profissional participante (Practitioner/id)",
+			filter:        referenceFilter("practitionerId", "Practitioner"),
+		},
+		"status": {
+			paramType:     "token",
+			documentation: "Status do encounter; vários valores separados por vírgula são combinados com OR",
+			filter:        tokenFilter("status", "http://hl7.org/fhir/encounter-status"),
+		},
+		"class": {
+			paramType:     "token",
+			documentation: "Classe do encounter (código v3-ActCode ou display)",
+			filter:        classFilter,
+		},
+		"date": {
+			paramType:     "date",
+			documentation: "Data dentro do período do encounter, aceita prefixos eq, ne, lt, gt, le, ge, sa, eb",
+			filter:        periodFilter("period.start", "period.end"),
+		},
 	}
 
-	validClasses := map[string]bool{
-		"inpatient":   true,
-		"observation": true,
-		"ambulatory":  true,
-		"emergency":   true,
-		"virtual":     true,
-		"home-health": true,
+	sortAliases := map[string]string{
+		"date":         "period.start",
+		"patient":      "patientId",
+		"practitioner": "practitionerId",
+		"class":        "class.code",
 	}
 
 	return &EncounterService{
 		db:           db,
 		logger:       logger,
 		validFields:  validFields,
-		validStatus:  validStatus,
-		validClasses: validClasses,
+		validStatus:  encounterStatuses,
+		transitions:  transitions,
+		versions:     newVersionStore(db, logger),
+		searchParams: searchParams,
+		sortAliases:  sortAliases,
+		limits:       limits,
 	}
 }
 
-func (s *EncounterService) GetEncounter(ctx context.Context, id string, fields []string) (*models.EncounterResponse, error) {
+// EnsureIndexes cria os índices usados pela busca de encounters.
+func (s *EncounterService) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "patientId", Value: 1}, {Key: "period.start", Value: -1}}},
+		{Keys: bson.D{{Key: "practitionerId", Value: 1}, {Key: "status", Value: 1}, {Key: "period.start", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "class.code", Value: 1}, {Key: "period.start", Value: -1}}},
+		{Keys: bson.D{{Key: "period.start", Value: -1}, {Key: "period.end", Value: -1}}},
+	}
+
+	names, err := s.db.Collection("encounters").Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		s.logger.WithError(err).Error("falha ao criar índices de encounters")
+		return err
+	}
+
+	s.logger.WithField("indexes", names).Info("índices de encounters verificados")
+	return nil
+}
+
+// EnsureSchema aplica à coleção o validator gerado por encounterSchema e migra
+// documentos com class no formato antigo (texto) para Coding. O validator entra
+// primeiro em modo moderate, que não valida atualizações de documentos já
+// inválidos, permitindo a migração, e ao final passa a strict.
+func (s *EncounterService) EnsureSchema(ctx context.Context) error {
+	if err := s.applyValidator(ctx, "moderate"); err != nil {
+		s.logger.WithError(err).Error("falha ao aplicar validator de encounters")
+		return err
+	}
+
+	migrated, err := s.migrateClass(ctx)
+	if err != nil {
+		s.logger.WithError(err).Error("falha ao migrar class de encounters")
+		return err
+	}
+
+	if err := s.applyValidator(ctx, "strict"); err != nil {
+		s.logger.WithError(err).Error("falha ao aplicar validator de encounters")
+		return err
+	}
+
+	s.logger.WithField("migrated", migrated).Info("schema de encounters verificado")
+	return nil
+}
+
+// namespaceNotFound é devolvido por collMod quando a coleção ainda não existe.
+const namespaceNotFound = 26
+
+func (s *EncounterService) applyValidator(ctx context.Context, level string) error {
+	err := s.db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: "encounters"},
+		{Key: "validator", Value: s.encounterSchema()},
+		{Key: "validationLevel", Value: level},
+		{Key: "validationAction", Value: "error"},
+	}).Err()
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(namespaceNotFound) {
+		return s.db.CreateCollection(ctx, "encounters", options.CreateCollection().
+			SetValidator(s.encounterSchema()).
+			SetValidationLevel(level).
+			SetValidationAction("error"))
+	}
+	return err
+}
+
+// migrateClass converte class gravado como texto (código, display ou termo
+// antigo) no Coding canônico. É idempotente: documentos já migrados não casam.
+func (s *EncounterService) migrateClass(ctx context.Context) (int64, error) {
+	collection := s.db.Collection("encounters")
+
+	var migrated int64
+	for _, class := range encounterClasses {
+		result, err := collection.UpdateMany(ctx,
+			bson.M{"class": bson.M{"$in": class.terms()}},
+			bson.M{"$set": bson.M{"class": class.coding()}},
+		)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += result.ModifiedCount
+	}
+
+	remaining, err := collection.CountDocuments(ctx, bson.M{"class": bson.M{"$type": "string"}})
+	if err != nil {
+		return migrated, err
+	}
+	if remaining > 0 {
+		s.logger.WithField("remaining", remaining).Warn("encounters com class desconhecido não foram migrados")
+	}
+
+	return migrated, nil
+}
+
+// SearchParams descreve os parâmetros de busca aceitos por SearchEncounters.
+func (s *EncounterService) SearchParams() []fhir.CapabilitySearchParam {
+	return s.searchParams.capability()
+}
+
+// SearchInclude lista os valores aceitos em _include por SearchEncounters.
+func (s *EncounterService) SearchInclude() []string {
+	return []string{encounterSubject.String(), encounterParticipant.String()}
+}
+
+func (s *EncounterService) SearchEncounters(ctx context.Context, query url.Values) (*SearchResult, error) {
 	startTime := time.Now()
 	logFields := logrus.Fields{
-		"operation":       "GetEncounter",
-		"encounterId":     id,
-		"requestedFields": fields,
+		"operation": "SearchEncounters",
+		"query":     query.Encode(),
+	}
+
+	filter, err := s.searchParams.filter(query)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de busca inválidos")
+		return nil, err
+	}
+
+	page, err := s.limits.page(query, s.sortAliases, s.validFields)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de paginação inválidos")
+		return nil, err
+	}
+
+	includes, err := parseIncludes(query, "_include", encounterSubject, encounterParticipant)
+	if err == nil {
+		_, err = parseIncludes(query, "_revinclude")
+	}
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de inclusão inválidos")
+		return nil, err
+	}
+	if err := authorizeIncludes(ctx, includes, false, encounterSubject, encounterParticipant); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("scope insuficiente para os recursos incluídos")
+		return nil, err
 	}
 
-	for _, field := range fields {
-		if !s.validFields[field] {
-			s.logger.WithFields(logFields).WithField("invalidField", field).Warn("campo inválido solicitado")
-			return nil, models.NewAppError("INVALID_FIELD", "campo inválido solicitado: "+field, http.StatusBadRequest)
+	var patientIDs, practitionerIDs []primitive.ObjectID
+	result, err := findPage(ctx, s.db.Collection("encounters"), filter, page, func(encounter models.Encounter) fhir.Resource {
+		patientIDs = append(patientIDs, encounter.PatientID)
+		practitionerIDs = append(practitionerIDs, encounter.PractitionerID)
+		return toFhirEncounter(encounter)
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar encounters no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	// Os referenciados são buscados com um $in por tipo para a página inteira.
+	if includes[encounterSubject.String()] {
+		included, err := findIncluded(ctx, s.db.Collection("patients"), "_id", patientIDs, 0, func(patient models.Patient) fhir.Resource {
+			return toFhirPatient(patient)
+		})
+		if err != nil {
+			s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar patients incluídos no MongoDB")
+			return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
 		}
+		result.Included = append(result.Included, included...)
 	}
+	if includes[encounterParticipant.String()] {
+		included, err := findIncluded(ctx, s.db.Collection("practitioners"), "_id", practitionerIDs, 0, func(practitioner models.Practitioner) fhir.Resource {
+			return toFhirPractitioner(practitioner)
+		})
+		if err != nil {
+			s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar practitioners incluídos no MongoDB")
+			return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+		}
+		result.Included = append(result.Included, included...)
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["total"] = result.Total
+	logFields["included"] = len(result.Included)
+	s.logger.WithFields(logFields).Info("busca de encounters realizada com sucesso")
 
-	projection := bson.M{}
-	for _, field := range fields {
-		projection[field] = 1
+	return result, nil
+}
+
+func (s *EncounterService) GetEncounter(ctx context.Context, id string) (*fhir.Encounter, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":   "GetEncounter",
+		"encounterId": id,
 	}
 
 	collection := s.db.Collection("encounters")
@@ -96,7 +283,6 @@ func (s *EncounterService) GetEncounter(ctx context.Context, id string, fields [
 	err := collection.FindOne(
 		ctx,
 		bson.M{"_id": objectID},
-		options.FindOne().SetProjection(projection),
 	).Decode(&encounter)
 
 	if err != nil {
@@ -106,10 +292,10 @@ func (s *EncounterService) GetEncounter(ctx context.Context, id string, fields [
 		}
 
 		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar encounter no MongoDB")
-		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError)
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
 	}
 
-	response := s.mapToResponse(encounter, fields)
+	response := toFhirEncounter(encounter)
 
 	logFields["duration"] = time.Since(startTime).String()
 	s.logger.WithFields(logFields).Info("consulta de encounter realizada com sucesso")
@@ -117,7 +303,258 @@ func (s *EncounterService) GetEncounter(ctx context.Context, id string, fields [
 	return response, nil
 }
 
-func (s *EncounterService) UpdateEncounterStatus(ctx context.Context, id, status string) error {
+func (s *EncounterService) CreateEncounter(ctx context.Context, resource *fhir.Encounter) (*fhir.Encounter, error) {
+	return s.createEncounter(ctx, primitive.NewObjectID(), resource)
+}
+
+// createEncounter grava o encounter com o id recebido. As referências já devem
+// estar resolvidas para ids reais.
+func (s *EncounterService) createEncounter(ctx context.Context, id primitive.ObjectID, resource *fhir.Encounter) (*fhir.Encounter, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "CreateEncounter",
+	}
+
+	encounter, err := s.fromResource(ctx, resource)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("encounter inválido")
+		return nil, err
+	}
+
+	encounter.ID = id
+	encounter.Meta = firstVersion()
+	logFields["encounterId"] = encounter.ID.Hex()
+
+	collection := s.db.Collection("encounters")
+	err = s.versions.write(ctx, "Encounter", encounter.ID, encounter.Meta, http.MethodPost, encounter, func() (bool, error) {
+		_, err := collection.InsertOne(ctx, encounter)
+		return true, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao inserir encounter")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("encounter criado com sucesso")
+
+	return toFhirEncounter(encounter), nil
+}
+
+// ConditionalCreateEncounter cria o encounter só se nenhum corresponder aos
+// critérios; um reenvio devolve o encounter já gravado.
+func (s *EncounterService) ConditionalCreateEncounter(ctx context.Context, resource *fhir.Encounter, criteria url.Values) (*fhir.Encounter, bool, error) {
+	encounter, created, err := conditionalCreate(ctx, "Encounter", criteria, s.SearchEncounters, func() (*fhir.Encounter, error) {
+		return s.CreateEncounter(ctx, resource)
+	})
+	if err == nil && !created {
+		s.logger.WithFields(logrus.Fields{
+			"operation":   "ConditionalCreateEncounter",
+			"encounterId": encounter.ID,
+			"criteria":    criteria.Encode(),
+		}).Info("encounter já existente para os critérios, nada criado")
+	}
+	return encounter, created, err
+}
+
+// ConditionalUpdateEncounter atualiza o encounter encontrado pelos critérios,
+// seguindo o grafo de status, ou cria um quando nenhum corresponde.
+func (s *EncounterService) ConditionalUpdateEncounter(ctx context.Context, criteria url.Values, resource *fhir.Encounter, expected int64) (*fhir.Encounter, bool, error) {
+	return conditionalUpdate(ctx, "Encounter", criteria, resource.ID, s.SearchEncounters,
+		func(id string) (*fhir.Encounter, error) { return s.UpdateEncounter(ctx, id, resource, expected) },
+		func() (*fhir.Encounter, error) { return s.CreateEncounter(ctx, resource) },
+	)
+}
+
+func (s *EncounterService) UpdateEncounter(ctx context.Context, id string, resource *fhir.Encounter, expected int64) (*fhir.Encounter, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":   "UpdateEncounter",
+		"encounterId": id,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	if resource.ID != "" && resource.ID != id {
+		s.logger.WithFields(logFields).WithField("bodyId", resource.ID).Warn("id do recurso difere da URL")
+		return nil, models.NewAppError("INVALID_INPUT", "id do recurso difere do id da URL", http.StatusBadRequest)
+	}
+
+	current, err := s.findEncounter(ctx, objectID, logFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("Encounter", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	return s.replaceEncounter(ctx, logFields, startTime, current, resource, http.MethodPut)
+}
+
+// PatchEncounter aplica um JSON Patch ou FHIRPath Patch ao encounter
+// armazenado e grava o resultado como uma nova versão. Mudanças de status
+// passam pelo grafo de transições, como em UpdateEncounter.
+func (s *EncounterService) PatchEncounter(ctx context.Context, id string, patch Patch, expected int64) (*fhir.Encounter, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":   "PatchEncounter",
+		"encounterId": id,
+		"contentType": patch.ContentType,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	engine, err := patch.parse()
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patch inválido")
+		return nil, err
+	}
+
+	current, err := s.findEncounter(ctx, objectID, logFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("Encounter", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	var resource fhir.Encounter
+	if err := applyPatch(engine, toFhirEncounter(*current), &resource); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patch não aplicável ao encounter")
+		return nil, err
+	}
+
+	return s.replaceEncounter(ctx, logFields, startTime, current, &resource, http.MethodPatch)
+}
+
+// replaceEncounter valida o recurso e o grava como a versão seguinte de
+// current, desde que o documento não tenha mudado desde a leitura.
+func (s *EncounterService) replaceEncounter(ctx context.Context, logFields logrus.Fields, startTime time.Time, current *models.Encounter, resource *fhir.Encounter, method string) (*fhir.Encounter, error) {
+	encounter, err := s.fromResource(ctx, resource)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("encounter inválido")
+		return nil, err
+	}
+
+	objectID := current.ID
+	if err := s.versions.archiveLegacy(ctx, "Encounter", objectID, current.Meta, current); err != nil {
+		return nil, err
+	}
+	encounter.ID = objectID
+	encounter.Meta = nextVersion(current.Meta)
+
+	// O status segue o grafo de transições a partir do valor persistido, e o
+	// histórico existente é preservado.
+	status := encounter.Status
+	encounter.Status = current.Status
+	encounter.StatusHistory = current.StatusHistory
+	if err := s.transitions.applyStatus(&encounter, status, time.Now().UTC()); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("transição de status inválida")
+		return nil, err
+	}
+
+	collection := s.db.Collection("encounters")
+	err = s.versions.write(ctx, "Encounter", objectID, encounter.Meta, method, encounter, func() (bool, error) {
+		result, err := collection.ReplaceOne(ctx, versionFilter(objectID, current.Meta), encounter)
+		return err == nil && result.MatchedCount > 0, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao atualizar encounter")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["versionId"] = encounter.Meta.VersionID
+	s.logger.WithFields(logFields).Info("encounter atualizado com sucesso")
+
+	return toFhirEncounter(encounter), nil
+}
+
+func (s *EncounterService) fromResource(ctx context.Context, resource *fhir.Encounter) (models.Encounter, error) {
+	if resource.ResourceType != "Encounter" {
+		return models.Encounter{}, models.NewAppError("INVALID_INPUT", "resourceType deve ser Encounter", http.StatusBadRequest)
+	}
+
+	if !s.validStatus[resource.Status] {
+		return models.Encounter{}, models.NewAppError("INVALID_STATUS", "status inválido: "+resource.Status, http.StatusBadRequest)
+	}
+
+	if resource.Class == nil || (resource.Class.System != "" && resource.Class.System != fhir.ActCodeSystem) {
+		return models.Encounter{}, models.NewAppError("INVALID_FIELD", "class deve ser um código "+fhir.ActCodeSystem, http.StatusBadRequest)
+	}
+	if _, ok := resolveEncounterClass(resource.Class); !ok {
+		value := resource.Class.Code
+		if value == "" {
+			value = resource.Class.Display
+		}
+		return models.Encounter{}, models.NewAppError("INVALID_FIELD", "class inválido: "+value, http.StatusBadRequest)
+	}
+
+	if resource.Period == nil || resource.Period.Start == "" {
+		return models.Encounter{}, models.NewAppError("INVALID_FIELD", "period.start é obrigatório", http.StatusBadRequest)
+	}
+
+	encounter, err := fromFhirEncounter(resource)
+	if err != nil {
+		return models.Encounter{}, err
+	}
+
+	if !encounter.Period.End.IsZero() && encounter.Period.End.Before(encounter.Period.Start) {
+		return models.Encounter{}, models.NewAppError("INVALID_FIELD", "period.end deve ser maior ou igual a period.start", http.StatusBadRequest)
+	}
+
+	if !encounter.PatientID.IsZero() {
+		if err := s.ensureExists(ctx, "patients", "Patient", encounter.PatientID); err != nil {
+			return models.Encounter{}, err
+		}
+	}
+	if !encounter.PractitionerID.IsZero() {
+		if err := s.ensureExists(ctx, "practitioners", "Practitioner", encounter.PractitionerID); err != nil {
+			return models.Encounter{}, err
+		}
+	}
+
+	return encounter, nil
+}
+
+// ensureExists falha com 400 quando a referência aponta para um documento inexistente.
+func (s *EncounterService) ensureExists(ctx context.Context, collection, resourceType string, id primitive.ObjectID) error {
+	count, err := s.db.Collection(collection).CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		s.logger.WithError(err).WithField("collection", collection).Error("falha ao verificar referência no MongoDB")
+		return models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	if count == 0 {
+		return models.NewAppError("INVALID_FIELD", "referência não encontrada: "+resourceType+"/"+id.Hex(), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// ensureNotReferenced falha com 409 enquanto algum encounter referenciar o
+// documento pelo campo informado (patientId ou practitionerId), para que a
+// remoção não deixe referências pendentes.
+func ensureNotReferenced(ctx context.Context, db *mongo.Database, logger *logrus.Logger, field, resourceType string, id primitive.ObjectID) error {
+	count, err := db.Collection("encounters").CountDocuments(ctx, bson.M{field: id})
+	if err != nil {
+		logger.WithError(err).WithField(field, id.Hex()).Error("falha ao verificar encounters que referenciam o documento")
+		return models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	if count > 0 {
+		return models.NewAppError("CONFLICT", fmt.Sprintf("%s/%s é referenciado por %d encounter(s); remova-os ou altere a referência antes", resourceType, id.Hex(), count), http.StatusConflict)
+	}
+	return nil
+}
+
+func (s *EncounterService) UpdateEncounterStatus(ctx context.Context, id, status string, expected int64) (*fhir.Encounter, error) {
 	startTime := time.Now()
 	logFields := logrus.Fields{
 		"operation":   "UpdateEncounterStatus",
@@ -127,60 +564,65 @@ func (s *EncounterService) UpdateEncounterStatus(ctx context.Context, id, status
 
 	if !s.validStatus[status] {
 		s.logger.WithFields(logFields).Warn("status inválido fornecido")
-		return models.NewAppError("INVALID_STATUS", "status inválido: "+status, http.StatusBadRequest)
+		return nil, models.NewAppError("INVALID_STATUS", "status inválido: "+status, http.StatusBadRequest)
 	}
 
 	objectID, errVal := primitive.ObjectIDFromHex(id)
 	if errVal != nil {
-		return models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
 	}
 
-	collection := s.db.Collection("encounters")
-	result, err := collection.UpdateOne(
-		ctx,
-		bson.M{"_id": objectID},
-		bson.M{"$set": bson.M{"status": status}},
-	)
-
+	encounter, err := s.findEncounter(ctx, objectID, logFields)
 	if err != nil {
-		s.logger.WithFields(logFields).WithError(err).Error("falha ao atualizar status no MongoDB")
-		return models.NewAppError("DATABASE_ERROR", "erro ao atualizar o banco de dados", http.StatusInternalServerError)
+		return nil, err
+	}
+	if err := checkVersion("Encounter", objectID, encounter.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	if err := s.versions.archiveLegacy(ctx, "Encounter", objectID, encounter.Meta, encounter); err != nil {
+		return nil, err
+	}
+
+	logFields["previousStatus"] = encounter.Status
+	if err := s.transitions.applyStatus(encounter, status, time.Now().UTC()); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("transição de status inválida")
+		return nil, err
 	}
 
-	if result.MatchedCount == 0 {
-		s.logger.WithFields(logFields).Warn("nenhum encounter encontrado para atualização")
-		return models.NewAppError("NOT_FOUND", "encounter não encontrado", http.StatusNotFound)
+	current := encounter.Meta
+	encounter.Meta = nextVersion(current)
+
+	collection := s.db.Collection("encounters")
+	err = s.versions.write(ctx, "Encounter", objectID, encounter.Meta, http.MethodPatch, encounter, func() (bool, error) {
+		result, err := collection.ReplaceOne(ctx, versionFilter(objectID, current), encounter)
+		return err == nil && result.MatchedCount > 0, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao atualizar status")
+		return nil, err
 	}
 
 	logFields["duration"] = time.Since(startTime).String()
-	logFields["matchedCount"] = result.MatchedCount
-	logFields["modifiedCount"] = result.ModifiedCount
+	logFields["versionId"] = encounter.Meta.VersionID
 	s.logger.WithFields(logFields).Info("status de encounter atualizado com sucesso")
 
-	return nil
+	return toFhirEncounter(*encounter), nil
 }
 
-func (s *EncounterService) mapToResponse(encounter models.Encounter, fields []string) *models.EncounterResponse {
-	response := &models.EncounterResponse{}
-
-	for _, field := range fields {
-		switch field {
-		case "fhirId":
-			response.FhirId = &encounter.FhirId
-		case "fullUrl":
-			response.FullURL = &encounter.FullUrl
-		case "status":
-			response.Status = &encounter.Status
-		case "class":
-			response.Class = &encounter.Class
-		case "period":
-			response.Period = &encounter.Period
-		case "practitionerId":
-			response.PractitionerID = &encounter.PractitionerID
-		case "patientId":
-			response.PatientID = &encounter.PatientID
+// findEncounter carrega o documento persistido, usado como base das atualizações.
+func (s *EncounterService) findEncounter(ctx context.Context, id primitive.ObjectID, logFields logrus.Fields) (*models.Encounter, error) {
+	var encounter models.Encounter
+	err := s.db.Collection("encounters").FindOne(ctx, bson.M{"_id": id}).Decode(&encounter)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.WithFields(logFields).Warn("nenhum encounter encontrado para atualização")
+			return nil, models.NewAppError("NOT_FOUND", "encounter não encontrado", http.StatusNotFound)
 		}
-	}
 
-	return response
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar encounter no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return &encounter, nil
 }
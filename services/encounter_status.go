@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"fhir-api/models"
+)
+
+// enteredInError pode ser alcançado a partir de qualquer status, independentemente do grafo.
+const enteredInError = "entered-in-error"
+
+// encounterStatuses são os códigos de Encounter.status aceitos, tanto nos
+// recursos quanto no grafo de transições.
+var encounterStatuses = map[string]bool{
+	"planned":          true,
+	"in-progress":      true,
+	"on-hold":          true,
+	"discharged":       true,
+	"completed":        true,
+	"finished":         true,
+	"cancelled":        true,
+	"discontinued":     true,
+	"entered-in-error": true,
+	"unknown":          true,
+}
+
+// StatusTransitions é o grafo de transições de status de encounter: para cada
+// status, os status que podem sucedê-lo. Status sem saída são terminais.
+type StatusTransitions map[string][]string
+
+// DefaultStatusTransitions é o grafo usado quando ENCOUNTER_STATUS_TRANSITIONS não é definido.
+var DefaultStatusTransitions = StatusTransitions{
+	"planned":     {"in-progress", "cancelled"},
+	"in-progress": {"on-hold", "discharged", "finished", "completed", "discontinued"},
+	"on-hold":     {"in-progress", "discontinued", "cancelled"},
+	"discharged":  {"finished", "completed"},
+	"unknown":     {"planned", "in-progress", "on-hold", "discharged", "finished", "completed", "cancelled", "discontinued"},
+}
+
+// ParseStatusTransitions lê o grafo no formato "origem=destino|destino;origem=destino",
+// ex.: "planned=in-progress|cancelled;in-progress=finished". Status fora de
+// encounterStatuses são recusados, para que um erro de digitação não bloqueie
+// transições silenciosamente.
+func ParseStatusTransitions(value string) (StatusTransitions, error) {
+	transitions := StatusTransitions{}
+	for _, rule := range strings.Split(value, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		from, targets, ok := strings.Cut(rule, "=")
+		from = strings.TrimSpace(from)
+		if !ok || from == "" {
+			return nil, fmt.Errorf("regra de transição inválida: %q", rule)
+		}
+		if !encounterStatuses[from] {
+			return nil, fmt.Errorf("status desconhecido na regra %q: %q", rule, from)
+		}
+
+		for _, to := range strings.Split(targets, "|") {
+			if to = strings.TrimSpace(to); to == "" {
+				continue
+			}
+			if !encounterStatuses[to] {
+				return nil, fmt.Errorf("status desconhecido na regra %q: %q", rule, to)
+			}
+			transitions[from] = append(transitions[from], to)
+		}
+	}
+
+	if len(transitions) == 0 {
+		return nil, fmt.Errorf("nenhuma transição definida")
+	}
+	return transitions, nil
+}
+
+func (t StatusTransitions) allows(from, to string) bool {
+	if to == enteredInError {
+		return true
+	}
+	for _, candidate := range t[from] {
+		if candidate == to {
+			return true
+		}
+	}
+	return false
+}
+
+// terminal indica que o status encerra o encounter. entered-in-error não conta:
+// marca um registro incorreto, não o fim do atendimento.
+func (t StatusTransitions) terminal(status string) bool {
+	return len(t[status]) == 0 && status != enteredInError
+}
+
+// applyStatus muda o status do encounter conforme o grafo, registrando em
+// statusHistory o status anterior e o período em que vigorou. Ao entrar em um
+// status terminal, period.end é preenchido com o instante da mudança.
+func (t StatusTransitions) applyStatus(encounter *models.Encounter, status string, now time.Time) error {
+	from := encounter.Status
+	if status == from {
+		return nil
+	}
+
+	if !t.allows(from, status) {
+		allowed := append([]string{}, t[from]...)
+		allowed = append(allowed, enteredInError)
+		sort.Strings(allowed)
+		message := fmt.Sprintf("transição de status não permitida: %s → %s (permitidas: %s)", from, status, strings.Join(allowed, ", "))
+		return models.NewAppError("INVALID_TRANSITION", message, http.StatusUnprocessableEntity)
+	}
+
+	since := encounter.Period.Start
+	if n := len(encounter.StatusHistory); n > 0 {
+		since = encounter.StatusHistory[n-1].Period.End
+	}
+	encounter.StatusHistory = append(encounter.StatusHistory, models.StatusHistory{
+		Status: from,
+		Period: models.DateRange{Start: since, End: now},
+	})
+	encounter.Status = status
+
+	if t.terminal(status) && encounter.Period.End.IsZero() && !now.Before(encounter.Period.Start) {
+		encounter.Period.End = now
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"fhir-api/models"
+)
+
+func TestStatusTransitionsAllows(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"planned", "in-progress", true},
+		{"planned", "cancelled", true},
+		{"planned", "finished", false},
+		{"in-progress", "finished", true},
+		{"on-hold", "in-progress", true},
+		{"discharged", "in-progress", false},
+		{"finished", "in-progress", false},
+		{"cancelled", "planned", false},
+		{"unknown", "discontinued", true},
+		{"finished", "entered-in-error", true},
+		{"planned", "entered-in-error", true},
+		{"entered-in-error", "planned", false},
+	}
+	for _, tt := range tests {
+		if got := DefaultStatusTransitions.allows(tt.from, tt.to); got != tt.want {
+			t.Errorf("allows(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestStatusTransitionsApplyStatus(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	now := start.Add(2 * time.Hour)
+
+	tests := []struct {
+		name      string
+		from, to  string
+		end       time.Time
+		wantErr   bool
+		wantEnd   time.Time
+		wantTrail int
+	}{
+		{name: "same status is a no-op", from: "planned", to: "planned", wantTrail: 0},
+		{name: "allowed transition", from: "planned", to: "in-progress", wantTrail: 1},
+		{name: "terminal status closes the period", from: "in-progress", to: "finished", wantEnd: now, wantTrail: 1},
+		{name: "terminal status keeps an existing end", from: "in-progress", to: "finished", end: start.Add(time.Hour), wantEnd: start.Add(time.Hour), wantTrail: 1},
+		{name: "entered-in-error bypasses the graph", from: "finished", to: "entered-in-error", wantTrail: 1},
+		{name: "entered-in-error does not close the period", from: "in-progress", to: "entered-in-error", wantTrail: 1},
+		{name: "transition outside the graph", from: "finished", to: "in-progress", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encounter := &models.Encounter{Status: tt.from, Period: models.Period{Start: start, End: tt.end}}
+			err := DefaultStatusTransitions.applyStatus(encounter, tt.to, now)
+
+			if tt.wantErr {
+				var appErr *models.AppError
+				if !errors.As(err, &appErr) || appErr.Code != "INVALID_TRANSITION" || appErr.StatusCode != http.StatusUnprocessableEntity {
+					t.Fatalf("err = %v, want INVALID_TRANSITION 422", err)
+				}
+				if encounter.Status != tt.from || len(encounter.StatusHistory) != 0 {
+					t.Errorf("encounter changed on rejected transition: %+v", encounter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if encounter.Status != tt.to {
+				t.Errorf("status = %q, want %q", encounter.Status, tt.to)
+			}
+			if !encounter.Period.End.Equal(tt.wantEnd) {
+				t.Errorf("period.end = %v, want %v", encounter.Period.End, tt.wantEnd)
+			}
+			if len(encounter.StatusHistory) != tt.wantTrail {
+				t.Fatalf("statusHistory has %d entries, want %d", len(encounter.StatusHistory), tt.wantTrail)
+			}
+			if tt.wantTrail > 0 {
+				entry := encounter.StatusHistory[0]
+				if entry.Status != tt.from || !entry.Period.Start.Equal(start) || !entry.Period.End.Equal(now) {
+					t.Errorf("statusHistory[0] = %+v, want %s from %v to %v", entry, tt.from, start, now)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyStatusChainsHistoryPeriods(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	encounter := &models.Encounter{Status: "planned", Period: models.Period{Start: start}}
+
+	steps := []string{"in-progress", "on-hold", "in-progress"}
+	for i, status := range steps {
+		if err := DefaultStatusTransitions.applyStatus(encounter, status, start.Add(time.Duration(i+1)*time.Hour)); err != nil {
+			t.Fatalf("applyStatus(%q): %v", status, err)
+		}
+	}
+
+	for i, entry := range encounter.StatusHistory {
+		wantStart := start.Add(time.Duration(i) * time.Hour)
+		if !entry.Period.Start.Equal(wantStart) || !entry.Period.End.Equal(wantStart.Add(time.Hour)) {
+			t.Errorf("statusHistory[%d] period = %+v, want [%v, %v]", i, entry.Period, wantStart, wantStart.Add(time.Hour))
+		}
+	}
+	if !encounter.Period.End.IsZero() {
+		t.Errorf("period.end = %v, want unset while in progress", encounter.Period.End)
+	}
+}
+
+func TestParseStatusTransitions(t *testing.T) {
+	transitions, err := ParseStatusTransitions(" planned = in-progress | cancelled ; in-progress=finished;")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !transitions.allows("planned", "cancelled") || !transitions.allows("in-progress", "finished") {
+		t.Errorf("transitions = %v, missing parsed rules", transitions)
+	}
+	if !transitions.terminal("finished") || transitions.terminal("planned") {
+		t.Errorf("terminal statuses not derived from the parsed graph: %v", transitions)
+	}
+
+	errorTests := []struct {
+		value   string
+		mention string
+	}{
+		{"", "nenhuma transição"},
+		{" ; ", "nenhuma transição"},
+		{"planned", "planned"},
+		{"=finished", "=finished"},
+		{"in_progress=finished", "in_progress"},
+		{"planned=in-progress|canceled", "canceled"},
+	}
+	for _, tt := range errorTests {
+		_, err := ParseStatusTransitions(tt.value)
+		if err == nil {
+			t.Errorf("ParseStatusTransitions(%q) accepted an invalid graph", tt.value)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.mention) {
+			t.Errorf("ParseStatusTransitions(%q) error %q does not mention %q", tt.value, err, tt.mention)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func toFhirPatient(patient models.Patient) *fhir.Patient {
+	resource := &fhir.Patient{
+		ResourceType: "Patient",
+		ID:           patient.ID.Hex(),
+		Meta:         fhirMeta(patient.Meta),
+		Identifier:   fhirIdentifiers(patient.FhirId, patient.Identifiers),
+		Gender:       patient.Gender,
+		BirthDate:    patient.BirthDate,
+	}
+
+	if name := humanName(patient.GivenName, patient.FamilyName); name != nil {
+		resource.Name = []fhir.HumanName{*name}
+	}
+
+	return resource
+}
+
+func toFhirPractitioner(practitioner models.Practitioner) *fhir.Practitioner {
+	resource := &fhir.Practitioner{
+		ResourceType: "Practitioner",
+		ID:           practitioner.ID.Hex(),
+		Meta:         fhirMeta(practitioner.Meta),
+		Identifier:   fhirIdentifiers(practitioner.FhirId, practitioner.Identifiers),
+		Gender:       practitioner.Gender,
+		BirthDate:    practitioner.BirthDate,
+	}
+
+	if name := humanName(practitioner.GivenName, practitioner.FamilyName); name != nil {
+		resource.Name = []fhir.HumanName{*name}
+	}
+
+	for _, telecom := range practitioner.Telecom {
+		resource.Telecom = append(resource.Telecom, fhir.ContactPoint{System: telecom.System, Value: telecom.Value, Use: telecom.Use})
+	}
+
+	for _, qualification := range practitioner.Qualifications {
+		item := fhir.PractitionerQualification{
+			Identifier: fhirIdentifiers("", qualification.Identifiers),
+			Code:       &fhir.CodeableConcept{Text: qualification.CodeText},
+		}
+		for _, coding := range qualification.Code {
+			item.Code.Coding = append(item.Code.Coding, fhir.Coding{System: coding.System, Code: coding.Code, Display: coding.Display})
+		}
+		if !qualification.Period.Start.IsZero() || !qualification.Period.End.IsZero() {
+			item.Period = &fhir.Period{
+				Start: fhir.FormatInstant(qualification.Period.Start),
+				End:   fhir.FormatInstant(qualification.Period.End),
+			}
+		}
+		if qualification.Issuer != nil {
+			item.Issuer = &fhir.Reference{Reference: qualification.Issuer.Reference, Display: qualification.Issuer.Display}
+		}
+		resource.Qualification = append(resource.Qualification, item)
+	}
+
+	return resource
+}
+
+func toFhirEncounter(encounter models.Encounter) *fhir.Encounter {
+	resource := &fhir.Encounter{
+		ResourceType: "Encounter",
+		ID:           encounter.ID.Hex(),
+		Meta:         fhirMeta(encounter.Meta),
+		Identifier:   fhirIdentifiers(encounter.FhirId, encounter.Identifiers),
+		Status:       encounter.Status,
+	}
+
+	resource.Meta.Source = encounter.FullUrl
+
+	for _, history := range encounter.StatusHistory {
+		resource.StatusHistory = append(resource.StatusHistory, fhir.EncounterStatusHistory{
+			Status: history.Status,
+			Period: fhir.Period{
+				Start: fhir.FormatInstant(history.Period.Start),
+				End:   fhir.FormatInstant(history.Period.End),
+			},
+		})
+	}
+
+	if encounter.Class.Code != "" {
+		resource.Class = &fhir.Coding{
+			System:  encounter.Class.System,
+			Code:    encounter.Class.Code,
+			Display: encounter.Class.Display,
+		}
+	}
+
+	if !encounter.PatientID.IsZero() {
+		resource.Subject = fhir.NewReference("Patient", encounter.PatientID.Hex())
+	}
+
+	if !encounter.PractitionerID.IsZero() {
+		resource.Participant = []fhir.EncounterParticipant{
+			{Individual: fhir.NewReference("Practitioner", encounter.PractitionerID.Hex())},
+		}
+	}
+
+	if !encounter.Period.Start.IsZero() {
+		resource.Period = &fhir.Period{
+			Start: fhir.FormatInstant(encounter.Period.Start),
+			End:   fhir.FormatInstant(encounter.Period.End),
+		}
+	}
+
+	return resource
+}
+
+// fromFhirPatient converte o recurso recebido no documento persistido. O id não
+// é copiado: é atribuído pelo servidor (ObjectID).
+func fromFhirPatient(resource *fhir.Patient) models.Patient {
+	patient := models.Patient{
+		Gender:    resource.Gender,
+		BirthDate: resource.BirthDate,
+	}
+
+	patient.FhirId, patient.Identifiers = identifiersFromFhir(resource.Identifier)
+	patient.GivenName, patient.FamilyName = nameFromFhir(resource.Name)
+
+	return patient
+}
+
+// fromFhirPractitioner converte o recurso recebido no documento persistido,
+// falhando quando o período de alguma qualificação não é um date/dateTime válido.
+func fromFhirPractitioner(resource *fhir.Practitioner) (models.Practitioner, error) {
+	practitioner := models.Practitioner{
+		Gender:    resource.Gender,
+		BirthDate: resource.BirthDate,
+	}
+
+	practitioner.FhirId, practitioner.Identifiers = identifiersFromFhir(resource.Identifier)
+	practitioner.GivenName, practitioner.FamilyName = nameFromFhir(resource.Name)
+
+	for _, telecom := range resource.Telecom {
+		practitioner.Telecom = append(practitioner.Telecom, models.ContactPoint{System: telecom.System, Value: telecom.Value, Use: telecom.Use})
+	}
+
+	for i, item := range resource.Qualification {
+		qualification := models.Qualification{}
+		_, qualification.Identifiers = identifiersFromFhir(item.Identifier)
+
+		if item.Code != nil {
+			qualification.CodeText = item.Code.Text
+			for _, coding := range item.Code.Coding {
+				qualification.Code = append(qualification.Code, models.Coding{System: coding.System, Code: coding.Code, Display: coding.Display})
+			}
+		}
+
+		if item.Period != nil {
+			period, err := dateRangeFromFhir(item.Period)
+			if err != nil {
+				return models.Practitioner{}, models.NewAppError("INVALID_FIELD", fmt.Sprintf("qualification[%d].period inválido: %v", i, err), http.StatusBadRequest)
+			}
+			qualification.Period = period
+		}
+
+		if item.Issuer != nil {
+			qualification.Issuer = &models.Reference{Reference: item.Issuer.Reference, Display: item.Issuer.Display}
+		}
+
+		practitioner.Qualifications = append(practitioner.Qualifications, qualification)
+	}
+
+	return practitioner, nil
+}
+
+// fromFhirEncounter converte o recurso recebido no documento persistido. O
+// documento guarda um único profissional, então no máximo um participant é aceito.
+// statusHistory é mantido pelo servidor e não é copiado.
+func fromFhirEncounter(resource *fhir.Encounter) (models.Encounter, error) {
+	encounter := models.Encounter{Status: resource.Status}
+
+	encounter.FhirId, encounter.Identifiers = identifiersFromFhir(resource.Identifier)
+
+	if resource.Meta != nil {
+		encounter.FullUrl = resource.Meta.Source
+	}
+
+	if resource.Class != nil {
+		if class, ok := resolveEncounterClass(resource.Class); ok {
+			encounter.Class = class.coding()
+		}
+	}
+
+	if resource.Subject != nil {
+		id, err := referenceID(resource.Subject, "Patient")
+		if err != nil {
+			return models.Encounter{}, models.NewAppError("INVALID_FIELD", "subject inválido: "+err.Error(), http.StatusBadRequest)
+		}
+		encounter.PatientID = id
+	}
+
+	if len(resource.Participant) > 1 {
+		return models.Encounter{}, models.NewAppError("INVALID_FIELD", "apenas um participant é suportado", http.StatusBadRequest)
+	}
+	for _, participant := range resource.Participant {
+		if participant.Individual == nil {
+			continue
+		}
+		id, err := referenceID(participant.Individual, "Practitioner")
+		if err != nil {
+			return models.Encounter{}, models.NewAppError("INVALID_FIELD", "participant.individual inválido: "+err.Error(), http.StatusBadRequest)
+		}
+		encounter.PractitionerID = id
+	}
+
+	if resource.Period != nil {
+		period, err := dateRangeFromFhir(resource.Period)
+		if err != nil {
+			return models.Encounter{}, models.NewAppError("INVALID_FIELD", "period inválido: "+err.Error(), http.StatusBadRequest)
+		}
+		encounter.Period = models.Period{Start: period.Start, End: period.End}
+	}
+
+	return encounter, nil
+}
+
+// referenceID extrai o ObjectID de uma referência relativa "Tipo/id" do tipo esperado.
+func referenceID(reference *fhir.Reference, resourceType string) (primitive.ObjectID, error) {
+	refType, id, ok := strings.Cut(reference.Reference, "/")
+	if !ok || refType != resourceType {
+		return primitive.NilObjectID, fmt.Errorf("esperada referência %s/{id}: %q", resourceType, reference.Reference)
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("id inválido: %q", id)
+	}
+	return objectID, nil
+}
+
+func dateRangeFromFhir(period *fhir.Period) (models.DateRange, error) {
+	var result models.DateRange
+	var err error
+
+	if period.Start != "" {
+		if result.Start, err = fhir.ParseDateTime(period.Start); err != nil {
+			return result, err
+		}
+	}
+	if period.End != "" {
+		if result.End, err = fhir.ParseDateTime(period.End); err != nil {
+			return result, err
+		}
+	}
+	if !result.Start.IsZero() && !result.End.IsZero() && result.End.Before(result.Start) {
+		return result, fmt.Errorf("end anterior a start")
+	}
+
+	return result, nil
+}
+
+// fhirMeta monta meta.versionId e meta.lastUpdated a partir do documento.
+func fhirMeta(meta models.ResourceMeta) *fhir.Meta {
+	return &fhir.Meta{
+		VersionID:   strconv.FormatInt(meta.Version(), 10),
+		LastUpdated: fhir.FormatInstant(meta.LastUpdated),
+	}
+}
+
+func hapiIdentifier(fhirId string) []fhir.Identifier {
+	if fhirId == "" {
+		return nil
+	}
+	return []fhir.Identifier{{System: fhir.HapiIdentifierSystem, Value: fhirId}}
+}
+
+func humanName(given, family string) *fhir.HumanName {
+	if given == "" && family == "" {
+		return nil
+	}
+
+	name := &fhir.HumanName{Use: "official", Family: family}
+	if given != "" {
+		name.Given = []string{given}
+	}
+	return name
+}
+
+// fhirIdentifiers junta o fhirId do Hapi aos demais identificadores persistidos.
+func fhirIdentifiers(fhirId string, identifiers []models.Identifier) []fhir.Identifier {
+	result := hapiIdentifier(fhirId)
+	for _, identifier := range identifiers {
+		result = append(result, fhir.Identifier{System: identifier.System, Value: identifier.Value})
+	}
+	return result
+}
+
+// identifiersFromFhir separa o identificador do Hapi (fhirId) dos demais.
+func identifiersFromFhir(identifiers []fhir.Identifier) (string, []models.Identifier) {
+	var fhirId string
+	var result []models.Identifier
+
+	for _, identifier := range identifiers {
+		if identifier.System == fhir.HapiIdentifierSystem {
+			fhirId = identifier.Value
+			continue
+		}
+		result = append(result, models.Identifier{System: identifier.System, Value: identifier.Value})
+	}
+
+	return fhirId, result
+}
+
+// nameFromFhir escolhe o nome oficial (ou o primeiro) e o achata nos campos
+// givenName/familyName do documento. O nome precisa ter passado por
+// validateName: givenName guarda um único given.
+func nameFromFhir(names []fhir.HumanName) (string, string) {
+	name := persistedName(names)
+	if name == nil {
+		return "", ""
+	}
+
+	var given string
+	if len(name.Given) > 0 {
+		given = name.Given[0]
+	}
+	return given, name.Family
+}
+
+// validateName recusa o nome persistido com mais de um given: o documento só
+// tem givenName, e juntá-los numa string mudaria o recurso devolvido depois.
+func validateName(names []fhir.HumanName) error {
+	if name := persistedName(names); name != nil && len(name.Given) > 1 {
+		return models.NewAppError("INVALID_FIELD", "name.given aceita apenas um valor: "+strings.Join(name.Given, ", "), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// persistedName é o nome que vai para o documento: o oficial ou o primeiro.
+func persistedName(names []fhir.HumanName) *fhir.HumanName {
+	if len(names) == 0 {
+		return nil
+	}
+
+	name := &names[0]
+	for i := range names {
+		if names[i].Use == "official" {
+			return &names[i]
+		}
+	}
+	return name
+}
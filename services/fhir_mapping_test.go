@@ -0,0 +1,53 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+
+	"fhir-api/fhir"
+)
+
+func TestNameFromFhir(t *testing.T) {
+	tests := []struct {
+		name       string
+		names      []fhir.HumanName
+		wantGiven  string
+		wantFamily string
+	}{
+		{name: "no name"},
+		{name: "first name", names: []fhir.HumanName{{Given: []string{"Ana"}, Family: "Silva"}, {Given: []string{"Aninha"}}}, wantGiven: "Ana", wantFamily: "Silva"},
+		{name: "official name", names: []fhir.HumanName{{Use: "nickname", Given: []string{"Aninha"}}, {Use: "official", Given: []string{"Ana"}, Family: "Silva"}}, wantGiven: "Ana", wantFamily: "Silva"},
+		{name: "family only", names: []fhir.HumanName{{Family: "Silva"}}, wantFamily: "Silva"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			given, family := nameFromFhir(tt.names)
+			if given != tt.wantGiven || family != tt.wantFamily {
+				t.Errorf("nameFromFhir = %q, %q, want %q, %q", given, family, tt.wantGiven, tt.wantFamily)
+			}
+		})
+	}
+}
+
+// TestValidateName garante que só o nome persistido precisa ter um único
+// given: outros nomes são descartados e não impedem a gravação.
+func TestValidateName(t *testing.T) {
+	valid := [][]fhir.HumanName{
+		nil,
+		{{Given: []string{"Ana"}, Family: "Silva"}},
+		{{Use: "official", Given: []string{"Ana"}}, {Use: "nickname", Given: []string{"Ana", "Maria"}}},
+	}
+	for _, names := range valid {
+		if err := validateName(names); err != nil {
+			t.Errorf("validateName(%+v): %v", names, err)
+		}
+	}
+
+	invalid := [][]fhir.HumanName{
+		{{Given: []string{"Ana", "Maria"}, Family: "Silva"}},
+		{{Use: "nickname", Given: []string{"Aninha"}}, {Use: "official", Given: []string{"Ana", "Maria"}}},
+	}
+	for _, names := range invalid {
+		assertAppError(t, validateName(names), "INVALID_FIELD", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyCollection guarda todas as versões de todos os recursos versionados.
+const historyCollection = "history"
+
+// historyType associa um tipo versionado à sua coleção e à conversão do documento gravado.
+type historyType struct {
+	collection string
+	decode     func(bson.Raw) (fhir.Resource, error)
+}
+
+var historyTypes = map[string]historyType{
+	"Patient":      {collection: "patients", decode: decodeVersion(toFhirPatient)},
+	"Practitioner": {collection: "practitioners", decode: decodeVersion(toFhirPractitioner)},
+	"Encounter":    {collection: "encounters", decode: decodeVersion(toFhirEncounter)},
+}
+
+func decodeVersion[T any, R fhir.Resource](convert func(T) R) func(bson.Raw) (fhir.Resource, error) {
+	return func(raw bson.Raw) (fhir.Resource, error) {
+		var document T
+		if err := bson.Unmarshal(raw, &document); err != nil {
+			return nil, err
+		}
+		return convert(document), nil
+	}
+}
+
+// versionStore grava as versões na coleção history a cada escrita. O índice
+// único por recurso e versão faz com que, de duas escritas concorrentes a partir
+// da mesma versão, só a primeira consiga gravar a versão seguinte.
+type versionStore struct {
+	collection *mongo.Collection
+	logger     *logrus.Logger
+}
+
+func newVersionStore(db *mongo.Database, logger *logrus.Logger) *versionStore {
+	return &versionStore{collection: db.Collection(historyCollection), logger: logger}
+}
+
+// nextVersion devolve o meta da versão seguinte à lida. lastUpdated é truncado
+// em milissegundos, a precisão do BSON date, para coincidir com o valor persistido.
+func nextVersion(current models.ResourceMeta) models.ResourceMeta {
+	return models.ResourceMeta{
+		VersionID:   current.Version() + 1,
+		LastUpdated: time.Now().UTC().Truncate(time.Millisecond),
+	}
+}
+
+// firstVersion é o meta de um recurso recém-criado.
+func firstVersion() models.ResourceMeta {
+	return models.ResourceMeta{VersionID: 1, LastUpdated: time.Now().UTC().Truncate(time.Millisecond)}
+}
+
+// versionFilter casa o documento somente se ele ainda estiver na versão lida.
+func versionFilter(id primitive.ObjectID, meta models.ResourceMeta) bson.M {
+	if meta.VersionID == 0 {
+		return bson.M{"_id": id, "versionId": bson.M{"$exists": false}}
+	}
+	return bson.M{"_id": id, "versionId": meta.VersionID}
+}
+
+// checkVersion aplica a pré-condição If-Match; expected 0 dispensa a verificação.
+func checkVersion(resourceType string, id primitive.ObjectID, current models.ResourceMeta, expected int64) error {
+	if expected == 0 || expected == current.Version() {
+		return nil
+	}
+	message := fmt.Sprintf("%s/%s está na versão %d, não na %d informada em If-Match", resourceType, id.Hex(), current.Version(), expected)
+	return models.NewAppError("PRECONDITION_FAILED", message, http.StatusPreconditionFailed)
+}
+
+// write grava a versão em history e então aplica a escrita no documento com
+// apply. Se apply falhar ou não encontrar o documento na versão esperada, a
+// versão gravada é descartada. document nil registra uma remoção. Os erros
+// devolvidos já são *models.AppError.
+func (v *versionStore) write(ctx context.Context, resourceType string, id primitive.ObjectID, meta models.ResourceMeta, method string, document interface{}, apply func() (bool, error)) error {
+	if err := v.record(ctx, resourceType, id, meta, method, document); err != nil {
+		return err
+	}
+
+	matched, err := apply()
+	if err != nil || !matched {
+		v.discard(ctx, resourceType, id, meta.VersionID)
+	}
+	if err != nil {
+		if appErr, ok := schemaViolation(err); ok {
+			return appErr
+		}
+		v.logger.WithError(err).WithField("resourceType", resourceType).Error("falha ao gravar documento no MongoDB")
+		return models.NewAppError("DATABASE_ERROR", "erro ao gravar no banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	if !matched {
+		return models.NewAppError("CONFLICT", fmt.Sprintf("%s/%s alterado concorrentemente, tente novamente", resourceType, id.Hex()), http.StatusConflict)
+	}
+	return nil
+}
+
+// archiveLegacy grava como versão 1 o estado de um documento anterior ao
+// versionamento, para que a primeira alteração não perca o estado original.
+// A existência da versão é verificada antes da inserção porque, dentro de uma
+// transação, a violação do índice único abortaria a transação inteira.
+func (v *versionStore) archiveLegacy(ctx context.Context, resourceType string, id primitive.ObjectID, meta models.ResourceMeta, document interface{}) error {
+	if meta.VersionID != 0 {
+		return nil
+	}
+
+	filter := bson.M{"resourceType": resourceType, "resourceId": id, "versionId": 1}
+	archived, err := v.collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		v.logger.WithError(err).WithField("resourceType", resourceType).Error("falha ao consultar histórico no MongoDB")
+		return models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	if archived > 0 {
+		return nil
+	}
+
+	err = v.record(ctx, resourceType, id, models.ResourceMeta{VersionID: 1, LastUpdated: meta.LastUpdated}, http.MethodPost, document)
+	var appErr *models.AppError
+	if errors.As(err, &appErr) && appErr.Code == "CONFLICT" {
+		return nil
+	}
+	return err
+}
+
+func (v *versionStore) record(ctx context.Context, resourceType string, id primitive.ObjectID, meta models.ResourceMeta, method string, document interface{}) error {
+	version := models.ResourceVersion{
+		ResourceType: resourceType,
+		ResourceID:   id,
+		VersionID:    meta.VersionID,
+		LastUpdated:  meta.LastUpdated,
+		Method:       method,
+	}
+
+	if document != nil {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			return err
+		}
+		version.Document = raw
+	}
+
+	if _, err := v.collection.InsertOne(ctx, version); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.NewAppError("CONFLICT", fmt.Sprintf("versão %d de %s/%s já existe, tente novamente", meta.VersionID, resourceType, id.Hex()), http.StatusConflict)
+		}
+		v.logger.WithError(err).WithField("resourceType", resourceType).Error("falha ao gravar versão no MongoDB")
+		return models.NewAppError("DATABASE_ERROR", "erro ao gravar no banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return nil
+}
+
+func (v *versionStore) discard(ctx context.Context, resourceType string, id primitive.ObjectID, versionID int64) {
+	_, err := v.collection.DeleteOne(ctx, bson.M{"resourceType": resourceType, "resourceId": id, "versionId": versionID})
+	if err != nil {
+		v.logger.WithError(err).WithFields(logrus.Fields{
+			"resourceType": resourceType,
+			"resourceId":   id.Hex(),
+			"versionId":    versionID,
+		}).Error("falha ao descartar versão não aplicada")
+	}
+}
+
+// HistoryEntry é uma versão de um recurso no histórico. Resource é nil quando a
+// versão registra uma remoção.
+type HistoryEntry struct {
+	ResourceType string
+	ID           string
+	VersionID    string
+	LastUpdated  string
+	Method       string
+	Resource     fhir.Resource
+}
+
+// HistoryResult é uma página do histórico, convertida em Bundle history pelos controllers.
+type HistoryResult struct {
+	Total    int64
+	Entries  []HistoryEntry
+	Next     string
+	Previous string
+}
+
+type HistoryService struct {
+	db     *mongo.Database
+	logger *logrus.Logger
+	limits SearchLimits
+}
+
+func NewHistoryService(db *mongo.Database, logger *logrus.Logger, limits SearchLimits) *HistoryService {
+	return &HistoryService{
+		db:     db,
+		logger: logger,
+		limits: limits,
+	}
+}
+
+// EnsureIndexes cria o índice único de versões e o usado por _history com _since.
+func (s *HistoryService) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "resourceType", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "versionId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "resourceType", Value: 1}, {Key: "lastUpdated", Value: -1}}},
+		{Keys: bson.D{{Key: "lastUpdated", Value: -1}}},
+	}
+
+	names, err := s.db.Collection(historyCollection).Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		s.logger.WithError(err).Error("falha ao criar índices de history")
+		return err
+	}
+
+	s.logger.WithField("indexes", names).Info("índices de history verificados")
+	return nil
+}
+
+// ReadVersion devolve o recurso na versão informada (vread). Documentos
+// anteriores ao versionamento respondem pela versão 1 a partir da coleção de origem.
+func (s *HistoryService) ReadVersion(ctx context.Context, resourceType, id, vid string) (fhir.Resource, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":    "ReadVersion",
+		"resourceType": resourceType,
+		"resourceId":   id,
+		"versionId":    vid,
+	}
+
+	kind, ok := historyTypes[resourceType]
+	if !ok {
+		return nil, models.NewAppError("NOT_FOUND", "tipo de recurso não versionado: "+resourceType, http.StatusNotFound)
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	versionID, err := strconv.ParseInt(vid, 10, 64)
+	if err != nil || versionID < 1 {
+		return nil, models.NewAppError("INVALID_INPUT", "versionId inválido: "+vid, http.StatusBadRequest)
+	}
+
+	var version models.ResourceVersion
+	err = s.db.Collection(historyCollection).FindOne(ctx, bson.M{
+		"resourceType": resourceType,
+		"resourceId":   objectID,
+		"versionId":    versionID,
+	}).Decode(&version)
+
+	var document bson.Raw
+	switch {
+	case err == nil:
+		if len(version.Document) == 0 {
+			s.logger.WithFields(logFields).Warn("versão solicitada registra uma remoção")
+			return nil, models.NewAppError("GONE", fmt.Sprintf("%s/%s foi removido na versão %d", resourceType, id, versionID), http.StatusGone)
+		}
+		document = version.Document
+	case errors.Is(err, mongo.ErrNoDocuments) && versionID == 1:
+		document, err = s.legacyDocument(ctx, kind.collection, objectID)
+		if err != nil {
+			s.logger.WithFields(logFields).WithError(err).Warn("versão não encontrada")
+			return nil, err
+		}
+	case errors.Is(err, mongo.ErrNoDocuments):
+		s.logger.WithFields(logFields).Warn("versão não encontrada")
+		return nil, models.NewAppError("NOT_FOUND", fmt.Sprintf("versão %s de %s/%s não encontrada", vid, resourceType, id), http.StatusNotFound)
+	default:
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar versão no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	resource, err := kind.decode(document)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao decodificar versão")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("consulta de versão realizada com sucesso")
+
+	return resource, nil
+}
+
+// legacyDocument busca na coleção de origem um documento ainda sem versionId.
+func (s *HistoryService) legacyDocument(ctx context.Context, collection string, id primitive.ObjectID) (bson.Raw, error) {
+	var document bson.Raw
+	err := s.db.Collection(collection).FindOne(ctx, bson.M{"_id": id, "versionId": bson.M{"$exists": false}}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, models.NewAppError("NOT_FOUND", "versão 1 de "+id.Hex()+" não encontrada", http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return document, nil
+}
+
+// History lista as versões de um recurso (id informado) ou de todos os recursos
+// do tipo, da mais recente para a mais antiga. _since restringe às versões
+// gravadas a partir do instante informado; _count e _cursor paginam.
+func (s *HistoryService) History(ctx context.Context, resourceType, id string, query url.Values) (*HistoryResult, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":    "History",
+		"resourceType": resourceType,
+		"resourceId":   id,
+		"query":        query.Encode(),
+	}
+
+	kind, ok := historyTypes[resourceType]
+	if !ok {
+		return nil, models.NewAppError("NOT_FOUND", "tipo de recurso não versionado: "+resourceType, http.StatusNotFound)
+	}
+
+	filter := bson.M{"resourceType": resourceType}
+
+	var objectID primitive.ObjectID
+	if id != "" {
+		var errVal error
+		if objectID, errVal = primitive.ObjectIDFromHex(id); errVal != nil {
+			return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+		}
+		filter["resourceId"] = objectID
+	}
+
+	if since := query.Get("_since"); since != "" {
+		t, err := fhir.ParseDateTime(since)
+		if err != nil {
+			return nil, models.NewAppError("INVALID_PARAM", "_since inválido: "+since, http.StatusBadRequest)
+		}
+		filter["lastUpdated"] = bson.M{"$gte": t}
+	}
+
+	// O histórico é sempre ordenado da versão mais recente para a mais antiga.
+	page, err := s.limits.page(url.Values{
+		"_sort":   {"-lastUpdated"},
+		"_count":  query["_count"],
+		"_cursor": query["_cursor"],
+	}, nil, map[string]bool{"lastUpdated": true})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de paginação inválidos")
+		return nil, err
+	}
+
+	found, err := findDocuments[models.ResourceVersion](ctx, s.db.Collection(historyCollection), filter, page)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar histórico no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	result := &HistoryResult{Total: found.total, Entries: []HistoryEntry{}, Next: found.next, Previous: found.previous}
+	for _, version := range found.documents {
+		entry := HistoryEntry{
+			ResourceType: resourceType,
+			ID:           version.ResourceID.Hex(),
+			VersionID:    strconv.FormatInt(version.VersionID, 10),
+			LastUpdated:  fhir.FormatInstant(version.LastUpdated),
+			Method:       version.Method,
+		}
+		if len(version.Document) > 0 {
+			if entry.Resource, err = kind.decode(version.Document); err != nil {
+				s.logger.WithFields(logFields).WithError(err).Error("falha ao decodificar versão")
+				return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+			}
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+
+	// Documento anterior ao versionamento e ainda não alterado: o histórico é a
+	// própria versão corrente.
+	if id != "" && found.total == 0 && query.Get("_since") == "" {
+		if document, err := s.legacyDocument(ctx, kind.collection, objectID); err == nil {
+			resource, err := kind.decode(document)
+			if err != nil {
+				s.logger.WithFields(logFields).WithError(err).Error("falha ao decodificar documento")
+				return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+			}
+			result.Total = 1
+			result.Entries = append(result.Entries, HistoryEntry{
+				ResourceType: resourceType,
+				ID:           id,
+				VersionID:    "1",
+				Method:       http.MethodPost,
+				Resource:     resource,
+			})
+		}
+	}
+
+	if id != "" && result.Total == 0 && query.Get("_since") == "" {
+		s.logger.WithFields(logFields).Warn("recurso sem histórico")
+		return nil, models.NewAppError("NOT_FOUND", resourceType+"/"+id+" não encontrado", http.StatusNotFound)
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["total"] = result.Total
+	s.logger.WithFields(logFields).Info("consulta de histórico realizada com sucesso")
+
+	return result, nil
+}
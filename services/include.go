@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// includeParam é um valor aceito em _include ou _revinclude, no formato
+// Origem:parâmetro, opcionalmente com o tipo alvo (Encounter:subject:Patient).
+// params lista o nome do elemento e o do parâmetro de busca equivalente.
+type includeParam struct {
+	source string
+	params []string
+	target string
+}
+
+var (
+	encounterSubject     = includeParam{source: "Encounter", params: []string{"subject", "patient"}, target: "Patient"}
+	encounterParticipant = includeParam{source: "Encounter", params: []string{"participant", "practitioner"}, target: "Practitioner"}
+)
+
+func (p includeParam) String() string {
+	return p.source + ":" + p.params[0]
+}
+
+func (p includeParam) matches(value string) bool {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != p.source {
+		return false
+	}
+	if len(parts) == 3 && parts[2] != p.target {
+		return false
+	}
+	for _, param := range p.params {
+		if parts[1] == param {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIncludes lê os valores de _include ou _revinclude (name), repetidos ou
+// separados por vírgula, e devolve os selecionados pela forma canônica
+// (includeParam.String). Valores não suportados pelo tipo buscado são 400.
+func parseIncludes(query url.Values, name string, supported ...includeParam) (map[string]bool, error) {
+	selected := map[string]bool{}
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			found := false
+			for _, param := range supported {
+				if param.matches(item) {
+					selected[param.String()], found = true, true
+				}
+			}
+			if !found {
+				return nil, models.NewAppError("INVALID_PARAM", name+" não suportado: "+item, http.StatusBadRequest)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// authorizeIncludes devolve 403 quando os scopes do contexto não permitem ler
+// algum tipo trazido pelos parâmetros selecionados: o alvo em _include ou a
+// origem em _revinclude (reverse). Sem isso um token restrito ao tipo buscado
+// leria os demais pela inclusão.
+func authorizeIncludes(ctx context.Context, selected map[string]bool, reverse bool, supported ...includeParam) error {
+	for _, param := range supported {
+		if !selected[param.String()] {
+			continue
+		}
+		resourceType := param.target
+		if reverse {
+			resourceType = param.source
+		}
+		if err := authorize(ctx, resourceType, "read"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findIncluded busca num único $in os documentos cujo field está em ids e os
+// converte em recursos FHIR. limit 0 não limita.
+func findIncluded[T any](ctx context.Context, collection *mongo.Collection, field string, ids []primitive.ObjectID, limit int, toResource func(T) fhir.Resource) ([]fhir.Resource, error) {
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	seen := map[primitive.ObjectID]bool{}
+	for _, id := range ids {
+		if !id.IsZero() && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := collection.Find(ctx, bson.M{field: bson.M{"$in": unique}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var resources []fhir.Resource
+	for cursor.Next(ctx) {
+		var document T
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+		resources = append(resources, toResource(document))
+	}
+	return resources, cursor.Err()
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"fhir-api/models"
+)
+
+func TestParseIncludes(t *testing.T) {
+	query := url.Values{"_include": {"Encounter:patient,Encounter:participant:Practitioner"}}
+	selected, err := parseIncludes(query, "_include", encounterSubject, encounterParticipant)
+	if err != nil {
+		t.Fatalf("parseIncludes: %v", err)
+	}
+	if !selected["Encounter:subject"] || !selected["Encounter:participant"] {
+		t.Errorf("selected = %v, want both Encounter includes", selected)
+	}
+
+	for _, value := range []string{"Encounter:subject:Group", "Patient:general-practitioner", "Encounter"} {
+		_, err := parseIncludes(url.Values{"_include": {value}}, "_include", encounterSubject, encounterParticipant)
+		assertAppError(t, err, "INVALID_PARAM", http.StatusBadRequest)
+	}
+}
+
+// TestAuthorizeIncludes garante que um token restrito ao tipo buscado não lê os
+// demais tipos por _include ou _revinclude.
+func TestAuthorizeIncludes(t *testing.T) {
+	withScope := func(scope string) context.Context {
+		return WithPrincipal(context.Background(), &models.Principal{ClientID: "backend", Scope: scope})
+	}
+	both := map[string]bool{encounterSubject.String(): true, encounterParticipant.String(): true}
+
+	tests := []struct {
+		name     string
+		scope    string
+		selected map[string]bool
+		reverse  bool
+		allowed  bool
+	}{
+		{name: "no include", scope: "system/Encounter.rs", selected: map[string]bool{}, allowed: true},
+		{name: "include with only the primary type", scope: "system/Encounter.rs", selected: both},
+		{name: "include with one of the targets", scope: "system/Encounter.rs system/Patient.rs", selected: both},
+		{name: "include with every target", scope: "system/Encounter.rs system/Patient.r system/Practitioner.r", selected: both, allowed: true},
+		{name: "revinclude with only the primary type", scope: "system/Patient.rs", selected: map[string]bool{encounterSubject.String(): true}, reverse: true},
+		{name: "revinclude with the source type", scope: "system/Patient.rs system/Encounter.r", selected: map[string]bool{encounterSubject.String(): true}, reverse: true, allowed: true},
+		{name: "without a principal", selected: both},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.scope != "" {
+				ctx = withScope(tt.scope)
+			}
+
+			err := authorizeIncludes(ctx, tt.selected, tt.reverse, encounterSubject, encounterParticipant)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			assertAppError(t, err, "FORBIDDEN", http.StatusForbidden)
+		})
+	}
+}
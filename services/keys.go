@@ -0,0 +1,377 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"fhir-api/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// activeKeyFile guarda, no diretório de chaves, o kid da chave que assina.
+const activeKeyFile = "active"
+
+// signingKey é uma chave privada carregada de um PEM do diretório. O kid é o
+// thumbprint RFC 7638 da chave pública; as chaves geradas aqui são gravadas
+// como <kid>.pem, mas qualquer nome terminado em .pem é aceito.
+type signingKey struct {
+	kid     string
+	file    string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// KeyStore mantém as chaves de assinatura de tokens lidas de um diretório de
+// PEMs. Só a chave ativa assina; todas as presentes verificam, o que permite
+// trocar a chave sem invalidar os tokens já emitidos.
+type KeyStore struct {
+	dir    string
+	mu     sync.RWMutex
+	keys   map[string]*signingKey
+	active string
+}
+
+// NewKeyStore abre o diretório sem carregar as chaves; use Reload.
+func NewKeyStore(dir string) *KeyStore {
+	return &KeyStore{dir: dir, keys: map[string]*signingKey{}}
+}
+
+// LoadKeyStore carrega as chaves do diretório e, quando ele ainda não tem
+// nenhuma, gera e ativa uma chave RS256 inicial.
+func LoadKeyStore(dir string) (*KeyStore, error) {
+	store := NewKeyStore(dir)
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	if store.active != "" {
+		return store, nil
+	}
+	if len(store.keys) > 0 {
+		return nil, fmt.Errorf("nenhuma chave ativa em %s; ative uma com o comando keys activate", dir)
+	}
+	if _, err := store.Roll(jwt.SigningMethodRS256.Alg()); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload relê o diretório, incorporando chaves geradas ou ativadas por outro
+// processo, como o comando keys.
+func (s *KeyStore) Reload() error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*signingKey, len(files))
+	for _, file := range files {
+		key, err := readSigningKey(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if other := keys[key.kid]; other != nil {
+			return fmt.Errorf("%s e %s contêm a mesma chave", other.file, file)
+		}
+		keys[key.kid] = key
+	}
+
+	active, err := os.ReadFile(filepath.Join(s.dir, activeKeyFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	kid := strings.TrimSpace(string(active))
+	if kid != "" && keys[kid] == nil {
+		return fmt.Errorf("chave ativa %s não encontrada em %s", kid, s.dir)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.active = kid
+	return nil
+}
+
+// Sign assina as claims com a chave ativa, informando o kid no header.
+func (s *KeyStore) Sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	key := s.keys[s.active]
+	s.mu.RUnlock()
+	if key == nil {
+		return "", errors.New("nenhuma chave de assinatura ativa")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Keyfunc escolhe a chave pública pelo kid do header e exige que o alg do token
+// seja o da chave, recusando HMAC e qualquer troca de algoritmo.
+func (s *KeyStore) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	s.mu.RLock()
+	key := s.keys[kid]
+	s.mu.RUnlock()
+	if key == nil {
+		return nil, fmt.Errorf("kid desconhecido: %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.private.Public(), nil
+}
+
+// JWKS publica as chaves públicas de verificação, a ativa primeiro.
+func (s *KeyStore) JWKS() models.JSONWebKeySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := models.JSONWebKeySet{Keys: []models.JSONWebKey{}}
+	for _, kid := range s.kidsLocked() {
+		set.Keys = append(set.Keys, publicJWK(s.keys[kid]))
+	}
+	return set
+}
+
+// KeyInfo resume uma chave para o comando keys list.
+type KeyInfo struct {
+	Kid    string
+	Alg    string
+	Active bool
+}
+
+// Keys lista as chaves carregadas, a ativa primeiro.
+func (s *KeyStore) Keys() []KeyInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var infos []KeyInfo
+	for _, kid := range s.kidsLocked() {
+		infos = append(infos, KeyInfo{Kid: kid, Alg: s.keys[kid].method.Alg(), Active: kid == s.active})
+	}
+	return infos
+}
+
+func (s *KeyStore) kidsLocked() []string {
+	kids := make([]string, 0, len(s.keys))
+	for kid := range s.keys {
+		kids = append(kids, kid)
+	}
+	sort.Slice(kids, func(a, b int) bool {
+		if (kids[a] == s.active) != (kids[b] == s.active) {
+			return kids[a] == s.active
+		}
+		return kids[a] < kids[b]
+	})
+	return kids
+}
+
+// Generate cria uma nova chave (RS256 ou ES256) no diretório sem ativá-la, para
+// que seja publicada no JWKS antes de começar a assinar.
+func (s *KeyStore) Generate(alg string) (string, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return "", fmt.Errorf("algoritmo não suportado: %s (use RS256 ou ES256)", alg)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+	key, err := newSigningKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, key.kid+".pem"), data, 0o600); err != nil {
+		return "", err
+	}
+	return key.kid, s.Reload()
+}
+
+// Activate passa a assinar com a chave kid; as demais continuam verificando.
+func (s *KeyStore) Activate(kid string) error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	_, ok := s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("chave %s não encontrada em %s", kid, s.dir)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, activeKeyFile), []byte(kid+"\n"), 0o600); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// Roll gera uma chave e a ativa imediatamente, mantendo as anteriores para
+// verificação até serem aposentadas com Retire.
+func (s *KeyStore) Roll(alg string) (string, error) {
+	kid, err := s.Generate(alg)
+	if err != nil {
+		return "", err
+	}
+	return kid, s.Activate(kid)
+}
+
+// Retire remove uma chave que não assina mais. Tokens assinados por ela deixam
+// de ser aceitos, então só deve ser feito depois que eles expirarem.
+func (s *KeyStore) Retire(kid string) error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	key, active := s.keys[kid], s.active
+	s.mu.RUnlock()
+	if key == nil {
+		return fmt.Errorf("chave %s não encontrada em %s", kid, s.dir)
+	}
+	if kid == active {
+		return fmt.Errorf("a chave %s está ativa; ative outra antes de aposentá-la", kid)
+	}
+	if err := os.Remove(key.file); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+func readSigningKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("PEM inválido")
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("tipo de chave não suportado")
+	}
+	key, err := newSigningKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	key.file = file
+	return key, nil
+}
+
+func newSigningKey(private crypto.Signer) (*signingKey, error) {
+	key := &signingKey{private: private}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("chaves RSA precisam de ao menos 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("chaves EC precisam usar a curva P-256")
+		}
+		key.method = jwt.SigningMethodES256
+	default:
+		return nil, errors.New("tipo de chave não suportado")
+	}
+
+	key.kid = thumbprint(publicJWK(key))
+	return key, nil
+}
+
+// publicJWK descreve a parte pública da chave como JWK.
+func publicJWK(key *signingKey) models.JSONWebKey {
+	jwk := models.JSONWebKey{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+	switch public := key.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64URL(public.N.Bytes())
+		jwk.E = base64URL(big.NewInt(int64(public.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = public.Curve.Params().Name
+		jwk.X = base64URL(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64URL(public.Y.FillBytes(make([]byte, size)))
+	}
+	return jwk
+}
+
+// thumbprint calcula o JWK thumbprint (RFC 7638): SHA-256 dos membros
+// obrigatórios em ordem lexicográfica.
+func thumbprint(jwk models.JSONWebKey) string {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64URL(sum[:])
+}
+
+func base64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// documentValidationFailure é o código devolvido pelo MongoDB quando o documento
+// viola o validator $jsonSchema da coleção.
+const documentValidationFailure = 121
+
+// schemaViolation converte a rejeição do validator $jsonSchema em erro 400,
+// listando os campos apontados em errInfo. ok é falso para qualquer outro erro.
+func schemaViolation(err error) (*models.AppError, bool) {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) || !serverErr.HasErrorCode(documentValidationFailure) {
+		return nil, false
+	}
+
+	message := "documento rejeitado pelo schema da coleção"
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, item := range writeErr.WriteErrors {
+			if fields := schemaFields(item.Details); len(fields) > 0 {
+				message += ": " + strings.Join(fields, ", ")
+				break
+			}
+		}
+	}
+
+	return models.NewAppError("SCHEMA_VALIDATION", message, http.StatusBadRequest), true
+}
+
+// schemaFields percorre o errInfo do MongoDB (5.0+) coletando as propriedades
+// ausentes (missingProperties) e as que falharam (propertiesNotSatisfied).
+func schemaFields(details bson.Raw) []string {
+	seen := map[string]bool{}
+
+	var walk func(value interface{}, prefix string)
+	walk = func(value interface{}, prefix string) {
+		switch v := value.(type) {
+		case bson.M:
+			if name, ok := v["propertyName"].(string); ok {
+				prefix += name
+				seen[prefix] = true
+				prefix += "."
+			}
+			if missing, ok := v["missingProperties"].(bson.A); ok {
+				for _, name := range missing {
+					if s, ok := name.(string); ok {
+						seen[prefix+s] = true
+					}
+				}
+			}
+			for key, child := range v {
+				if key != "specifiedAs" {
+					walk(child, prefix)
+				}
+			}
+		case bson.A:
+			for _, child := range v {
+				walk(child, prefix)
+			}
+		}
+	}
+
+	if len(details) > 0 {
+		var document bson.M
+		if err := bson.Unmarshal(details, &document); err == nil {
+			walk(document, "")
+		}
+	}
+
+	// period e period.start: mantém só o campo mais específico.
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		parent := false
+		for other := range seen {
+			if strings.HasPrefix(other, field+".") {
+				parent = true
+				break
+			}
+		}
+		if !parent {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchLimits define o tamanho de página padrão e o máximo aceito em _count.
+// MaxInclude limita os recursos trazidos por _revinclude numa página, já que
+// cada recurso da página pode ser referenciado por muitos outros.
+type SearchLimits struct {
+	DefaultCount int
+	MaxCount     int
+	MaxInclude   int
+}
+
+// sortKey é um campo da ordenação aplicada à busca; _id é sempre o último
+// critério para que a ordem seja total e o cursor estável.
+type sortKey struct {
+	field      string
+	descending bool
+}
+
+// pageCursor é o conteúdo do parâmetro opaco _cursor: a direção da página, a
+// ordenação com a qual foi gerado e os valores das chaves do documento limite.
+type pageCursor struct {
+	Direction string          `bson:"d"`
+	Sort      string          `bson:"s"`
+	Values    []bson.RawValue `bson:"v"`
+}
+
+const (
+	cursorNext     = "next"
+	cursorPrevious = "previous"
+)
+
+// pageRequest é a página solicitada via _count e _cursor.
+type pageRequest struct {
+	count  int
+	sort   []sortKey
+	cursor *pageCursor
+}
+
+// page lê _sort, _count e _cursor da query, validando o cursor contra a ordenação em uso.
+func (l SearchLimits) page(query url.Values, sortAliases map[string]string, validFields map[string]bool) (*pageRequest, error) {
+	sort, err := parseSort(query.Get("_sort"), sortAliases, validFields)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := append(sort, sortKey{field: "_id"})
+	request := &pageRequest{count: l.DefaultCount, sort: keys}
+
+	if value := query.Get("_count"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, models.NewAppError("INVALID_PARAM", "_count inválido: "+value, http.StatusBadRequest)
+		}
+		request.count = count
+	}
+	if request.count > l.MaxCount {
+		request.count = l.MaxCount
+	}
+
+	if value := query.Get("_cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil || cursor.Sort != sortSignature(request.sort) || len(cursor.Values) != len(request.sort) {
+			return nil, models.NewAppError("INVALID_PARAM", "_cursor inválido ou gerado para outra ordenação", http.StatusBadRequest)
+		}
+		request.cursor = cursor
+	}
+
+	return request, nil
+}
+
+// parseSort converte _sort (lista separada por vírgula, "-" para descendente) em
+// chaves de ordenação. Cada nome pode ser um parâmetro de busca mapeado em
+// sortAliases (ex.: date → period.start) ou o próprio campo do documento; em
+// ambos os casos o campo de primeiro nível precisa constar em validFields.
+func parseSort(value string, sortAliases map[string]string, validFields map[string]bool) ([]sortKey, error) {
+	var keys []sortKey
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		key := sortKey{}
+		if strings.HasPrefix(name, "-") {
+			key.descending = true
+			name = name[1:]
+		}
+
+		key.field = name
+		if alias, ok := sortAliases[name]; ok {
+			key.field = alias
+		}
+
+		root, _, _ := strings.Cut(key.field, ".")
+		if !validFields[root] {
+			return nil, models.NewAppError("INVALID_PARAM", "campo de ordenação inválido: "+name, http.StatusBadRequest)
+		}
+		if seen[key.field] {
+			return nil, models.NewAppError("INVALID_PARAM", "campo de ordenação repetido: "+name, http.StatusBadRequest)
+		}
+		seen[key.field] = true
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// documentPage é uma página de documentos com os cursores das páginas vizinhas.
+type documentPage[T any] struct {
+	total     int64
+	documents []T
+	next      string
+	previous  string
+}
+
+// findPage executa a busca paginada e converte os documentos em recursos FHIR.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page *pageRequest, toResource func(T) fhir.Resource) (*SearchResult, error) {
+	found, err := findDocuments[T](ctx, collection, filter, page)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Total: found.total, Resources: []fhir.Resource{}, Next: found.next, Previous: found.previous}
+	for _, document := range found.documents {
+		result.Resources = append(result.Resources, toResource(document))
+	}
+	return result, nil
+}
+
+// findDocuments executa a busca paginada por keyset: em vez de skip, filtra pelos
+// documentos posteriores (ou anteriores) ao limite registrado no cursor, de modo
+// que inserções e remoções não desloquem as páginas. Total considera só o filtro.
+func findDocuments[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page *pageRequest) (*documentPage[T], error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &documentPage[T]{total: total}
+	if page.count == 0 {
+		return result, nil
+	}
+
+	backward := page.cursor != nil && page.cursor.Direction == cursorPrevious
+	query := filter
+	if page.cursor != nil {
+		query = bson.M{"$and": []bson.M{filter, page.after(backward)}}
+	}
+
+	cursor, err := collection.Find(ctx, query, options.Find().
+		SetSort(page.sortSpec(backward)).
+		SetLimit(int64(page.count+1)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var documents []T
+	var keys [][]bson.RawValue
+	for cursor.Next(ctx) {
+		var document T
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+		keys = append(keys, page.keyValues(cursor.Current))
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	hasMore := len(documents) > page.count
+	if hasMore {
+		documents, keys = documents[:page.count], keys[:page.count]
+	}
+
+	if backward {
+		for i, j := 0, len(documents)-1; i < j; i, j = i+1, j-1 {
+			documents[i], documents[j] = documents[j], documents[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	result.documents = documents
+	if len(documents) == 0 {
+		return result, nil
+	}
+
+	signature := sortSignature(page.sort)
+	if (backward && hasMore) || (!backward && page.cursor != nil) {
+		result.previous = encodeCursor(pageCursor{Direction: cursorPrevious, Sort: signature, Values: keys[0]})
+	}
+	if (!backward && hasMore) || backward {
+		result.next = encodeCursor(pageCursor{Direction: cursorNext, Sort: signature, Values: keys[len(keys)-1]})
+	}
+
+	return result, nil
+}
+
+// sortSpec monta a ordenação do MongoDB, invertida ao paginar para trás.
+func (p *pageRequest) sortSpec(backward bool) bson.D {
+	spec := bson.D{}
+	for _, key := range p.sort {
+		direction := 1
+		if key.descending != backward {
+			direction = -1
+		}
+		spec = append(spec, bson.E{Key: key.field, Value: direction})
+	}
+	return spec
+}
+
+// after monta a condição de keyset: documentos estritamente depois do limite do
+// cursor na ordenação (ou antes, quando backward).
+func (p *pageRequest) after(backward bool) bson.M {
+	var alternatives []bson.M
+	for i, key := range p.sort {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[p.sort[j].field] = equalTo(p.cursor.Values[j])
+		}
+
+		greater := key.descending == backward
+		condition, ok := beyond(key.field, p.cursor.Values[i], greater)
+		if !ok {
+			continue
+		}
+		for field, value := range condition {
+			clause[field] = value
+		}
+		alternatives = append(alternatives, clause)
+	}
+
+	if len(alternatives) == 0 {
+		return matchNothing("_id")
+	}
+	return bson.M{"$or": alternatives}
+}
+
+// keyValues extrai do documento os valores das chaves de ordenação.
+func (p *pageRequest) keyValues(document bson.Raw) []bson.RawValue {
+	values := make([]bson.RawValue, 0, len(p.sort))
+	for _, key := range p.sort {
+		value, err := document.LookupErr(strings.Split(key.field, ".")...)
+		if err != nil {
+			value = bson.RawValue{Type: bsontype.Null}
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// equalTo compara igualdade; null também casa com campos ausentes.
+func equalTo(value bson.RawValue) interface{} {
+	if value.Type == bsontype.Null {
+		return nil
+	}
+	return value
+}
+
+// beyond devolve a condição "maior que" (ou "menor que") o valor. O MongoDB
+// ordena null/ausente antes de qualquer valor, o que é tratado explicitamente
+// porque $gt/$lt não comparam com null. ok é falso quando nada satisfaz a condição.
+func beyond(field string, value bson.RawValue, greater bool) (bson.M, bool) {
+	if value.Type == bsontype.Null {
+		if greater {
+			return bson.M{field: bson.M{"$ne": nil}}, true
+		}
+		return nil, false
+	}
+
+	if greater {
+		return bson.M{field: bson.M{"$gt": value}}, true
+	}
+	return bson.M{"$or": []bson.M{
+		{field: bson.M{"$lt": value}},
+		{field: nil},
+	}}, true
+}
+
+func sortSignature(sort []sortKey) string {
+	parts := make([]string, 0, len(sort))
+	for _, key := range sort {
+		if key.descending {
+			parts = append(parts, "-"+key.field)
+		} else {
+			parts = append(parts, key.field)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, err := bson.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor pageCursor
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Direction != cursorNext && cursor.Direction != cursorPrevious {
+		return nil, errors.New("direção de cursor inválida: " + cursor.Direction)
+	}
+	return &cursor, nil
+}
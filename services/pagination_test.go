@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	testLimits      = SearchLimits{DefaultCount: 20, MaxCount: 100}
+	testSortAliases = map[string]string{"date": "period.start", "family": "name.family"}
+	testValidFields = map[string]bool{"_id": true, "name": true, "status": true, "period": true}
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []sortKey
+	}{
+		{"", nil},
+		{"status", []sortKey{{field: "status"}}},
+		{"-status", []sortKey{{field: "status", descending: true}}},
+		{"date", []sortKey{{field: "period.start"}}},
+		{"-date, status", []sortKey{{field: "period.start", descending: true}, {field: "status"}}},
+		{"family,,", []sortKey{{field: "name.family"}}},
+		{"period.end", []sortKey{{field: "period.end"}}},
+	}
+	for _, tt := range tests {
+		got, err := parseSort(tt.value, testSortAliases, testValidFields)
+		if err != nil {
+			t.Errorf("parseSort(%q): %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSort(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseSortRejects(t *testing.T) {
+	for _, value := range []string{
+		"password",
+		"-secret.value",
+		"$where",
+		"status,-status",
+		"date,period.start",
+		"-",
+	} {
+		_, err := parseSort(value, testSortAliases, testValidFields)
+		assertInvalidParam(t, "parseSort("+value+")", err)
+	}
+}
+
+func TestPageCount(t *testing.T) {
+	tests := []struct {
+		count string
+		want  int
+	}{
+		{"", 20},
+		{"0", 0},
+		{"5", 5},
+		{"100", 100},
+		{"500", 100},
+	}
+	for _, tt := range tests {
+		query := url.Values{}
+		if tt.count != "" {
+			query.Set("_count", tt.count)
+		}
+		page, err := testLimits.page(query, testSortAliases, testValidFields)
+		if err != nil {
+			t.Errorf("_count=%q: %v", tt.count, err)
+			continue
+		}
+		if page.count != tt.want {
+			t.Errorf("_count=%q gave page size %d, want %d", tt.count, page.count, tt.want)
+		}
+	}
+
+	for _, count := range []string{"-1", "abc", "1.5"} {
+		_, err := testLimits.page(url.Values{"_count": {count}}, testSortAliases, testValidFields)
+		assertInvalidParam(t, "_count="+count, err)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	values := []bson.RawValue{
+		rawValue(t, "Silva"),
+		{Type: bsontype.Null},
+		rawValue(t, primitive.NewDateTimeFromTime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))),
+		rawValue(t, id),
+	}
+	original := pageCursor{Direction: cursorPrevious, Sort: "name.family,-birthDate,period.start,_id", Values: values}
+
+	encoded := encodeCursor(original)
+	if encoded == "" || strings.ContainsAny(encoded, "+/=") {
+		t.Fatalf("encodeCursor = %q, want non-empty URL-safe base64", encoded)
+	}
+	decoded, err := decodeCursor(encoded)
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if decoded.Direction != original.Direction || decoded.Sort != original.Sort || len(decoded.Values) != len(values) {
+		t.Fatalf("decoded = %+v, want %+v", decoded, original)
+	}
+	for i, value := range decoded.Values {
+		if value.Type != values[i].Type || !bytes.Equal(value.Value, values[i].Value) {
+			t.Errorf("value %d = %v, want %v", i, value, values[i])
+		}
+	}
+
+	// O cursor decodificado é aceito pela mesma ordenação com que foi gerado.
+	query := url.Values{"_sort": {"family,-status"}}
+	sortKeys, _ := parseSort(query.Get("_sort"), testSortAliases, testValidFields)
+	query.Set("_cursor", encodeCursor(pageCursor{
+		Direction: cursorNext,
+		Sort:      sortSignature(append(sortKeys, sortKey{field: "_id"})),
+		Values:    values[:3],
+	}))
+	page, err := testLimits.page(query, testSortAliases, testValidFields)
+	if err != nil {
+		t.Fatalf("page with a valid cursor: %v", err)
+	}
+	if page.cursor == nil || page.cursor.Direction != cursorNext {
+		t.Errorf("page cursor = %+v, want the decoded next cursor", page.cursor)
+	}
+}
+
+func TestPageRejectsInvalidCursor(t *testing.T) {
+	nameSort := "name,_id"
+	twoValues := []bson.RawValue{rawValue(t, "Ana"), rawValue(t, int32(1))}
+	garbage, _ := bson.Marshal(bson.M{"x": 1})
+	mustEncode := func(cursor pageCursor) string { return encodeCursor(cursor) }
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"not base64", "name", "%%%"},
+		{"standard base64 padding", "name", "AAAA=="},
+		{"base64 of random bytes", "name", "c29tZSByYW5kb20gYnl0ZXM"},
+		{"bson without direction", "name", base64.RawURLEncoding.EncodeToString(garbage)},
+		{"unknown direction", "name", mustEncode(pageCursor{Direction: "sideways", Sort: nameSort, Values: twoValues})},
+		{"generated for another sort", "-name", mustEncode(pageCursor{Direction: cursorNext, Sort: nameSort, Values: twoValues})},
+		{"generated without _sort", "", mustEncode(pageCursor{Direction: cursorNext, Sort: nameSort, Values: twoValues})},
+		{"too few values", "name", mustEncode(pageCursor{Direction: cursorNext, Sort: nameSort, Values: twoValues[:1]})},
+		{"too many values", "name", mustEncode(pageCursor{Direction: cursorNext, Sort: nameSort, Values: append(twoValues, twoValues[0])})},
+		{"truncated", "name", mustEncode(pageCursor{Direction: cursorNext, Sort: nameSort, Values: twoValues})[:10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"_cursor": {tt.cursor}}
+			if tt.sort != "" {
+				query.Set("_sort", tt.sort)
+			}
+			_, err := testLimits.page(query, testSortAliases, testValidFields)
+			assertInvalidParam(t, "_cursor", err)
+		})
+	}
+}
+
+func TestSortSpec(t *testing.T) {
+	page := &pageRequest{sort: []sortKey{{field: "name"}, {field: "period.start", descending: true}, {field: "_id"}}}
+
+	forward := bson.D{{Key: "name", Value: 1}, {Key: "period.start", Value: -1}, {Key: "_id", Value: 1}}
+	if got := page.sortSpec(false); !reflect.DeepEqual(got, forward) {
+		t.Errorf("sortSpec(false) = %v, want %v", got, forward)
+	}
+	backward := bson.D{{Key: "name", Value: -1}, {Key: "period.start", Value: 1}, {Key: "_id", Value: -1}}
+	if got := page.sortSpec(true); !reflect.DeepEqual(got, backward) {
+		t.Errorf("sortSpec(true) = %v, want %v", got, backward)
+	}
+}
+
+// TestKeysetWithNullSortFields confere, para cada documento usado como limite
+// do cursor, que a condição de keyset seleciona exatamente os documentos
+// depois dele na ordenação (e, para trás, exatamente os anteriores), inclusive
+// quando as chaves são null ou ausentes. Um erro aqui pula ou repete
+// resultados entre páginas.
+func TestKeysetWithNullSortFields(t *testing.T) {
+	documents := []bson.Raw{
+		document(t, bson.D{{Key: "_id", Value: int32(1)}, {Key: "name", Value: "Ana"}, {Key: "status", Value: "active"}}),
+		document(t, bson.D{{Key: "_id", Value: int32(2)}, {Key: "name", Value: nil}, {Key: "status", Value: "active"}}),
+		document(t, bson.D{{Key: "_id", Value: int32(3)}, {Key: "status", Value: "inactive"}}),
+		document(t, bson.D{{Key: "_id", Value: int32(4)}, {Key: "name", Value: "Bia"}}),
+		document(t, bson.D{{Key: "_id", Value: int32(5)}, {Key: "name", Value: "Ana"}, {Key: "status", Value: nil}}),
+		document(t, bson.D{{Key: "_id", Value: int32(6)}, {Key: "name", Value: "Ana"}, {Key: "status", Value: "inactive"}}),
+		document(t, bson.D{{Key: "_id", Value: int32(7)}}),
+		document(t, bson.D{{Key: "_id", Value: int32(8)}, {Key: "name", Value: "Bia"}, {Key: "status", Value: "active"}}),
+		document(t, bson.D{{Key: "_id", Value: int32(9)}, {Key: "name", Value: "Caio"}, {Key: "period", Value: bson.D{{Key: "start", Value: "2024-01-01"}}}}),
+		document(t, bson.D{{Key: "_id", Value: int32(10)}, {Key: "name", Value: "Caio"}, {Key: "period", Value: bson.D{}}}),
+	}
+
+	for _, sortParam := range []string{"", "name", "-name", "name,status", "name,-status", "-name,status", "-name,-status", "status,-name", "date", "-date,name"} {
+		t.Run("_sort="+sortParam, func(t *testing.T) {
+			page, err := testLimits.page(url.Values{"_sort": {sortParam}}, testSortAliases, testValidFields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ordered := append([]bson.Raw{}, documents...)
+			sort.SliceStable(ordered, func(a, b int) bool {
+				return compareKeys(page.sort, page.keyValues(ordered[a]), page.keyValues(ordered[b])) < 0
+			})
+
+			for i, boundary := range ordered {
+				page.cursor = &pageCursor{Values: page.keyValues(boundary)}
+
+				if got, want := matchingIDs(t, page.after(false), ordered), ids(ordered[i+1:]); !reflect.DeepEqual(got, want) {
+					t.Errorf("after %v: got %v, want %v", ids(ordered[i:i+1]), got, want)
+				}
+				if got, want := matchingIDs(t, page.after(true), ordered), ids(ordered[:i]); !reflect.DeepEqual(got, want) {
+					t.Errorf("before %v: got %v, want %v", ids(ordered[i:i+1]), got, want)
+				}
+			}
+		})
+	}
+}
+
+func assertInvalidParam(t *testing.T, what string, err error) {
+	t.Helper()
+	var appErr *models.AppError
+	if !errors.As(err, &appErr) || appErr.Code != "INVALID_PARAM" || appErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("%s: err = %v, want INVALID_PARAM 400", what, err)
+	}
+}
+
+func rawValue(t *testing.T, value interface{}) bson.RawValue {
+	t.Helper()
+	kind, data, err := bson.MarshalValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.RawValue{Type: kind, Value: data}
+}
+
+func document(t *testing.T, fields bson.D) bson.Raw {
+	t.Helper()
+	data, err := bson.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func ids(documents []bson.Raw) []int32 {
+	result := []int32{}
+	for _, document := range documents {
+		result = append(result, document.Lookup("_id").Int32())
+	}
+	return result
+}
+
+// matchingIDs devolve, na ordem de ordered, os documentos que satisfazem o filtro.
+func matchingIDs(t *testing.T, filter bson.M, ordered []bson.Raw) []int32 {
+	t.Helper()
+	result := []int32{}
+	for _, document := range ordered {
+		if matches(t, filter, document) {
+			result = append(result, document.Lookup("_id").Int32())
+		}
+	}
+	return result
+}
+
+// matches avalia o subconjunto de operadores gerado por after/beyond com a
+// semântica do MongoDB: null casa com campo ausente e $gt/$lt só comparam
+// valores do mesmo tipo.
+func matches(t *testing.T, filter bson.M, document bson.Raw) bool {
+	t.Helper()
+	for key, condition := range filter {
+		switch key {
+		case "$or", "$and":
+			clauses, ok := condition.([]bson.M)
+			if !ok {
+				t.Fatalf("%s with %T", key, condition)
+			}
+			some, all := false, true
+			for _, clause := range clauses {
+				if matches(t, clause, document) {
+					some = true
+				} else {
+					all = false
+				}
+			}
+			if (key == "$or" && !some) || (key == "$and" && !all) {
+				return false
+			}
+			continue
+		}
+
+		value := lookup(document, key)
+		switch c := condition.(type) {
+		case nil:
+			if value.Type != bsontype.Null {
+				return false
+			}
+		case bson.RawValue:
+			if value.Type != c.Type || compareValues(value, c) != 0 {
+				return false
+			}
+		case bson.M:
+			for operator, operand := range c {
+				switch operator {
+				case "$ne":
+					if operand != nil {
+						t.Fatalf("$ne with %v", operand)
+					}
+					if value.Type == bsontype.Null {
+						return false
+					}
+				case "$gt", "$lt":
+					bound := operand.(bson.RawValue)
+					if value.Type != bound.Type {
+						return false
+					}
+					cmp := compareValues(value, bound)
+					if (operator == "$gt" && cmp <= 0) || (operator == "$lt" && cmp >= 0) {
+						return false
+					}
+				default:
+					t.Fatalf("unexpected operator %s", operator)
+				}
+			}
+		default:
+			t.Fatalf("unexpected condition %T for %s", condition, key)
+		}
+	}
+	return true
+}
+
+func lookup(document bson.Raw, field string) bson.RawValue {
+	value, err := document.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return bson.RawValue{Type: bsontype.Null}
+	}
+	return value
+}
+
+// compareKeys ordena como o MongoDB: null/ausente antes de qualquer valor.
+func compareKeys(keys []sortKey, a, b []bson.RawValue) int {
+	for i, key := range keys {
+		cmp := compareValues(a[i], b[i])
+		if key.descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+func compareValues(a, b bson.RawValue) int {
+	switch {
+	case a.Type == bsontype.Null && b.Type == bsontype.Null:
+		return 0
+	case a.Type == bsontype.Null:
+		return -1
+	case b.Type == bsontype.Null:
+		return 1
+	case a.Type != b.Type:
+		panic(fmt.Sprintf("comparing %s with %s", a.Type, b.Type))
+	case a.Type == bsontype.String:
+		return strings.Compare(a.StringValue(), b.StringValue())
+	case a.Type == bsontype.Int32:
+		return int(a.Int32()) - int(b.Int32())
+	}
+	panic("unsupported type " + a.Type.String())
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+)
+
+const (
+	// JSONPatchMediaType identifica um corpo JSON Patch (RFC 6902).
+	JSONPatchMediaType = "application/json-patch+json"
+	// FHIRJSONMediaType identifica um recurso FHIR em JSON; num PATCH, o
+	// Parameters do FHIRPath Patch.
+	FHIRJSONMediaType = "application/fhir+json"
+)
+
+// Patch é o corpo de uma requisição PATCH e o media type com que foi enviado.
+type Patch struct {
+	ContentType string
+	Body        []byte
+}
+
+type patchEngine interface {
+	Apply(document []byte) ([]byte, error)
+}
+
+// parse decodifica o corpo conforme o media type, antes de qualquer leitura
+// no banco: um patch malformado é 400 e um media type desconhecido, 415.
+func (p Patch) parse() (patchEngine, error) {
+	switch p.ContentType {
+	case JSONPatchMediaType:
+		operations, err := fhir.ParseJSONPatch(p.Body)
+		if err != nil {
+			return nil, models.NewAppError("INVALID_INPUT", err.Error(), http.StatusBadRequest)
+		}
+		return operations, nil
+	case FHIRJSONMediaType, "application/json":
+		operations, err := fhir.ParseFHIRPathPatch(p.Body)
+		if err != nil {
+			return nil, models.NewAppError("INVALID_INPUT", err.Error(), http.StatusBadRequest)
+		}
+		return operations, nil
+	default:
+		return nil, models.NewAppError("UNSUPPORTED_MEDIA_TYPE", "content type não suportado em PATCH: "+p.ContentType, http.StatusUnsupportedMediaType)
+	}
+}
+
+// applyPatch aplica o patch à representação FHIR atual e decodifica o
+// resultado em patched, que segue depois pelo mesmo caminho de validação de um
+// update. Um patch que não se aplica ao recurso é 422.
+func applyPatch(engine patchEngine, current, patched fhir.Resource) error {
+	document, err := json.Marshal(current)
+	if err != nil {
+		return models.NewAppError("INTERNAL_ERROR", "falha ao serializar o recurso", http.StatusInternalServerError)
+	}
+
+	result, err := engine.Apply(document)
+	if err != nil {
+		return models.NewAppError("PATCH_FAILED", err.Error(), http.StatusUnprocessableEntity)
+	}
+
+	if err := json.Unmarshal(result, patched); err != nil {
+		return models.NewAppError("PATCH_FAILED", "o resultado do patch não é um "+current.ResourceTypeName()+" válido: "+err.Error(), http.StatusUnprocessableEntity)
+	}
+	if patched.ResourceID() != current.ResourceID() {
+		return models.NewAppError("PATCH_FAILED", "o patch não pode alterar o id do recurso", http.StatusUnprocessableEntity)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+)
+
+func assertAppError(t *testing.T, err error, code string, status int) {
+	t.Helper()
+	var appErr *models.AppError
+	if !errors.As(err, &appErr) || appErr.Code != code || appErr.StatusCode != status {
+		t.Fatalf("err = %v, want %s %d", err, code, status)
+	}
+}
+
+func TestPatchParse(t *testing.T) {
+	const fhirPathBody = `{"resourceType":"Parameters","parameter":[{"name":"operation","part":[
+		{"name":"type","valueCode":"delete"},{"name":"path","valueString":"Patient.gender"}]}]}`
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantCode    string
+		wantStatus  int
+	}{
+		{"JSON Patch", JSONPatchMediaType, `[{"op":"remove","path":"/gender"}]`, "", 0},
+		{"FHIRPath Patch", FHIRJSONMediaType, fhirPathBody, "", 0},
+		{"FHIRPath Patch as application/json", "application/json", fhirPathBody, "", 0},
+		{"malformed JSON Patch", JSONPatchMediaType, `[{"op":"remove","path":"gender"}]`, "INVALID_INPUT", http.StatusBadRequest},
+		{"JSON Patch sent as FHIR", FHIRJSONMediaType, `[{"op":"remove","path":"/gender"}]`, "INVALID_INPUT", http.StatusBadRequest},
+		{"malformed FHIRPath Patch", FHIRJSONMediaType, `{"resourceType":"Patient"}`, "INVALID_INPUT", http.StatusBadRequest},
+		{"merge patch", "application/merge-patch+json", `{"gender":null}`, "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType},
+		{"XML", "application/fhir+xml", `<Parameters/>`, "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType},
+		{"no content type", "", `[]`, "UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := Patch{ContentType: tt.contentType, Body: []byte(tt.body)}.parse()
+			if tt.wantCode == "" {
+				if err != nil || engine == nil {
+					t.Fatalf("parse = %v, %v, want a patch", engine, err)
+				}
+				return
+			}
+			assertAppError(t, err, tt.wantCode, tt.wantStatus)
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	current := &fhir.Patient{ResourceType: "Patient", ID: "p1", Gender: "female"}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantErr    bool
+		wantGender string
+	}{
+		{name: "applies", body: `[{"op":"replace","path":"/gender","value":"male"}]`, wantGender: "male"},
+		{name: "operation does not apply", body: `[{"op":"remove","path":"/birthDate"}]`, wantErr: true},
+		{name: "failing test", body: `[{"op":"test","path":"/gender","value":"male"}]`, wantErr: true},
+		{name: "result is not a Patient", body: `[{"op":"replace","path":"/gender","value":1}]`, wantErr: true},
+		{name: "changes the id", body: `[{"op":"replace","path":"/id","value":"p2"}]`, wantErr: true},
+		{name: "removes the id", body: `[{"op":"remove","path":"/id"}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := Patch{ContentType: JSONPatchMediaType, Body: []byte(tt.body)}.parse()
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			patched := &fhir.Patient{}
+			err = applyPatch(engine, current, patched)
+			if tt.wantErr {
+				assertAppError(t, err, "PATCH_FAILED", http.StatusUnprocessableEntity)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if patched.Gender != tt.wantGender || patched.ID != current.ID {
+				t.Errorf("patched = %+v, want gender %q and id %q", patched, tt.wantGender, current.ID)
+			}
+			if current.Gender != "female" {
+				t.Errorf("current resource was modified: %+v", current)
+			}
+		})
+	}
+}
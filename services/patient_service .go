@@ -4,24 +4,30 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/url"
 	"time"
 
+	"fhir-api/fhir"
 	"fhir-api/models"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type PatientService struct {
-	db          *mongo.Database
-	logger      *logrus.Logger
-	validFields map[string]bool
+	db           *mongo.Database
+	logger       *logrus.Logger
+	validFields  map[string]bool
+	validGender  map[string]bool
+	versions     *versionStore
+	searchParams searchParams
+	sortAliases  map[string]string
+	limits       SearchLimits
 }
 
-func NewPatientService(db *mongo.Database, logger *logrus.Logger) *PatientService {
+func NewPatientService(db *mongo.Database, logger *logrus.Logger, limits SearchLimits) *PatientService {
 	validFields := map[string]bool{
 		"fhirId":     true,
 		"givenName":  true,
@@ -30,31 +36,71 @@ func NewPatientService(db *mongo.Database, logger *logrus.Logger) *PatientServic
 		"gender":     true,
 	}
 
-	return &PatientService{
-		db:          db,
-		logger:      logger,
-		validFields: validFields,
+	validGender := map[string]bool{
+		"male":    true,
+		"female":  true,
+		"other":   true,
+		"unknown": true,
 	}
-}
 
-func (s *PatientService) GetPatient(ctx context.Context, id string, fields []string) (*models.PatientResponse, error) {
-	startTime := time.Now()
-	logFields := logrus.Fields{
-		"operation":       "GetPatient",
-		"patientId":       id,
-		"requestedFields": fields,
+	searchParams := searchParams{
+		"name": {
+			paramType:     "string",
+			documentation: "Prefixo do nome ou sobrenome",
+			filter:        stringFilter("givenName", "familyName"),
+		},
+		"family": {
+			paramType:     "string",
+			documentation: "Prefixo do sobrenome",
+			filter:        stringFilter("familyName"),
+		},
+		"given": {
+			paramType:     "string",
+			documentation: "Prefixo do nome",
+			filter:        stringFilter("givenName"),
+		},
+		"birthdate": {
+			paramType:     "date",
+			documentation: "Data de nascimento, aceita prefixos eq, ne, lt, gt, le, ge, sa, eb",
+			filter:        dateFilter("birthDate", true),
+		},
+		"gender": {
+			paramType:     "token",
+			documentation: "Gênero administrativo (male, female, other, unknown)",
+			filter:        tokenFilter("gender", "http://hl7.org/fhir/administrative-gender"),
+		},
+		"identifier": {
+			paramType:     "token",
+			documentation: "Identificador do paciente (system|value)",
+			filter:        identifierFilter,
+		},
 	}
 
-	for _, field := range fields {
-		if !s.validFields[field] {
-			s.logger.WithFields(logFields).WithField("invalidField", field).Warn("campo inválido solicitado")
-			return nil, models.NewAppError("INVALID_FIELD", "campo inválido solicitado: "+field, http.StatusBadRequest)
-		}
+	sortAliases := map[string]string{
+		"name":       "familyName",
+		"family":     "familyName",
+		"given":      "givenName",
+		"birthdate":  "birthDate",
+		"identifier": "fhirId",
 	}
 
-	projection := bson.M{}
-	for _, field := range fields {
-		projection[field] = 1
+	return &PatientService{
+		db:           db,
+		logger:       logger,
+		validFields:  validFields,
+		validGender:  validGender,
+		versions:     newVersionStore(db, logger),
+		searchParams: searchParams,
+		sortAliases:  sortAliases,
+		limits:       limits,
+	}
+}
+
+func (s *PatientService) GetPatient(ctx context.Context, id string) (*fhir.Patient, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "GetPatient",
+		"patientId": id,
 	}
 
 	collection := s.db.Collection("patients")
@@ -64,11 +110,10 @@ func (s *PatientService) GetPatient(ctx context.Context, id string, fields []str
 	if errVal != nil {
 		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
 	}
-	
+
 	err := collection.FindOne(
 		ctx,
 		bson.M{"_id": objectID},
-		options.FindOne().SetProjection(projection),
 	).Decode(&patient)
 
 	if err != nil {
@@ -78,33 +123,337 @@ func (s *PatientService) GetPatient(ctx context.Context, id string, fields []str
 		}
 
 		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar patient no MongoDB")
-		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError)
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
 	}
 
-	response := s.mapToResponse(patient, fields)
+	response := toFhirPatient(patient)
 	logFields["duration"] = time.Since(startTime).String()
 	s.logger.WithFields(logFields).Info("consulta de encounter realizada com sucesso")
 
 	return response, nil
 }
 
-func (s *PatientService) mapToResponse(patient models.Patient, fields []string) *models.PatientResponse {
-	response := &models.PatientResponse{}
-
-	for _, field := range fields {
-		switch field {
-		case "fhirId":
-			response.FhirId = &patient.FhirId
-		case "givenName":
-			response.GivenName = &patient.GivenName
-		case "familyName":
-			response.FamilyName = &patient.FamilyName
-		case "birthDate":
-			response.BirthDate = &patient.BirthDate
-		case "gender":
-			response.Gender = &patient.Gender
+// SearchParams descreve os parâmetros de busca aceitos por SearchPatients.
+func (s *PatientService) SearchParams() []fhir.CapabilitySearchParam {
+	return s.searchParams.capability()
+}
+
+// SearchRevInclude lista os valores aceitos em _revinclude por SearchPatients.
+func (s *PatientService) SearchRevInclude() []string {
+	return []string{encounterSubject.String()}
+}
+
+func (s *PatientService) SearchPatients(ctx context.Context, query url.Values) (*SearchResult, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "SearchPatients",
+		"query":     query.Encode(),
+	}
+
+	filter, err := s.searchParams.filter(query)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de busca inválidos")
+		return nil, err
+	}
+
+	page, err := s.limits.page(query, s.sortAliases, s.validFields)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de paginação inválidos")
+		return nil, err
+	}
+
+	revincludes, err := parseIncludes(query, "_revinclude", encounterSubject)
+	if err == nil {
+		_, err = parseIncludes(query, "_include")
+	}
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de inclusão inválidos")
+		return nil, err
+	}
+	if err := authorizeIncludes(ctx, revincludes, true, encounterSubject); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("scope insuficiente para os recursos incluídos")
+		return nil, err
+	}
+
+	var patientIDs []primitive.ObjectID
+	result, err := findPage(ctx, s.db.Collection("patients"), filter, page, func(patient models.Patient) fhir.Resource {
+		patientIDs = append(patientIDs, patient.ID)
+		return toFhirPatient(patient)
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar patients no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	// Os encounters de todos os patients da página vêm de um único $in, limitado
+	// por SEARCH_MAX_INCLUDE.
+	if revincludes[encounterSubject.String()] {
+		included, err := findIncluded(ctx, s.db.Collection("encounters"), "patientId", patientIDs, s.limits.MaxInclude, func(encounter models.Encounter) fhir.Resource {
+			return toFhirEncounter(encounter)
+		})
+		if err != nil {
+			s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar encounters incluídos no MongoDB")
+			return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+		}
+		result.Included = included
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["total"] = result.Total
+	logFields["included"] = len(result.Included)
+	s.logger.WithFields(logFields).Info("busca de patients realizada com sucesso")
+
+	return result, nil
+}
+
+func (s *PatientService) CreatePatient(ctx context.Context, resource *fhir.Patient) (*fhir.Patient, error) {
+	return s.createPatient(ctx, primitive.NewObjectID(), resource)
+}
+
+// createPatient grava o recurso com um id já atribuído, usado também pelas
+// transações, que reservam os ids antes de resolver referências urn:uuid.
+func (s *PatientService) createPatient(ctx context.Context, id primitive.ObjectID, resource *fhir.Patient) (*fhir.Patient, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "CreatePatient",
+	}
+
+	if err := s.validatePatient(resource); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patient inválido")
+		return nil, err
+	}
+
+	patient := fromFhirPatient(resource)
+	patient.ID = id
+	patient.Meta = firstVersion()
+	logFields["patientId"] = patient.ID.Hex()
+
+	collection := s.db.Collection("patients")
+	err := s.versions.write(ctx, "Patient", patient.ID, patient.Meta, http.MethodPost, patient, func() (bool, error) {
+		_, err := collection.InsertOne(ctx, patient)
+		return true, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao inserir patient")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("patient criado com sucesso")
+
+	return toFhirPatient(patient), nil
+}
+
+// ConditionalCreatePatient implementa o create condicional (If-None-Exist):
+// cria o patient só quando nenhum corresponde aos critérios e, caso contrário,
+// devolve o existente com created falso.
+func (s *PatientService) ConditionalCreatePatient(ctx context.Context, resource *fhir.Patient, criteria url.Values) (*fhir.Patient, bool, error) {
+	patient, created, err := conditionalCreate(ctx, "Patient", criteria, s.SearchPatients, func() (*fhir.Patient, error) {
+		return s.CreatePatient(ctx, resource)
+	})
+	if err == nil && !created {
+		s.logger.WithFields(logrus.Fields{
+			"operation": "ConditionalCreatePatient",
+			"patientId": patient.ID,
+			"criteria":  criteria.Encode(),
+		}).Info("patient já existente para os critérios, nada criado")
+	}
+	return patient, created, err
+}
+
+// ConditionalUpdatePatient atualiza o patient encontrado pelos critérios ou
+// cria um novo quando nenhum corresponde.
+func (s *PatientService) ConditionalUpdatePatient(ctx context.Context, criteria url.Values, resource *fhir.Patient, expected int64) (*fhir.Patient, bool, error) {
+	return conditionalUpdate(ctx, "Patient", criteria, resource.ID, s.SearchPatients,
+		func(id string) (*fhir.Patient, error) { return s.UpdatePatient(ctx, id, resource, expected) },
+		func() (*fhir.Patient, error) { return s.CreatePatient(ctx, resource) },
+	)
+}
+
+func (s *PatientService) UpdatePatient(ctx context.Context, id string, resource *fhir.Patient, expected int64) (*fhir.Patient, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "UpdatePatient",
+		"patientId": id,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	if resource.ID != "" && resource.ID != id {
+		s.logger.WithFields(logFields).WithField("bodyId", resource.ID).Warn("id do recurso difere da URL")
+		return nil, models.NewAppError("INVALID_INPUT", "id do recurso difere do id da URL", http.StatusBadRequest)
+	}
+
+	current, err := s.findPatient(ctx, objectID, logFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("Patient", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	return s.replacePatient(ctx, logFields, startTime, current, resource, http.MethodPut)
+}
+
+// PatchPatient aplica um JSON Patch ou FHIRPath Patch ao patient armazenado e
+// grava o resultado como uma nova versão, validado como em UpdatePatient.
+func (s *PatientService) PatchPatient(ctx context.Context, id string, patch Patch, expected int64) (*fhir.Patient, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":   "PatchPatient",
+		"patientId":   id,
+		"contentType": patch.ContentType,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	engine, err := patch.parse()
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patch inválido")
+		return nil, err
+	}
+
+	current, err := s.findPatient(ctx, objectID, logFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("Patient", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	var resource fhir.Patient
+	if err := applyPatch(engine, toFhirPatient(*current), &resource); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patch não aplicável ao patient")
+		return nil, err
+	}
+
+	return s.replacePatient(ctx, logFields, startTime, current, &resource, http.MethodPatch)
+}
+
+// replacePatient valida o recurso e o grava como a versão seguinte de current,
+// desde que o documento não tenha mudado desde a leitura.
+func (s *PatientService) replacePatient(ctx context.Context, logFields logrus.Fields, startTime time.Time, current *models.Patient, resource *fhir.Patient, method string) (*fhir.Patient, error) {
+	if err := s.validatePatient(resource); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patient inválido")
+		return nil, err
+	}
+
+	objectID := current.ID
+	if err := s.versions.archiveLegacy(ctx, "Patient", objectID, current.Meta, current); err != nil {
+		return nil, err
+	}
+
+	patient := fromFhirPatient(resource)
+	patient.ID = objectID
+	patient.Meta = nextVersion(current.Meta)
+
+	collection := s.db.Collection("patients")
+	err := s.versions.write(ctx, "Patient", objectID, patient.Meta, method, patient, func() (bool, error) {
+		result, err := collection.ReplaceOne(ctx, versionFilter(objectID, current.Meta), patient)
+		return err == nil && result.MatchedCount > 0, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao atualizar patient")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["versionId"] = patient.Meta.VersionID
+	s.logger.WithFields(logFields).Info("patient atualizado com sucesso")
+
+	return toFhirPatient(patient), nil
+}
+
+func (s *PatientService) DeletePatient(ctx context.Context, id string, expected int64) error {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "DeletePatient",
+		"patientId": id,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	current, err := s.findPatient(ctx, objectID, logFields)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion("Patient", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return err
+	}
+	if err := ensureNotReferenced(ctx, s.db, s.logger, "patientId", "Patient", objectID); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patient ainda referenciado por encounters")
+		return err
+	}
+	if err := s.versions.archiveLegacy(ctx, "Patient", objectID, current.Meta, current); err != nil {
+		return err
+	}
+
+	collection := s.db.Collection("patients")
+	err = s.versions.write(ctx, "Patient", objectID, nextVersion(current.Meta), http.MethodDelete, nil, func() (bool, error) {
+		result, err := collection.DeleteOne(ctx, versionFilter(objectID, current.Meta))
+		return err == nil && result.DeletedCount > 0, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao remover patient")
+		return err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("patient removido com sucesso")
+
+	return nil
+}
+
+// ConditionalDeletePatient remove o patient encontrado pelos critérios.
+func (s *PatientService) ConditionalDeletePatient(ctx context.Context, criteria url.Values, expected int64) error {
+	return conditionalDelete(ctx, "Patient", criteria, s.SearchPatients, func(id string) error {
+		return s.DeletePatient(ctx, id, expected)
+	})
+}
+
+// findPatient carrega o documento persistido, usado como base das atualizações.
+func (s *PatientService) findPatient(ctx context.Context, id primitive.ObjectID, logFields logrus.Fields) (*models.Patient, error) {
+	var patient models.Patient
+	err := s.db.Collection("patients").FindOne(ctx, bson.M{"_id": id}).Decode(&patient)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.WithFields(logFields).Warn("nenhum patient encontrado para atualização")
+			return nil, models.NewAppError("NOT_FOUND", "patient não encontrado", http.StatusNotFound)
 		}
+
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar patient no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return &patient, nil
+}
+
+// validatePatient verifica o recurso recebido em create/update: gender deve
+// pertencer ao value set administrative-gender, birthDate ser um date FHIR e o
+// nome persistido ter no máximo um given.
+func (s *PatientService) validatePatient(resource *fhir.Patient) error {
+	if resource.ResourceType != "Patient" {
+		return models.NewAppError("INVALID_INPUT", "resourceType deve ser Patient", http.StatusBadRequest)
+	}
+
+	if resource.Gender != "" && !s.validGender[resource.Gender] {
+		return models.NewAppError("INVALID_FIELD", "gender inválido: "+resource.Gender, http.StatusBadRequest)
+	}
+
+	if resource.BirthDate != "" && !fhir.ValidDate(resource.BirthDate) {
+		return models.NewAppError("INVALID_FIELD", "birthDate inválido: "+resource.BirthDate, http.StatusBadRequest)
 	}
 
-	return response
+	return validateName(resource.Name)
 }
@@ -3,25 +3,33 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
+	"fhir-api/fhir"
 	"fhir-api/models"
 
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type PractitionerService struct {
-	db          *mongo.Database
-	logger      *logrus.Logger
-	validFields map[string]bool
+	db           *mongo.Database
+	logger       *logrus.Logger
+	validFields  map[string]bool
+	validGender  map[string]bool
+	validTelecom map[string]bool
+	versions     *versionStore
+	searchParams searchParams
+	sortAliases  map[string]string
+	limits       SearchLimits
 }
 
-func NewPractitionerService(db *mongo.Database, logger *logrus.Logger) *PractitionerService {
+func NewPractitionerService(db *mongo.Database, logger *logrus.Logger, limits SearchLimits) *PractitionerService {
 	validFields := map[string]bool{
 		"fhirId":     true,
 		"givenName":  true,
@@ -30,31 +38,71 @@ func NewPractitionerService(db *mongo.Database, logger *logrus.Logger) *Practiti
 		"gender":     true,
 	}
 
-	return &PractitionerService{
-		db:          db,
-		logger:      logger,
-		validFields: validFields,
+	validGender := map[string]bool{
+		"male":    true,
+		"female":  true,
+		"other":   true,
+		"unknown": true,
 	}
-}
 
-func (s *PractitionerService) GetPractitioner(ctx context.Context, id string, fields []string) (*models.PractitionerRespose, error) {
-	startTime := time.Now()
-	logFields := logrus.Fields{
-		"operation":       "GetPractitioner",
-		"practitionerId":  id,
-		"requestedFields": fields,
+	validTelecom := map[string]bool{
+		"phone": true,
+		"fax":   true,
+		"email": true,
+		"pager": true,
+		"url":   true,
+		"sms":   true,
+		"other": true,
 	}
 
-	for _, field := range fields {
-		if !s.validFields[field] {
-			s.logger.WithFields(logFields).WithField("invalidField", field).Warn("campo inválido solicitado")
-			return nil, models.NewAppError("INVALID_FIELD", "campo inválido solicitado: "+field, http.StatusBadRequest)
-		}
+	searchParams := searchParams{
+		"name": {
+			paramType:     "string",
+			documentation: "Prefixo do nome ou sobrenome",
+			filter:        stringFilter("givenName", "familyName"),
+		},
+		"family": {
+			paramType:     "string",
+			documentation: "Prefixo do sobrenome",
+			filter:        stringFilter("familyName"),
+		},
+		"given": {
+			paramType:     "string",
+			documentation: "Prefixo do nome",
+			filter:        stringFilter("givenName"),
+		},
+		"identifier": {
+			paramType:     "token",
+			documentation: "Identificador do profissional, ex.: número do conselho (system|value)",
+			filter:        identifierFilter,
+		},
+	}
+
+	sortAliases := map[string]string{
+		"name":       "familyName",
+		"family":     "familyName",
+		"given":      "givenName",
+		"identifier": "fhirId",
+	}
+
+	return &PractitionerService{
+		db:           db,
+		logger:       logger,
+		validFields:  validFields,
+		validGender:  validGender,
+		validTelecom: validTelecom,
+		versions:     newVersionStore(db, logger),
+		searchParams: searchParams,
+		sortAliases:  sortAliases,
+		limits:       limits,
 	}
+}
 
-	projection := bson.M{}
-	for _, field := range fields {
-		projection[field] = 1
+func (s *PractitionerService) GetPractitioner(ctx context.Context, id string) (*fhir.Practitioner, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":      "GetPractitioner",
+		"practitionerId": id,
 	}
 
 	collection := s.db.Collection("practitioners")
@@ -68,7 +116,6 @@ func (s *PractitionerService) GetPractitioner(ctx context.Context, id string, fi
 	err := collection.FindOne(
 		ctx,
 		bson.M{"_id": objectID},
-		options.FindOne().SetProjection(projection),
 	).Decode(&practitioner)
 
 	if err != nil {
@@ -78,10 +125,10 @@ func (s *PractitionerService) GetPractitioner(ctx context.Context, id string, fi
 		}
 
 		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar practitioner no MongoDB")
-		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError)
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
 	}
 
-	response := s.mapToResponse(practitioner, fields)
+	response := toFhirPractitioner(practitioner)
 
 	logFields["duration"] = time.Since(startTime).String()
 	s.logger.WithFields(logFields).Info("consulta de encounter realizada com sucesso")
@@ -89,19 +136,325 @@ func (s *PractitionerService) GetPractitioner(ctx context.Context, id string, fi
 	return response, nil
 }
 
-func (s *PractitionerService) mapToResponse(practitioner models.Practitioner, fields []string) *models.PractitionerRespose {
-	response := &models.PractitionerRespose{}
+// SearchParams descreve os parâmetros de busca aceitos por SearchPractitioners.
+func (s *PractitionerService) SearchParams() []fhir.CapabilitySearchParam {
+	return s.searchParams.capability()
+}
+
+func (s *PractitionerService) SearchPractitioners(ctx context.Context, query url.Values) (*SearchResult, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "SearchPractitioners",
+		"query":     query.Encode(),
+	}
+
+	filter, err := s.searchParams.filter(query)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de busca inválidos")
+		return nil, err
+	}
+
+	page, err := s.limits.page(query, s.sortAliases, s.validFields)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de paginação inválidos")
+		return nil, err
+	}
+
+	// Nenhuma inclusão é suportada a partir de Practitioner; os valores são
+	// rejeitados em vez de ignorados.
+	if _, err := parseIncludes(query, "_include"); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de inclusão inválidos")
+		return nil, err
+	}
+	if _, err := parseIncludes(query, "_revinclude"); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("parâmetros de inclusão inválidos")
+		return nil, err
+	}
+
+	result, err := findPage(ctx, s.db.Collection("practitioners"), filter, page, func(practitioner models.Practitioner) fhir.Resource {
+		return toFhirPractitioner(practitioner)
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar practitioners no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["total"] = result.Total
+	s.logger.WithFields(logFields).Info("busca de practitioners realizada com sucesso")
+
+	return result, nil
+}
+
+func (s *PractitionerService) CreatePractitioner(ctx context.Context, resource *fhir.Practitioner) (*fhir.Practitioner, error) {
+	return s.createPractitioner(ctx, primitive.NewObjectID(), resource)
+}
+
+// createPractitioner grava o practitioner com o id recebido; CreatePractitioner
+// gera um id novo.
+func (s *PractitionerService) createPractitioner(ctx context.Context, id primitive.ObjectID, resource *fhir.Practitioner) (*fhir.Practitioner, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation": "CreatePractitioner",
+	}
+
+	practitioner, err := s.fromResource(resource)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("practitioner inválido")
+		return nil, err
+	}
+
+	practitioner.ID = id
+	practitioner.Meta = firstVersion()
+	logFields["practitionerId"] = practitioner.ID.Hex()
+
+	collection := s.db.Collection("practitioners")
+	err = s.versions.write(ctx, "Practitioner", practitioner.ID, practitioner.Meta, http.MethodPost, practitioner, func() (bool, error) {
+		_, err := collection.InsertOne(ctx, practitioner)
+		return true, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao inserir practitioner")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("practitioner criado com sucesso")
+
+	return toFhirPractitioner(practitioner), nil
+}
+
+// ConditionalCreatePractitioner cria o practitioner apenas se nenhum
+// corresponder aos critérios de If-None-Exist; senão devolve o existente.
+func (s *PractitionerService) ConditionalCreatePractitioner(ctx context.Context, resource *fhir.Practitioner, criteria url.Values) (*fhir.Practitioner, bool, error) {
+	practitioner, created, err := conditionalCreate(ctx, "Practitioner", criteria, s.SearchPractitioners, func() (*fhir.Practitioner, error) {
+		return s.CreatePractitioner(ctx, resource)
+	})
+	if err == nil && !created {
+		s.logger.WithFields(logrus.Fields{
+			"operation":      "ConditionalCreatePractitioner",
+			"practitionerId": practitioner.ID,
+			"criteria":       criteria.Encode(),
+		}).Info("practitioner já existente para os critérios, nada criado")
+	}
+	return practitioner, created, err
+}
+
+// ConditionalUpdatePractitioner atualiza o practitioner encontrado pelos
+// critérios, ou cria um quando nenhum corresponde.
+func (s *PractitionerService) ConditionalUpdatePractitioner(ctx context.Context, criteria url.Values, resource *fhir.Practitioner, expected int64) (*fhir.Practitioner, bool, error) {
+	return conditionalUpdate(ctx, "Practitioner", criteria, resource.ID, s.SearchPractitioners,
+		func(id string) (*fhir.Practitioner, error) { return s.UpdatePractitioner(ctx, id, resource, expected) },
+		func() (*fhir.Practitioner, error) { return s.CreatePractitioner(ctx, resource) },
+	)
+}
+
+func (s *PractitionerService) UpdatePractitioner(ctx context.Context, id string, resource *fhir.Practitioner, expected int64) (*fhir.Practitioner, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":      "UpdatePractitioner",
+		"practitionerId": id,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	if resource.ID != "" && resource.ID != id {
+		s.logger.WithFields(logFields).WithField("bodyId", resource.ID).Warn("id do recurso difere da URL")
+		return nil, models.NewAppError("INVALID_INPUT", "id do recurso difere do id da URL", http.StatusBadRequest)
+	}
+
+	current, err := s.findPractitioner(ctx, objectID, logFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("Practitioner", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	return s.replacePractitioner(ctx, logFields, startTime, current, resource, http.MethodPut)
+}
+
+// PatchPractitioner aplica um JSON Patch ou FHIRPath Patch ao practitioner
+// armazenado e grava o resultado como uma nova versão, validado como em
+// UpdatePractitioner.
+func (s *PractitionerService) PatchPractitioner(ctx context.Context, id string, patch Patch, expected int64) (*fhir.Practitioner, error) {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":      "PatchPractitioner",
+		"practitionerId": id,
+		"contentType":    patch.ContentType,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return nil, models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	engine, err := patch.parse()
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patch inválido")
+		return nil, err
+	}
+
+	current, err := s.findPractitioner(ctx, objectID, logFields)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion("Practitioner", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return nil, err
+	}
+
+	var resource fhir.Practitioner
+	if err := applyPatch(engine, toFhirPractitioner(*current), &resource); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("patch não aplicável ao practitioner")
+		return nil, err
+	}
+
+	return s.replacePractitioner(ctx, logFields, startTime, current, &resource, http.MethodPatch)
+}
+
+// replacePractitioner valida o recurso e o grava como a versão seguinte de
+// current, desde que o documento não tenha mudado desde a leitura.
+func (s *PractitionerService) replacePractitioner(ctx context.Context, logFields logrus.Fields, startTime time.Time, current *models.Practitioner, resource *fhir.Practitioner, method string) (*fhir.Practitioner, error) {
+	practitioner, err := s.fromResource(resource)
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("practitioner inválido")
+		return nil, err
+	}
+
+	objectID := current.ID
+	if err := s.versions.archiveLegacy(ctx, "Practitioner", objectID, current.Meta, current); err != nil {
+		return nil, err
+	}
+
+	practitioner.ID = objectID
+	practitioner.Meta = nextVersion(current.Meta)
+
+	collection := s.db.Collection("practitioners")
+	err = s.versions.write(ctx, "Practitioner", objectID, practitioner.Meta, method, practitioner, func() (bool, error) {
+		result, err := collection.ReplaceOne(ctx, versionFilter(objectID, current.Meta), practitioner)
+		return err == nil && result.MatchedCount > 0, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao atualizar practitioner")
+		return nil, err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	logFields["versionId"] = practitioner.Meta.VersionID
+	s.logger.WithFields(logFields).Info("practitioner atualizado com sucesso")
+
+	return toFhirPractitioner(practitioner), nil
+}
+
+func (s *PractitionerService) DeletePractitioner(ctx context.Context, id string, expected int64) error {
+	startTime := time.Now()
+	logFields := logrus.Fields{
+		"operation":      "DeletePractitioner",
+		"practitionerId": id,
+	}
+
+	objectID, errVal := primitive.ObjectIDFromHex(id)
+	if errVal != nil {
+		return models.NewAppError("INVALID_INPUT", "ID Inválido", http.StatusBadRequest)
+	}
+
+	current, err := s.findPractitioner(ctx, objectID, logFields)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion("Practitioner", objectID, current.Meta, expected); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("versão informada em If-Match desatualizada")
+		return err
+	}
+	if err := ensureNotReferenced(ctx, s.db, s.logger, "practitionerId", "Practitioner", objectID); err != nil {
+		s.logger.WithFields(logFields).WithError(err).Warn("practitioner ainda referenciado por encounters")
+		return err
+	}
+	if err := s.versions.archiveLegacy(ctx, "Practitioner", objectID, current.Meta, current); err != nil {
+		return err
+	}
+
+	collection := s.db.Collection("practitioners")
+	err = s.versions.write(ctx, "Practitioner", objectID, nextVersion(current.Meta), http.MethodDelete, nil, func() (bool, error) {
+		result, err := collection.DeleteOne(ctx, versionFilter(objectID, current.Meta))
+		return err == nil && result.DeletedCount > 0, err
+	})
+	if err != nil {
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao remover practitioner")
+		return err
+	}
+
+	logFields["duration"] = time.Since(startTime).String()
+	s.logger.WithFields(logFields).Info("practitioner removido com sucesso")
+
+	return nil
+}
+
+// ConditionalDeletePractitioner remove o practitioner encontrado pelos critérios.
+func (s *PractitionerService) ConditionalDeletePractitioner(ctx context.Context, criteria url.Values, expected int64) error {
+	return conditionalDelete(ctx, "Practitioner", criteria, s.SearchPractitioners, func(id string) error {
+		return s.DeletePractitioner(ctx, id, expected)
+	})
+}
+
+// findPractitioner carrega o documento persistido, usado como base das atualizações.
+func (s *PractitionerService) findPractitioner(ctx context.Context, id primitive.ObjectID, logFields logrus.Fields) (*models.Practitioner, error) {
+	var practitioner models.Practitioner
+	err := s.db.Collection("practitioners").FindOne(ctx, bson.M{"_id": id}).Decode(&practitioner)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			s.logger.WithFields(logFields).Warn("nenhum practitioner encontrado para atualização")
+			return nil, models.NewAppError("NOT_FOUND", "practitioner não encontrado", http.StatusNotFound)
+		}
+
+		s.logger.WithFields(logFields).WithError(err).Error("falha ao buscar practitioner no MongoDB")
+		return nil, models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+	return &practitioner, nil
+}
+
+// fromResource valida o recurso recebido em create/update e o converte no
+// documento persistido.
+func (s *PractitionerService) fromResource(resource *fhir.Practitioner) (models.Practitioner, error) {
+	if resource.ResourceType != "Practitioner" {
+		return models.Practitioner{}, models.NewAppError("INVALID_INPUT", "resourceType deve ser Practitioner", http.StatusBadRequest)
+	}
+
+	if resource.Gender != "" && !s.validGender[resource.Gender] {
+		return models.Practitioner{}, models.NewAppError("INVALID_FIELD", "gender inválido: "+resource.Gender, http.StatusBadRequest)
+	}
+
+	if resource.BirthDate != "" && !fhir.ValidDate(resource.BirthDate) {
+		return models.Practitioner{}, models.NewAppError("INVALID_FIELD", "birthDate inválido: "+resource.BirthDate, http.StatusBadRequest)
+	}
+
+	if err := validateName(resource.Name); err != nil {
+		return models.Practitioner{}, err
+	}
+
+	for i, identifier := range resource.Identifier {
+		if identifier.Value == "" {
+			return models.Practitioner{}, models.NewAppError("INVALID_FIELD", fmt.Sprintf("identifier[%d].value é obrigatório", i), http.StatusBadRequest)
+		}
+	}
+
+	for i, telecom := range resource.Telecom {
+		if telecom.Value == "" || (telecom.System != "" && !s.validTelecom[telecom.System]) {
+			return models.Practitioner{}, models.NewAppError("INVALID_FIELD", fmt.Sprintf("telecom[%d] inválido", i), http.StatusBadRequest)
+		}
+	}
 
-	for _, field := range fields {
-		switch field {
-		case "fhirId":
-			response.FhirId = &practitioner.FhirId
-		case "givenName":
-			response.GivenName = &practitioner.GivenName
-		case "familyName":
-			response.FamilyName = &practitioner.FamilyName
+	for i, qualification := range resource.Qualification {
+		if qualification.Code == nil || (len(qualification.Code.Coding) == 0 && qualification.Code.Text == "") {
+			return models.Practitioner{}, models.NewAppError("INVALID_FIELD", fmt.Sprintf("qualification[%d].code é obrigatório", i), http.StatusBadRequest)
 		}
 	}
 
-	return response
+	return fromFhirPractitioner(resource)
 }
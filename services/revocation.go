@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"fhir-api/models"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const revokedTokensCollection = "revoked_tokens"
+
+// RevocationList guarda os jti revogados na coleção revoked_tokens e os mantém
+// em memória, para que a verificação de cada requisição não vá ao MongoDB.
+// Revogações feitas por outra instância aparecem no próximo Refresh.
+type RevocationList struct {
+	db     *mongo.Database
+	logger *logrus.Logger
+	mu     sync.RWMutex
+	cache  map[string]time.Time
+}
+
+func NewRevocationList(db *mongo.Database, logger *logrus.Logger) *RevocationList {
+	return &RevocationList{
+		db:     db,
+		logger: logger,
+		cache:  map[string]time.Time{},
+	}
+}
+
+// EnsureIndexes cria o índice único de jti e o TTL que descarta as revogações
+// de tokens já expirados.
+func (l *RevocationList) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "jti", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	names, err := l.db.Collection(revokedTokensCollection).Indexes().CreateMany(ctx, indexes)
+	if err != nil {
+		l.logger.WithError(err).Error("falha ao criar índices de revoked_tokens")
+		return err
+	}
+
+	l.logger.WithField("indexes", names).Info("índices de revoked_tokens verificados")
+	return nil
+}
+
+// Revoke grava a revogação e a aplica imediatamente nesta instância. expiresAt
+// é o último instante em que o token ainda seria aceito, exp somado à
+// tolerância de relógio; antes disso a revogação não pode sumir da coleção nem
+// do cache. Revogar de novo o mesmo jti não é erro.
+func (l *RevocationList) Revoke(ctx context.Context, jti, clientID string, expiresAt time.Time) error {
+	logFields := logrus.Fields{
+		"operation": "Revoke",
+		"jti":       jti,
+		"client_id": clientID,
+	}
+
+	_, err := l.db.Collection(revokedTokensCollection).UpdateOne(ctx,
+		bson.M{"jti": jti},
+		bson.M{"$setOnInsert": models.RevokedToken{
+			JTI:       jti,
+			ClientID:  clientID,
+			ExpiresAt: expiresAt.UTC(),
+			RevokedAt: time.Now().UTC(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		l.logger.WithFields(logFields).WithError(err).Error("falha ao gravar revogação no MongoDB")
+		return models.NewAppError("DATABASE_ERROR", "erro ao acessar o banco de dados", http.StatusInternalServerError).Wrap(err)
+	}
+
+	l.remember(jti, expiresAt)
+
+	l.logger.WithFields(logFields).Info("token revogado")
+	return nil
+}
+
+// IsRevoked consulta apenas o cache em memória.
+func (l *RevocationList) IsRevoked(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.cache[jti]
+	return ok
+}
+
+// Refresh recarrega o cache com as revogações de tokens ainda não expirados.
+func (l *RevocationList) Refresh(ctx context.Context) error {
+	cursor, err := l.db.Collection(revokedTokensCollection).Find(ctx, bson.M{"expiresAt": bson.M{"$gt": time.Now().UTC()}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	cache := map[string]time.Time{}
+	for cursor.Next(ctx) {
+		var revoked models.RevokedToken
+		if err := cursor.Decode(&revoked); err != nil {
+			return err
+		}
+		cache[revoked.JTI] = revoked.ExpiresAt
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	l.merge(cache, time.Now())
+	return nil
+}
+
+func (l *RevocationList) remember(jti string, expiresAt time.Time) {
+	l.mu.Lock()
+	l.cache[jti] = expiresAt
+	l.mu.Unlock()
+}
+
+// merge troca o cache pelas revogações lidas do MongoDB. Revogações locais
+// gravadas durante a consulta continuam valendo enquanto não passarem de
+// expiresAt.
+func (l *RevocationList) merge(loaded map[string]time.Time, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for jti, expiresAt := range l.cache {
+		if _, ok := loaded[jti]; !ok && expiresAt.After(now) {
+			loaded[jti] = expiresAt
+		}
+	}
+	l.cache = loaded
+}
+
+// Watch chama Refresh a cada interval até ctx ser cancelado.
+func (l *RevocationList) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Refresh(ctx); err != nil && ctx.Err() == nil {
+				l.logger.WithError(err).Warn("falha ao atualizar a lista de tokens revogados")
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"fhir-api/models"
+)
+
+// permissionOrder é a ordem canônica das permissões nos scopes SMART v2
+// (create, read, update, delete, search).
+const permissionOrder = "cruds"
+
+// interactionPermissions associa cada interação FHIR à permissão SMART v2 que
+// ela exige.
+var interactionPermissions = map[string]byte{
+	"create":           'c',
+	"read":             'r',
+	"vread":            'r',
+	"history-instance": 'r',
+	"update":           'u',
+	"patch":            'u',
+	"delete":           'd',
+	"search-type":      's',
+	"history-type":     's',
+}
+
+// v1Permissions traduz os sufixos SMART v1 para as permissões v2 equivalentes.
+var v1Permissions = map[string]string{
+	"read":  "rs",
+	"write": "cud",
+	"*":     "cruds",
+}
+
+// Scope é um scope de recurso SMART, como system/Patient.rs ou user/*.cruds.
+type Scope struct {
+	Context      string
+	ResourceType string
+	Permissions  string
+}
+
+func (s Scope) String() string {
+	return s.Context + "/" + s.ResourceType + "." + s.Permissions
+}
+
+// ParseScope interpreta um scope de recurso SMART v2, aceitando também os
+// sufixos v1 (.read, .write, .*). Só os contextos system e user são aceitos:
+// scopes patient/ exigiriam restringir o acesso ao compartimento do paciente.
+func ParseScope(value string) (Scope, error) {
+	level, rest, ok := strings.Cut(value, "/")
+	if !ok || (level != "system" && level != "user") {
+		return Scope{}, fmt.Errorf("scope não suportado: %s", value)
+	}
+	resourceType, permissions, ok := strings.Cut(rest, ".")
+	if !ok || resourceType == "" {
+		return Scope{}, fmt.Errorf("scope inválido: %s", value)
+	}
+	if v1, ok := v1Permissions[permissions]; ok {
+		permissions = v1
+	}
+
+	// As permissões v2 precisam vir na ordem c, r, u, d, s, sem repetição.
+	last := -1
+	for i := 0; i < len(permissions); i++ {
+		pos := strings.IndexByte(permissionOrder, permissions[i])
+		if pos <= last {
+			return Scope{}, fmt.Errorf("permissões inválidas no scope: %s", value)
+		}
+		last = pos
+	}
+	if permissions == "" {
+		return Scope{}, fmt.Errorf("scope sem permissões: %s", value)
+	}
+
+	return Scope{Context: level, ResourceType: resourceType, Permissions: permissions}, nil
+}
+
+// ScopeSet é o conjunto de scopes de recurso concedidos a um token. Scopes que
+// não são de recurso (openid, launch...) são ignorados.
+type ScopeSet []Scope
+
+// ParseScopes lê o claim scope de um token, separado por espaços.
+func ParseScopes(value string) ScopeSet {
+	var scopes ScopeSet
+	for _, item := range strings.Fields(value) {
+		if scope, err := ParseScope(item); err == nil {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// permits indica se algum scope do conjunto concede a permissão sobre o tipo.
+func (s ScopeSet) permits(resourceType string, permission byte) bool {
+	for _, scope := range s {
+		if (scope.ResourceType == "*" || scope.ResourceType == resourceType) && strings.IndexByte(scope.Permissions, permission) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows indica se o conjunto concede a interação sobre o tipo de recurso.
+// Interações sem permissão associada são negadas.
+func (s ScopeSet) Allows(resourceType, interaction string) bool {
+	permission, ok := interactionPermissions[interaction]
+	return ok && s.permits(resourceType, permission)
+}
+
+// Covers indica se scope não concede nada além do que o conjunto já concede;
+// usado para restringir os scopes pedidos em /auth/token aos do cliente. system
+// e user dão o mesmo acesso nesta API, por isso o contexto não é comparado.
+func (s ScopeSet) Covers(scope Scope) bool {
+	for i := 0; i < len(scope.Permissions); i++ {
+		if !s.permits(scope.ResourceType, scope.Permissions[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequiredScope descreve o scope mínimo exigido por uma interação, usado nas
+// mensagens de erro e no WWW-Authenticate.
+func RequiredScope(resourceType, interaction string) string {
+	permission, ok := interactionPermissions[interaction]
+	if !ok {
+		return ""
+	}
+	return "system/" + resourceType + "." + string(permission)
+}
+
+// ResourceScopes lista os scopes system/ e user/ que concedem, sobre o tipo,
+// todas as interações informadas; é o que a smart-configuration anuncia.
+func ResourceScopes(resourceType string, interactions []string) []string {
+	granted := map[byte]bool{}
+	for _, interaction := range interactions {
+		if permission, ok := interactionPermissions[interaction]; ok {
+			granted[permission] = true
+		}
+	}
+
+	var permissions []byte
+	for i := 0; i < len(permissionOrder); i++ {
+		if granted[permissionOrder[i]] {
+			permissions = append(permissions, permissionOrder[i])
+		}
+	}
+	if len(permissions) == 0 {
+		return nil
+	}
+	return []string{
+		"system/" + resourceType + "." + string(permissions),
+		"user/" + resourceType + "." + string(permissions),
+	}
+}
+
+// authorize devolve 403 quando os scopes do contexto não permitem a interação.
+func authorize(ctx context.Context, resourceType, interaction string) error {
+	if ScopesFromContext(ctx).Allows(resourceType, interaction) {
+		return nil
+	}
+	return models.NewAppError("FORBIDDEN", fmt.Sprintf("scope insuficiente para %s em %s: exige %s", interaction, resourceType, RequiredScope(resourceType, interaction)), http.StatusForbidden)
+}
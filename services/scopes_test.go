@@ -0,0 +1,193 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Scope
+	}{
+		{"system/Patient.r", Scope{Context: "system", ResourceType: "Patient", Permissions: "r"}},
+		{"system/*.cruds", Scope{Context: "system", ResourceType: "*", Permissions: "cruds"}},
+		{"user/Encounter.rs", Scope{Context: "user", ResourceType: "Encounter", Permissions: "rs"}},
+		{"system/Patient.cud", Scope{Context: "system", ResourceType: "Patient", Permissions: "cud"}},
+		{"system/Patient.read", Scope{Context: "system", ResourceType: "Patient", Permissions: "rs"}},
+		{"system/Patient.write", Scope{Context: "system", ResourceType: "Patient", Permissions: "cud"}},
+		{"user/*.*", Scope{Context: "user", ResourceType: "*", Permissions: "cruds"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseScope(tt.value)
+		if err != nil {
+			t.Errorf("ParseScope(%q): %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseScope(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseScopeRejects(t *testing.T) {
+	for _, value := range []string{
+		"patient/Patient.r",
+		"patient/*.read",
+		"openid",
+		"launch/patient",
+		"system/Patient",
+		"system/.r",
+		"system/Patient.",
+		"system/Patient.rc",
+		"system/Patient.rr",
+		"system/Patient.x",
+		"system/Patient.readwrite",
+		"System/Patient.r",
+		"",
+	} {
+		if scope, err := ParseScope(value); err == nil {
+			t.Errorf("ParseScope(%q) = %+v, want error", value, scope)
+		}
+	}
+}
+
+func TestParseScopesIgnoresNonResourceScopes(t *testing.T) {
+	got := ParseScopes("openid system/Patient.rs  patient/Encounter.r fhirUser user/Practitioner.read")
+	want := ScopeSet{
+		{Context: "system", ResourceType: "Patient", Permissions: "rs"},
+		{Context: "user", ResourceType: "Practitioner", Permissions: "rs"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseScopes = %+v, want %+v", got, want)
+	}
+}
+
+func TestScopeSetAllows(t *testing.T) {
+	tests := []struct {
+		scopes       string
+		resourceType string
+		interaction  string
+		want         bool
+	}{
+		{"system/*.cruds", "Patient", "read", true},
+		{"system/*.cruds", "Encounter", "delete", true},
+		{"system/*.cruds", "Practitioner", "search-type", true},
+		{"system/Patient.r", "Patient", "read", true},
+		{"system/Patient.r", "Patient", "vread", true},
+		{"system/Patient.r", "Patient", "history-instance", true},
+		{"system/Patient.r", "Patient", "search-type", false},
+		{"system/Patient.r", "Patient", "history-type", false},
+		{"system/Patient.r", "Encounter", "read", false},
+		{"system/Patient.r", "Patient", "update", false},
+		{"system/Patient.u", "Patient", "patch", true},
+		{"system/Patient.c", "Patient", "create", true},
+		{"system/Patient.read", "Patient", "search-type", true},
+		{"system/Patient.read", "Patient", "create", false},
+		{"system/Patient.write", "Patient", "delete", true},
+		{"system/Patient.write", "Patient", "read", false},
+		{"user/*.*", "Encounter", "update", true},
+		{"system/*.cruds", "Patient", "operation", false},
+		{"patient/*.cruds", "Patient", "read", false},
+		{"", "Patient", "read", false},
+	}
+	for _, tt := range tests {
+		if got := ParseScopes(tt.scopes).Allows(tt.resourceType, tt.interaction); got != tt.want {
+			t.Errorf("ParseScopes(%q).Allows(%s, %s) = %v, want %v", tt.scopes, tt.resourceType, tt.interaction, got, tt.want)
+		}
+	}
+}
+
+func TestScopeSetCovers(t *testing.T) {
+	tests := []struct {
+		granted   string
+		requested string
+		want      bool
+	}{
+		{"system/*.cruds", "system/Patient.r", true},
+		{"system/*.cruds", "user/Encounter.cud", true},
+		{"system/*.cruds", "system/*.cruds", true},
+		{"system/Patient.r", "system/*.cruds", false},
+		{"system/Patient.r", "system/Patient.rs", false},
+		{"system/Patient.rs", "system/Patient.r", true},
+		{"system/Patient.rs system/Patient.cud", "system/Patient.cruds", true},
+		{"system/Patient.read", "system/Patient.s", true},
+		{"system/Patient.cruds", "system/Encounter.r", false},
+		{"system/Patient.r system/Encounter.r", "system/*.r", false},
+	}
+	for _, tt := range tests {
+		requested, err := ParseScope(tt.requested)
+		if err != nil {
+			t.Fatalf("ParseScope(%q): %v", tt.requested, err)
+		}
+		if got := ParseScopes(tt.granted).Covers(requested); got != tt.want {
+			t.Errorf("ParseScopes(%q).Covers(%s) = %v, want %v", tt.granted, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestInteractionPermissions(t *testing.T) {
+	want := map[string]byte{
+		"create":           'c',
+		"read":             'r',
+		"vread":            'r',
+		"history-instance": 'r',
+		"update":           'u',
+		"patch":            'u',
+		"delete":           'd',
+		"search-type":      's',
+		"history-type":     's',
+	}
+	if !reflect.DeepEqual(interactionPermissions, want) {
+		t.Errorf("interactionPermissions = %v, want %v", interactionPermissions, want)
+	}
+	for interaction, permission := range want {
+		if got := RequiredScope("Patient", interaction); got != "system/Patient."+string(permission) {
+			t.Errorf("RequiredScope(Patient, %s) = %q", interaction, got)
+		}
+	}
+	if got := RequiredScope("Patient", "operation"); got != "" {
+		t.Errorf("RequiredScope for an unmapped interaction = %q, want empty", got)
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	allowed := []string{"system/Patient.rs", "system/Encounter.cruds"}
+
+	tests := []struct {
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{requested: "", want: "system/Patient.rs system/Encounter.cruds"},
+		{requested: "system/Patient.r", want: "system/Patient.r"},
+		{requested: "system/Patient.read system/Encounter.c", want: "system/Patient.read system/Encounter.c"},
+		{requested: "system/Patient.u", wantErr: true},
+		{requested: "system/*.r", wantErr: true},
+		{requested: "patient/Patient.r", wantErr: true},
+		{requested: "openid", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := grantScopes(allowed, tt.requested)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("grantScopes(%q) = %q, want invalid_scope", tt.requested, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("grantScopes(%q) = %q, %v, want %q", tt.requested, got, err, tt.want)
+		}
+	}
+}
+
+func TestResourceScopes(t *testing.T) {
+	got := ResourceScopes("Patient", []string{"read", "search-type", "create", "vread", "operation"})
+	want := []string{"system/Patient.crs", "user/Patient.crs"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResourceScopes = %v, want %v", got, want)
+	}
+	if got := ResourceScopes("Patient", []string{"operation"}); got != nil {
+		t.Errorf("ResourceScopes without mapped interactions = %v, want nil", got)
+	}
+}
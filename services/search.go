@@ -0,0 +1,352 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SearchResult é o resultado de uma busca, convertido em Bundle searchset pelos
+// controllers. Next e Previous são os cursores opacos das páginas vizinhas.
+// Included traz os recursos de _include/_revinclude (search.mode=include).
+type SearchResult struct {
+	Total     int64
+	Resources []fhir.Resource
+	Included  []fhir.Resource
+	Next      string
+	Previous  string
+}
+
+// searchParam descreve um parâmetro de busca FHIR e como ele vira filtro no MongoDB.
+// filter recebe um único valor (já separado por vírgula) e o modificador (:exact, :contains...).
+type searchParam struct {
+	paramType     string
+	documentation string
+	filter        func(value, modifier string) (bson.M, error)
+}
+
+type searchParams map[string]searchParam
+
+// capability lista os parâmetros em ordem alfabética para o CapabilityStatement.
+func (p searchParams) capability() []fhir.CapabilitySearchParam {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]fhir.CapabilitySearchParam, 0, len(names))
+	for _, name := range names {
+		result = append(result, fhir.CapabilitySearchParam{
+			Name:          name,
+			Type:          p[name].paramType,
+			Documentation: p[name].documentation,
+		})
+	}
+	return result
+}
+
+// filter converte a query string em filtro MongoDB. Parâmetros repetidos são
+// combinados com AND e valores separados por vírgula com OR. Parâmetros de
+// resultado (prefixo "_") são tratados fora daqui e ignorados.
+func (p searchParams) filter(query url.Values) (bson.M, error) {
+	var clauses []bson.M
+
+	for key, values := range query {
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+
+		name, modifier, _ := strings.Cut(key, ":")
+		param, ok := p[name]
+		if !ok {
+			return nil, models.NewAppError("INVALID_PARAM", "parâmetro de busca não suportado: "+key, http.StatusBadRequest)
+		}
+
+		for _, value := range values {
+			var alternatives []bson.M
+			for _, v := range strings.Split(value, ",") {
+				if v == "" {
+					continue
+				}
+				clause, err := param.filter(v, modifier)
+				if err != nil {
+					return nil, err
+				}
+				alternatives = append(alternatives, clause)
+			}
+
+			switch len(alternatives) {
+			case 0:
+				return nil, models.NewAppError("INVALID_PARAM", "valor vazio para o parâmetro: "+key, http.StatusBadRequest)
+			case 1:
+				clauses = append(clauses, alternatives[0])
+			default:
+				clauses = append(clauses, bson.M{"$or": alternatives})
+			}
+		}
+	}
+
+	switch len(clauses) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return clauses[0], nil
+	default:
+		return bson.M{"$and": clauses}, nil
+	}
+}
+
+// stringFilter implementa parâmetros do tipo string: por padrão, prefixo sem
+// distinção de maiúsculas; :exact compara igualdade e :contains busca substring.
+func stringFilter(fields ...string) func(value, modifier string) (bson.M, error) {
+	return func(value, modifier string) (bson.M, error) {
+		var condition interface{}
+		switch modifier {
+		case "":
+			condition = bson.M{"$regex": "^" + regexp.QuoteMeta(value), "$options": "i"}
+		case "contains":
+			condition = bson.M{"$regex": regexp.QuoteMeta(value), "$options": "i"}
+		case "exact":
+			condition = value
+		default:
+			return nil, invalidModifier(modifier)
+		}
+
+		return anyField(fields, condition), nil
+	}
+}
+
+// tokenFilter implementa parâmetros do tipo token comparando apenas o código;
+// o sistema, quando informado (system|code), deve ser o esperado.
+func tokenFilter(field, system string) func(value, modifier string) (bson.M, error) {
+	return func(value, modifier string) (bson.M, error) {
+		if modifier != "" {
+			return nil, invalidModifier(modifier)
+		}
+
+		if valueSystem, code, ok := strings.Cut(value, "|"); ok {
+			if valueSystem != "" && valueSystem != system {
+				return matchNothing(field), nil
+			}
+			value = code
+		}
+
+		return bson.M{field: value}, nil
+	}
+}
+
+// identifierFilter implementa o parâmetro identifier: o sistema do Hapi é
+// comparado com fhirId e os demais com o array identifiers do documento.
+func identifierFilter(value, modifier string) (bson.M, error) {
+	if modifier != "" {
+		return nil, invalidModifier(modifier)
+	}
+
+	system, code, hasSystem := strings.Cut(value, "|")
+	if !hasSystem {
+		return bson.M{"$or": []bson.M{
+			{"fhirId": value},
+			{"identifiers.value": value},
+		}}, nil
+	}
+
+	switch system {
+	case fhir.HapiIdentifierSystem:
+		return bson.M{"fhirId": code}, nil
+	case "":
+		return bson.M{"identifiers": bson.M{"$elemMatch": bson.M{"value": code, "system": nil}}}, nil
+	default:
+		return bson.M{"identifiers": bson.M{"$elemMatch": bson.M{"system": system, "value": code}}}, nil
+	}
+}
+
+// referenceFilter implementa parâmetros do tipo reference. Aceita "Tipo/id" ou
+// apenas o id; como documentos antigos podem guardar a referência como texto,
+// compara tanto com o ObjectID quanto com o hex.
+func referenceFilter(field, resourceType string) func(value, modifier string) (bson.M, error) {
+	return func(value, modifier string) (bson.M, error) {
+		if modifier != "" && modifier != resourceType {
+			return nil, invalidModifier(modifier)
+		}
+
+		if refType, id, ok := strings.Cut(value, "/"); ok {
+			if refType != resourceType {
+				return matchNothing(field), nil
+			}
+			value = id
+		}
+
+		objectID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return bson.M{field: value}, nil
+		}
+		return bson.M{field: bson.M{"$in": bson.A{objectID, value}}}, nil
+	}
+}
+
+// periodFilter aplica um parâmetro date a um Period (início e fim), seguindo a
+// semântica de intervalos do FHIR: eq busca sobreposição e um fim ausente indica
+// período em aberto.
+func periodFilter(startField, endField string) func(value, modifier string) (bson.M, error) {
+	return func(value, modifier string) (bson.M, error) {
+		if modifier != "" {
+			return nil, invalidModifier(modifier)
+		}
+
+		prefix, low, high, err := parseDateParam(value)
+		if err != nil {
+			return nil, err
+		}
+
+		endAfter := func(t time.Time) bson.M {
+			return bson.M{"$or": []bson.M{
+				{endField: bson.M{"$gte": t}},
+				{endField: nil},
+			}}
+		}
+
+		switch prefix {
+		case "ne":
+			return bson.M{"$or": []bson.M{
+				{startField: bson.M{"$gte": high}},
+				{endField: bson.M{"$lt": low}},
+			}}, nil
+		case "lt":
+			return bson.M{startField: bson.M{"$lt": low}}, nil
+		case "le":
+			return bson.M{startField: bson.M{"$lt": high}}, nil
+		case "gt":
+			return endAfter(high), nil
+		case "ge":
+			return endAfter(low), nil
+		case "sa":
+			return bson.M{startField: bson.M{"$gte": high}}, nil
+		case "eb":
+			return bson.M{endField: bson.M{"$lt": low}}, nil
+		default:
+			return bson.M{"$and": []bson.M{
+				{startField: bson.M{"$lt": high}},
+				endAfter(low),
+			}}, nil
+		}
+	}
+}
+
+// dateFilter implementa parâmetros do tipo date com os prefixos FHIR
+// (eq, ne, lt, gt, le, ge, sa, eb). O valor define um intervalo conforme a
+// precisão informada (ano, mês, dia ou instante). asString indica que o campo é
+// persistido como data FHIR em texto (YYYY-MM-DD) em vez de BSON date; nesse
+// caso valores com hora são recusados, já que o intervalo deles cabe dentro de
+// um único dia e nenhuma data armazenada cairia nele.
+func dateFilter(field string, asString bool) func(value, modifier string) (bson.M, error) {
+	return func(value, modifier string) (bson.M, error) {
+		if modifier != "" {
+			return nil, invalidModifier(modifier)
+		}
+
+		prefix, low, high, err := parseDateParam(value)
+		if err != nil {
+			return nil, err
+		}
+		if asString && high.Sub(low) < 24*time.Hour {
+			return nil, models.NewAppError("INVALID_PARAM", "precisão maior que um dia não é suportada para este parâmetro: "+value, http.StatusBadRequest)
+		}
+
+		return dateRangeFilter(field, prefix, stored(low, asString), stored(high, asString)), nil
+	}
+}
+
+// dateRangeFilter aplica o prefixo ao intervalo [low, high) do valor pesquisado.
+func dateRangeFilter(field, prefix string, low, high interface{}) bson.M {
+	switch prefix {
+	case "ne":
+		return bson.M{"$or": []bson.M{
+			{field: bson.M{"$lt": low}},
+			{field: bson.M{"$gte": high}},
+		}}
+	case "lt", "eb":
+		return bson.M{field: bson.M{"$lt": low}}
+	case "le":
+		return bson.M{field: bson.M{"$lt": high}}
+	case "gt", "sa":
+		return bson.M{field: bson.M{"$gte": high}}
+	case "ge":
+		return bson.M{field: bson.M{"$gte": low}}
+	default:
+		return bson.M{field: bson.M{"$gte": low, "$lt": high}}
+	}
+}
+
+var datePrefixes = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "gt": true, "le": true,
+	"ge": true, "sa": true, "eb": true,
+}
+
+// dateLayouts associa cada precisão aceita ao incremento que fecha o intervalo.
+var dateLayouts = []struct {
+	layout string
+	next   func(time.Time) time.Time
+}{
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02T15:04:05Z07:00", func(t time.Time) time.Time { return t.Add(time.Second) }},
+	{time.RFC3339Nano, func(t time.Time) time.Time { return t.Add(time.Millisecond) }},
+}
+
+// parseDateParam separa o prefixo e devolve o intervalo [low, high) representado pelo valor.
+func parseDateParam(value string) (string, time.Time, time.Time, error) {
+	prefix := "eq"
+	if len(value) > 2 && datePrefixes[value[:2]] {
+		prefix, value = value[:2], value[2:]
+	}
+	if strings.HasPrefix(value, "ap") {
+		return "", time.Time{}, time.Time{}, models.NewAppError("INVALID_PARAM", "prefixo ap não suportado: "+value, http.StatusBadRequest)
+	}
+
+	for _, candidate := range dateLayouts {
+		if t, err := time.Parse(candidate.layout, value); err == nil {
+			return prefix, t.UTC(), candidate.next(t).UTC(), nil
+		}
+	}
+
+	return "", time.Time{}, time.Time{}, models.NewAppError("INVALID_PARAM", "data inválida: "+value, http.StatusBadRequest)
+}
+
+func stored(t time.Time, asString bool) interface{} {
+	if asString {
+		return t.Format("2006-01-02")
+	}
+	return t
+}
+
+func anyField(fields []string, condition interface{}) bson.M {
+	if len(fields) == 1 {
+		return bson.M{fields[0]: condition}
+	}
+
+	alternatives := make([]bson.M, 0, len(fields))
+	for _, field := range fields {
+		alternatives = append(alternatives, bson.M{field: condition})
+	}
+	return bson.M{"$or": alternatives}
+}
+
+func matchNothing(field string) bson.M {
+	return bson.M{field: bson.M{"$in": bson.A{}}}
+}
+
+func invalidModifier(modifier string) error {
+	return models.NewAppError("INVALID_PARAM", "modificador não suportado: "+modifier, http.StatusBadRequest)
+}
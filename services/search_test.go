@@ -0,0 +1,76 @@
+package services
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseDateParam(t *testing.T) {
+	day := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value      string
+		wantPrefix string
+		wantLow    time.Time
+		wantHigh   time.Time
+	}{
+		{"2000", "eq", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"ge2000-01", "ge", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"lt2000-01-02", "lt", day, day.AddDate(0, 0, 1)},
+		{"2000-01-02T10:30", "eq", day.Add(10*time.Hour + 30*time.Minute), day.Add(10*time.Hour + 31*time.Minute)},
+	}
+	for _, tt := range tests {
+		prefix, low, high, err := parseDateParam(tt.value)
+		if err != nil {
+			t.Errorf("parseDateParam(%q): %v", tt.value, err)
+			continue
+		}
+		if prefix != tt.wantPrefix || !low.Equal(tt.wantLow) || !high.Equal(tt.wantHigh) {
+			t.Errorf("parseDateParam(%q) = %s [%v, %v), want %s [%v, %v)", tt.value, prefix, low, high, tt.wantPrefix, tt.wantLow, tt.wantHigh)
+		}
+	}
+
+	for _, value := range []string{"ap2000-01-02", "2000-13", "yesterday"} {
+		_, _, _, err := parseDateParam(value)
+		assertAppError(t, err, "INVALID_PARAM", http.StatusBadRequest)
+	}
+}
+
+// TestDateFilterAsString cobre datas persistidas como texto (birthDate): o
+// intervalo é comparado por dia e valores com hora são recusados.
+func TestDateFilterAsString(t *testing.T) {
+	filter := dateFilter("birthDate", true)
+
+	tests := []struct {
+		value string
+		want  bson.M
+	}{
+		{"2000-01-02", bson.M{"birthDate": bson.M{"$gte": "2000-01-02", "$lt": "2000-01-03"}}},
+		{"2000", bson.M{"birthDate": bson.M{"$gte": "2000-01-01", "$lt": "2001-01-01"}}},
+		{"lt2000-01-02", bson.M{"birthDate": bson.M{"$lt": "2000-01-02"}}},
+		{"gt2000-01", bson.M{"birthDate": bson.M{"$gte": "2000-02-01"}}},
+	}
+	for _, tt := range tests {
+		got, err := filter(tt.value, "")
+		if err != nil {
+			t.Errorf("dateFilter(%q): %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dateFilter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"2000-01-02T10:30", "ge2000-01-02T10:30:00Z", "2000-01-02T10:30:00.5Z"} {
+		_, err := filter(value, "")
+		assertAppError(t, err, "INVALID_PARAM", http.StatusBadRequest)
+	}
+
+	if _, err := dateFilter("period.start", false)("2000-01-02T10:30", ""); err != nil {
+		t.Errorf("dateTime rejected for a date field: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"fhir-api/models"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// TokenVerifier é o único ponto que decide se um access token é aceito. É usado
+// pelo AuthMiddleware e por /auth/revoke e /auth/introspect.
+type TokenVerifier struct {
+	keys        *KeyStore
+	clientCode  string
+	settings    TokenSettings
+	revocations *RevocationList
+}
+
+func NewTokenVerifier(keys *KeyStore, clientCode string, settings TokenSettings, revocations *RevocationList) *TokenVerifier {
+	return &TokenVerifier{
+		keys:        keys,
+		clientCode:  clientCode,
+		settings:    settings,
+		revocations: revocations,
+	}
+}
+
+// Verify valida assinatura e alg (pelo kid, via KeyStore), iss, aud, exp, nbf e
+// iat com a tolerância de relógio, o jti contra a lista de revogação e o
+// client_code da instância. Falhas do token são UNAUTHORIZED; um token válido
+// de outra instância é FORBIDDEN.
+func (v *TokenVerifier) Verify(tokenString string) (*models.Principal, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(tokenString, v.keys.Keyfunc)
+	if err != nil {
+		return nil, models.NewAppError("UNAUTHORIZED", "Invalid token", http.StatusUnauthorized)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	now := time.Now()
+	switch {
+	case !claims.VerifyIssuer(v.settings.Issuer, true):
+		return nil, models.NewAppError("UNAUTHORIZED", "Invalid token issuer", http.StatusUnauthorized)
+	case !claims.VerifyAudience(v.settings.Audience, true):
+		return nil, models.NewAppError("UNAUTHORIZED", "Invalid token audience", http.StatusUnauthorized)
+	case !claims.VerifyExpiresAt(now.Add(-v.settings.ClockSkew).Unix(), true):
+		return nil, models.NewAppError("UNAUTHORIZED", "Token expired", http.StatusUnauthorized)
+	case !claims.VerifyNotBefore(now.Add(v.settings.ClockSkew).Unix(), false),
+		!claims.VerifyIssuedAt(now.Add(v.settings.ClockSkew).Unix(), false):
+		return nil, models.NewAppError("UNAUTHORIZED", "Token not yet valid", http.StatusUnauthorized)
+	}
+
+	principal := &models.Principal{
+		Issuer:    v.settings.Issuer,
+		Audience:  v.settings.Audience,
+		IssuedAt:  timeClaim(claims, "iat"),
+		NotBefore: timeClaim(claims, "nbf"),
+		ExpiresAt: timeClaim(claims, "exp"),
+	}
+	principal.TokenID, _ = claims["jti"].(string)
+	principal.Subject, _ = claims["sub"].(string)
+	principal.ClientID, _ = claims["client_id"].(string)
+	principal.Scope, _ = claims["scope"].(string)
+
+	if principal.TokenID == "" {
+		return nil, models.NewAppError("UNAUTHORIZED", "Token without jti", http.StatusUnauthorized)
+	}
+	if v.revocations.IsRevoked(principal.TokenID) {
+		return nil, models.NewAppError("UNAUTHORIZED", "Token revoked", http.StatusUnauthorized)
+	}
+	if code, _ := claims["client_code"].(string); code != v.clientCode {
+		return nil, models.NewAppError("FORBIDDEN", "Access denied", http.StatusForbidden)
+	}
+	return principal, nil
+}
+
+// acceptedUntil é o último instante em que Verify ainda aceita o token: exp
+// mais a tolerância de relógio. Uma revogação precisa valer até lá.
+func (v *TokenVerifier) acceptedUntil(principal *models.Principal) time.Time {
+	return principal.ExpiresAt.Add(v.settings.ClockSkew)
+}
+
+func timeClaim(claims jwt.MapClaims, name string) time.Time {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(value), 0)
+}
+
+type principalKey struct{}
+
+// WithPrincipal guarda o principal da requisição no contexto repassado aos
+// serviços, para que interações decididas só no serviço, como as entradas de um
+// Bundle, também sejam autorizadas.
+func WithPrincipal(ctx context.Context, principal *models.Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext devolve o principal guardado por WithPrincipal, ou nil.
+func PrincipalFromContext(ctx context.Context) *models.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*models.Principal)
+	return principal
+}
+
+// ScopesFromContext devolve os scopes do principal do contexto; sem principal
+// nada é permitido.
+func ScopesFromContext(ctx context.Context) ScopeSet {
+	principal := PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil
+	}
+	return ParseScopes(principal.Scope)
+}
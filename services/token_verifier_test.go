@@ -0,0 +1,63 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/sirupsen/logrus"
+)
+
+// TestRevocationOutlastsClockSkew revoga um token já vencido mas ainda aceito
+// pela tolerância de relógio: a revogação precisa continuar valendo depois de
+// um Refresh feito após o exp.
+func TestRevocationOutlastsClockSkew(t *testing.T) {
+	keys, err := LoadKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocations := NewRevocationList(nil, logrus.New())
+	verifier := NewTokenVerifier(keys, "hca", testSettings, revocations)
+
+	now := time.Now()
+	exp := now.Add(-testSettings.ClockSkew / 2)
+	token, err := keys.Sign(jwt.MapClaims{
+		"iss":         testSettings.Issuer,
+		"aud":         testSettings.Audience,
+		"sub":         "backend",
+		"client_id":   "backend",
+		"client_code": "hca",
+		"scope":       "system/Patient.rs",
+		"jti":         "token-1",
+		"iat":         now.Add(-time.Hour).Unix(),
+		"exp":         exp.Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := verifier.Verify(token)
+	if err != nil {
+		t.Fatalf("token inside the clock skew rejected: %v", err)
+	}
+
+	until := verifier.acceptedUntil(principal)
+	if want := time.Unix(exp.Unix(), 0).Add(testSettings.ClockSkew); !until.Equal(want) {
+		t.Fatalf("acceptedUntil = %v, want exp + skew = %v", until, want)
+	}
+
+	revocations.remember(principal.TokenID, until)
+	revocations.merge(map[string]time.Time{}, now)
+
+	_, err = verifier.Verify(token)
+	assertAppError(t, err, "UNAUTHORIZED", http.StatusUnauthorized)
+	if !revocations.IsRevoked(principal.TokenID) {
+		t.Error("revocation dropped before the token stopped being accepted")
+	}
+
+	revocations.merge(map[string]time.Time{}, until.Add(time.Second))
+	if revocations.IsRevoked(principal.TokenID) {
+		t.Error("revocation kept after the token stopped being accepted")
+	}
+}
@@ -1,73 +1,82 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"runtime/debug"
 
+	"fhir-api/fhir"
+	"fhir-api/models"
+
+	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
-type AppError struct {
-	Code       string `json:"code"`              
-	Message    string `json:"message"`           // Mensagem do erro (humano)
-	StatusCode int    `json:"statusCode"`        // Código HTTP
-	Details    string `json:"details,omitempty"` // Detalhes adicionais
-}
-
-// Error implementa a interface error padrão
-func (e *AppError) Error() string {
-	return e.Message
+// issueTypes associa o código de models.AppError ao código de issue FHIR (IssueType).
+var issueTypes = map[string]string{
+	"NOT_FOUND":              "not-found",
+	"INVALID_INPUT":          "invalid",
+	"INVALID_FIELD":          "value",
+	"INVALID_PARAM":          "invalid",
+	"INVALID_STATUS":         "code-invalid",
+	"INVALID_TRANSITION":     "business-rule",
+	"SCHEMA_VALIDATION":      "structure",
+	"PATCH_FAILED":           "processing",
+	"CONFLICT":               "conflict",
+	"PRECONDITION_FAILED":    "conflict",
+	"GONE":                   "deleted",
+	"UNSUPPORTED_MEDIA_TYPE": "not-supported",
+	"NOT_SUPPORTED":          "not-supported",
+	"UNAUTHORIZED":           "login",
+	"FORBIDDEN":              "forbidden",
+	"DATABASE_ERROR":         "exception",
+	"INTERNAL_ERROR":         "exception",
 }
 
-// LogError registra o erro no logger com contexto adicional
-func (e *AppError) LogError(logger *logrus.Logger, context map[string]interface{}) {
-	fields := logrus.Fields{
-		"errorCode":  e.Code,
-		"statusCode": e.StatusCode,
+// OperationOutcomeFromError converte um erro no status HTTP e no OperationOutcome
+// devolvidos ao cliente. Erros que não são *models.AppError viram INTERNAL_ERROR.
+func OperationOutcomeFromError(err error) (int, *fhir.OperationOutcome) {
+	var appErr *models.AppError
+	if !errors.As(err, &appErr) {
+		appErr = models.ErrInternalServer
 	}
 
-	// Adiciona contexto adicional se fornecido
-	for k, v := range context {
-		fields[k] = v
+	code, ok := issueTypes[appErr.Code]
+	if !ok {
+		code = "processing"
+		if appErr.StatusCode >= http.StatusInternalServerError {
+			code = "exception"
+		}
 	}
 
-	// Determina o nível de log baseado no status code
-	switch {
-	case e.StatusCode >= 500:
-		logger.WithFields(fields).Error(e.Message)
-	case e.StatusCode >= 400:
-		logger.WithFields(fields).Warn(e.Message)
-	default:
-		logger.WithFields(fields).Info(e.Message)
-	}
+	return appErr.StatusCode, fhir.NewOperationOutcome("error", code, appErr.Code, appErr.Message)
 }
 
-// NewAppError cria um novo erro da aplicação
-func NewAppError(code, message string, statusCode int) *AppError {
-	return &AppError{
-		Code:       code,
-		Message:    message,
-		StatusCode: statusCode,
-	}
+// AbortWithError interrompe a requisição respondendo o erro como OperationOutcome.
+func AbortWithError(c *gin.Context, err error) {
+	status, outcome := OperationOutcomeFromError(err)
+	c.AbortWithStatusJSON(status, outcome)
 }
 
-// NewAppErrorWithDetails cria um erro com detalhes adicionais
-func NewAppErrorWithDetails(code, message string, statusCode int, details string) *AppError {
-	return &AppError{
-		Code:       code,
-		Message:    message,
-		StatusCode: statusCode,
-		Details:    details,
-	}
+// Recovery substitui gin.Recovery, registrando o panic no logger da aplicação
+// e respondendo com um OperationOutcome de erro interno.
+func Recovery(logger *Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		LogInternalError(logger, fmt.Errorf("panic: %v", recovered), map[string]interface{}{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+			"stack":  string(debug.Stack()),
+		})
+		AbortWithError(c, models.ErrInternalServer)
+	})
 }
 
-// Erros pré-definidos
-var (
-	ErrInternalServer = NewAppError("INTERNAL_ERROR", "Erro interno do servidor", http.StatusInternalServerError)
-	ErrNotFound       = NewAppError("NOT_FOUND", "Recurso não encontrado", http.StatusNotFound)
-	ErrBadRequest     = NewAppError("BAD_REQUEST", "Requisição inválida", http.StatusBadRequest)
-	ErrUnauthorized   = NewAppError("UNAUTHORIZED", "Não autorizado", http.StatusUnauthorized)
-	ErrForbidden      = NewAppError("FORBIDDEN", "Acesso proibido", http.StatusForbidden)
-)
+// NoRoute responde rotas e métodos inexistentes com OperationOutcome.
+func NoRoute(c *gin.Context) {
+	AbortWithError(c, models.NewAppError("NOT_FOUND", "rota não encontrada: "+c.Request.Method+" "+c.Request.URL.Path, http.StatusNotFound))
+}
 
 // LogInternalError registra erros internos com stack trace
 func LogInternalError(logger *logrus.Logger, err error, context map[string]interface{}) {
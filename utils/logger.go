@@ -28,6 +28,11 @@ func GinLogger(logger *Logger) gin.HandlerFunc {
 			"latency":    latency,
 			"time":       end.Format(time.RFC3339),
 		}
+		if principal := CurrentPrincipal(c); principal != nil {
+			fields["client_id"] = principal.ClientID
+			fields["sub"] = principal.Subject
+			fields["jti"] = principal.TokenID
+		}
 
 		entry := logger.WithFields(fields)
 
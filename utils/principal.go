@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"fhir-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// SetPrincipal guarda no contexto do gin o cliente autenticado pelo AuthMiddleware.
+func SetPrincipal(c *gin.Context, principal *models.Principal) {
+	c.Set(principalKey, principal)
+}
+
+// CurrentPrincipal devolve o cliente autenticado da requisição, ou nil em rotas
+// públicas.
+func CurrentPrincipal(c *gin.Context) *models.Principal {
+	principal, _ := c.Get(principalKey)
+	p, _ := principal.(*models.Principal)
+	return p
+}